@@ -0,0 +1,456 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jweissig/polygon/calendar"
+)
+
+// bar types
+const (
+	barTime   = "time"   // a bar per time window
+	barTick   = "tick"   // a bar every N trades
+	barVolume = "volume" // a bar every N shares
+	barDollar = "dollar" // a bar every $N traded
+
+	barQuote    = "quote"    // a bar of NBBO quotes per time window
+	barCombined = "combined" // a time bar of trades and quotes side by side
+)
+
+// BarSpec - what closes a bar: the end of a time window, or a number of
+// trades, shares or dollars
+type BarSpec struct {
+	Type      string   // one of the bar* constants
+	Name      string   // as given on the command line, e.g. "5m" or "1000"
+	Interval  Interval // time, quote and combined bars
+	Threshold float64  // tick, volume and dollar bars, the first bar's size in trades for imbalance and run bars
+}
+
+// timed - does the bar close on the clock?
+func (s BarSpec) timed() bool {
+	return s.Type == barTime || s.Type == barQuote || s.Type == barCombined
+}
+
+// Interval - a bar size
+type Interval struct {
+	Name string        // as given on the command line, e.g. "5m"
+	D    time.Duration // bar length
+}
+
+// day - daily bars line up with New York midnight
+const day = 24 * time.Hour
+
+// parseIntervals - comma separated bar sizes: 100ms, 5s, 1m, 1h, 1d, ...
+func parseIntervals(s string) ([]Interval, error) {
+
+	var intervals []Interval
+	seen := map[time.Duration]bool{}
+
+	for _, name := range strings.Split(s, ",") {
+
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		var d time.Duration
+		if n := strings.TrimSuffix(name, "d"); n != name {
+			days, err := strconv.Atoi(n)
+			if err != nil {
+				return nil, fmt.Errorf("invalid interval %q", name)
+			}
+			d = time.Duration(days) * day
+		} else {
+			var err error
+			if d, err = time.ParseDuration(name); err != nil {
+				return nil, fmt.Errorf("invalid interval %q", name)
+			}
+		}
+
+		if d <= 0 {
+			return nil, fmt.Errorf("interval %q must be positive", name)
+		}
+		if d > day {
+			return nil, fmt.Errorf("interval %q is longer than a day", name)
+		}
+		if seen[d] {
+			continue
+		}
+		seen[d] = true
+
+		intervals = append(intervals, Interval{Name: name, D: d})
+	}
+
+	if len(intervals) == 0 {
+		return nil, fmt.Errorf("no intervals in %q", s)
+	}
+
+	return intervals, nil
+}
+
+// parseThresholds - comma separated trade, share or dollar counts for bars of type typ
+func parseThresholds(typ, s string) ([]BarSpec, error) {
+
+	var specs []BarSpec
+	seen := map[float64]bool{}
+
+	for _, name := range strings.Split(s, ",") {
+
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		n, err := strconv.ParseFloat(name, 64)
+		if err != nil || n <= 0 || math.IsInf(n, 0) {
+			return nil, fmt.Errorf("invalid %v bar size %q", typ, name)
+		}
+		if typ != barDollar && n != math.Trunc(n) {
+			return nil, fmt.Errorf("%v bar size %q must be a whole number", typ, name)
+		}
+		if seen[n] {
+			continue
+		}
+		seen[n] = true
+
+		specs = append(specs, BarSpec{Type: typ, Name: name, Threshold: n})
+	}
+
+	return specs, nil
+}
+
+// floor - start (Unix NS) of the bar the timestamp ts falls in
+//
+// Anything under a day is floored straight on the nanosecond timestamp, so
+// intervals that divide an hour line up with the New York clock too (its UTC
+// offset is whole hours). Daily bars start at New York midnight so the post market session
+// doesn't spill over into the next UTC day.
+func (iv Interval) floor(ts int64) int64 {
+
+	d := int64(iv.D)
+	if iv.D < day {
+		return ts - mod(ts, d)
+	}
+
+	t := time.Unix(0, ts).In(calendar.NewYork)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, calendar.NewYork).UnixNano()
+}
+
+// start - start (Unix NS) of the time bar a timestamp ts on day d falls in
+func (iv Interval) start(d *nyDay, ts int64) int64 {
+
+	start := iv.floor(ts)
+
+	// bars are cut at session boundaries so each one has a single label, a
+	// 9am 1h bar becomes 9:00-9:30 pre and 9:30-10:00 regular
+	if iv.D < day {
+		if s := d.sessionStart(ts); s > start {
+			start = s
+		}
+	}
+
+	return start
+}
+
+// end - end (Unix NS) of the time bar starting at start on day d
+func (iv Interval) end(d *nyDay, start int64) int64 {
+
+	if iv.D >= day {
+		return d.end
+	}
+
+	end := iv.floor(start) + int64(iv.D)
+	if e := d.sessionEnd(start); e < end {
+		end = e
+	}
+
+	return end
+}
+
+// mod - modulo that stays positive for timestamps before 1970
+func mod(a, b int64) int64 {
+	m := a % b
+	if m < 0 {
+		m += b
+	}
+	return m
+}
+
+// barBuilder - builds bars of one kind and size from trades in time order
+type barBuilder struct {
+	spec       BarSpec
+	conditions Conditions // which trades update what, nil lets every trade update everything
+	qualified  Conditions // VWAP only from trades these rules count towards volume, nil for all trades
+	regular    bool       // drop trades outside the regular session
+	day        nyDay      // New York day and sessions of the latest trade
+	start      int64      // start of the open bar (Unix NS)
+	label      string     // session of the open bar
+	bar        Agg        // the open bar, empty when N == 0
+	last       priceRange // the open bar's trades that update the last sale
+	hl         priceRange // the open bar's trades that update the high/low
+	all        priceRange // every trade in the open bar, for when nothing above has a price
+	prev       float64    // last sale before the open bar
+	vwap       vwapSum    // the open bar's VWAP
+	dollars    float64    // the open bar's dollar volume
+	session    session    // running totals for the day
+	bars       []Agg      // finished bars
+	info       *infoBars  // imbalance and run bars
+	fill       string     // what to do with windows without trades, one of the fill* constants
+	gap        *gapFiller // the session grid, for time bars that fill empty windows
+}
+
+// newBarBuilder - bar builder for a bar spec
+func newBarBuilder(cfg *Config, spec BarSpec) *barBuilder {
+	b := &barBuilder{spec: spec, regular: cfg.RegularOnly, fill: cfg.Fill}
+	if spec.timed() && cfg.Fill != fillSkip {
+		b.gap = &gapFiller{iv: spec.Interval}
+	}
+	if cfg.ApplyConditions {
+		b.conditions = cfg.Conditions
+	}
+	if cfg.QualifiedVWAP {
+		b.qualified = cfg.Conditions
+	}
+	switch spec.Type {
+	case barTickImbalance, barVolumeImbalance, barTickRuns, barVolumeRuns:
+		b.info = newInfoBars(spec.Type, spec.Threshold, cfg.EWMASpan)
+	}
+	return b
+}
+
+// add - fold a trade into the open bar, closing it first if the trade is past its window
+func (b *barBuilder) add(t Trade) {
+
+	// official opens/closes and held trades don't update anything
+	rule := b.conditions.rule(t.C)
+	if !rule.HighLow && !rule.Last && !rule.Volume {
+		return
+	}
+
+	b.day.update(t.T)
+	label := b.day.label(t.T)
+	if b.regular && label != sessionRegular {
+		return
+	}
+
+	if b.spec.timed() && b.spec.Interval.D >= day && !b.regular {
+		label = sessionDay
+	}
+
+	// new bar; save prev bar
+	if b.bar.N > 0 && b.closes(t.T, label) {
+		b.flush()
+	}
+
+	// first tick in the bar
+	if b.bar.N == 0 {
+		b.start = b.startOf(t.T)
+		b.label = label
+		if b.gap != nil {
+			b.gap.fill(&b.day, b.start, b.empty)
+			b.gap.skip(b.start)
+		}
+	}
+
+	// prices, only from trades whose conditions allow it
+	if rule.Last {
+		b.last.add(t.P)
+	}
+	if rule.HighLow {
+		b.hl.add(t.P)
+	}
+	b.all.add(t.P)
+
+	b.bar.X = appendStringIfMissing(b.bar.X, t.X) // add exchange
+	b.bar.N++
+
+	// volume weighted prices for the bar and the day so far, bars never
+	// straddle New York midnight so the previous bar has its session numbers
+	b.session.roll(&b.day)
+	if rule.Volume {
+		if b.qualified == nil || b.qualified.updatesVolume(t.C) {
+			b.vwap.add(t.P, t.S)
+			b.session.vwap.add(t.P, t.S)
+		}
+		b.bar.V += t.S // add to volume for window
+		b.dollars += t.P * float64(t.S)
+		b.session.volume += t.S
+		b.session.dollars += t.P * float64(t.S)
+	}
+
+	// imbalance and run bars only count the volume of trades that count towards it
+	if b.info != nil {
+		v := t.S
+		if !rule.Volume {
+			v = 0
+		}
+		b.info.add(t.Sign, v)
+	}
+
+	// tick, volume, dollar, imbalance and run bars close as soon as they're
+	// full, imbalance and run bars learn what to expect next from bars that
+	// fill up (not ones cut short at a session boundary)
+	if b.full() {
+		if b.info != nil {
+			b.info.learn()
+		}
+		b.flush()
+	}
+}
+
+// startOf - start (Unix NS) of a bar opened by a trade at ts
+func (b *barBuilder) startOf(ts int64) int64 {
+
+	// tick, volume and dollar bars start with their first trade
+	if !b.spec.timed() {
+		return ts
+	}
+
+	return b.spec.Interval.start(&b.day, ts)
+}
+
+// closes - does a trade at ts (in session label) start a new bar?
+func (b *barBuilder) closes(ts int64, label string) bool {
+
+	if b.spec.timed() {
+		return b.startOf(ts) != b.start
+	}
+
+	// the rest never straddle a session or a day either
+	return label != b.label || b.session.day != b.day.start
+}
+
+// full - has the open bar reached its tick, volume or dollar threshold, or
+// its expected imbalance or run?
+func (b *barBuilder) full() bool {
+	if b.info != nil {
+		return b.info.full()
+	}
+	switch b.spec.Type {
+	case barTick:
+		return float64(b.bar.N) >= b.spec.Threshold
+	case barVolume:
+		return float64(b.bar.V) >= b.spec.Threshold
+	case barDollar:
+		return b.dollars >= b.spec.Threshold
+	}
+	return false
+}
+
+// flush - close the open bar, if any
+func (b *barBuilder) flush() {
+
+	if b.bar.N == 0 {
+		return
+	}
+
+	// bars are stamped with the start of their window
+	b.bar.T = b.start / int64(time.Millisecond)
+	b.bar.TAt = time.Unix(0, b.start).UTC()
+	b.bar.Session = b.label
+
+	// a bar of nothing but odd lots (say) has no last sale of its own, it
+	// opens and closes at the previous one like a consolidated tape would
+	switch {
+	case b.last.n > 0:
+		b.bar.O, b.bar.C = b.last.o, b.last.c
+		b.prev = b.last.c
+	case b.prev != 0:
+		b.bar.O, b.bar.C = b.prev, b.prev
+	default:
+		b.bar.O, b.bar.C = b.all.o, b.all.c
+	}
+	if b.hl.n > 0 {
+		b.bar.H, b.bar.L = b.hl.h, b.hl.l
+	} else {
+		b.bar.H, b.bar.L = math.Max(b.bar.O, b.bar.C), math.Min(b.bar.O, b.bar.C)
+	}
+
+	// the session numbers are as of the bar's last trade
+	b.bar.VW = b.vwap.value()
+	b.bar.DV = b.dollars
+	b.bar.A = b.session.vwap.value()
+	b.bar.AV = b.session.volume
+	b.bar.ADV = b.session.dollars
+
+	b.bars = append(b.bars, b.bar)
+	b.bar = Agg{}
+	b.last, b.hl, b.all = priceRange{}, priceRange{}, priceRange{}
+	b.vwap = vwapSum{}
+	b.dollars = 0
+	if b.info != nil {
+		b.info.reset()
+	}
+}
+
+// finish - close the open bar and fill in the rest of the day's windows
+func (b *barBuilder) finish() {
+	b.flush()
+	if b.gap != nil {
+		b.gap.finish(b.empty)
+	}
+}
+
+// empty - add a bar for a window without trades, the last sale carried
+// forward or NaN prices, depending on the fill policy
+func (b *barBuilder) empty(start int64) {
+
+	bar := Agg{
+		T:       start / int64(time.Millisecond),
+		TAt:     time.Unix(0, start).UTC(),
+		X:       []int{},
+		Session: b.gap.label(start, b.regular),
+		Filled:  true,
+	}
+
+	p := b.prev
+	if b.fill == fillNaN || p == 0 {
+		p = math.NaN()
+	}
+	bar.O, bar.H, bar.L, bar.C, bar.VW = p, p, p, p, p
+
+	// the day's running numbers haven't moved since the last bar, if it was today
+	if n := len(b.bars); n > 0 && b.bars[n-1].T*int64(time.Millisecond) >= b.gap.day.start {
+		last := b.bars[n-1]
+		bar.A, bar.AV, bar.ADV = last.A, last.AV, last.ADV
+	}
+
+	b.bars = append(b.bars, bar)
+}
+
+// priceRange - open, high, low and close of a run of prices
+type priceRange struct {
+	o, h, l, c float64
+	n          int
+}
+
+// add - fold in a price, zero prices are ignored
+func (r *priceRange) add(p float64) {
+
+	if p == 0 {
+		return
+	}
+
+	if r.n == 0 {
+		r.o, r.h, r.l = p, p, p
+	}
+
+	// we set this at every tick, but the last one will remain
+	r.c = p
+
+	// high
+	if r.h < p {
+		r.h = p
+	}
+
+	// low
+	if r.l > p {
+		r.l = p
+	}
+
+	r.n++
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jweissig/polygon/calendar"
+)
+
+// testConfig - a Config as the command line would build it, for building bars by hand
+func testConfig(t *testing.T, args ...string) *Config {
+	t.Helper()
+
+	cfg, err := parseFlags(append(args, "AAA-2022-12-23.gob.lz4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// ny - Unix NS of a New York wall clock time, "2006-01-02 15:04:05"
+func ny(t *testing.T, s string) int64 {
+	t.Helper()
+
+	ts, err := time.ParseInLocation("2006-01-02 15:04:05", s, calendar.NewYork)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts.UnixNano()
+}
+
+// ms - Unix MS of a New York wall clock time, as bars are stamped
+func ms(t *testing.T, s string) int64 {
+	t.Helper()
+	return ny(t, s) / int64(time.Millisecond)
+}
+
+// buildBars - feed trades to a builder for spec and return its bars
+func buildBars(cfg *Config, spec BarSpec, trades ...Trade) []Agg {
+	b := newBarBuilder(cfg, spec)
+	for _, t := range trades {
+		b.add(t)
+	}
+	b.finish()
+	return b.bars
+}
+
+// timeSpec - a time bar spec
+func timeSpec(t *testing.T, name string) BarSpec {
+	t.Helper()
+
+	intervals, err := parseIntervals(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return BarSpec{Type: barTime, Name: name, Interval: intervals[0]}
+}
+
+// near - floats equal to within rounding
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestConditionsUpdateBars(t *testing.T) {
+
+	trades := []Trade{
+		{T: ny(t, "2022-12-23 10:00:01"), P: 100, S: 100, X: 4},
+		{T: ny(t, "2022-12-23 10:00:02"), P: 105, S: 50, X: 4, C: []int{37}},      // odd lot, volume only
+		{T: ny(t, "2022-12-23 10:00:03"), P: 95, S: 200, X: 11, C: []int{32}},     // out of sequence, high/low and volume
+		{T: ny(t, "2022-12-23 10:00:04"), P: 101, S: 100, X: 12, C: []int{38}},    // corrected close, prices but no volume
+		{T: ny(t, "2022-12-23 10:00:05"), P: 200, S: 1000, X: 12, C: []int{16}},   // official open, dropped
+		{T: ny(t, "2022-12-23 10:01:10"), P: 110, S: 10, X: 4, C: []int{37}},      // a bar of nothing but odd lots
+		{T: ny(t, "2022-12-23 10:02:00"), P: 102, S: 100, X: 4, C: []int{14, 37}}, // unknown ids don't widen the rule
+	}
+
+	bars := buildBars(testConfig(t, "-interval", "1m"), timeSpec(t, "1m"), trades...)
+	if len(bars) != 3 {
+		t.Fatalf("got %v bars, want 3: %+v", len(bars), bars)
+	}
+
+	b := bars[0]
+	if b.O != 100 || b.C != 101 || b.H != 101 || b.L != 95 {
+		t.Errorf("10:00 OHLC %v %v %v %v, want 100 101 101 95", b.O, b.H, b.L, b.C)
+	}
+	if b.V != 350 || b.N != 4 {
+		t.Errorf("10:00 volume %v from %v trades, want 350 from 4", b.V, b.N)
+	}
+	if vw := (100*100 + 105*50 + 95*200) / 350.0; !near(b.VW, vw) || !near(b.DV, 350*vw) {
+		t.Errorf("10:00 VWAP %v, dollar volume %v, want %v and %v", b.VW, b.DV, vw, 350*vw)
+	}
+
+	// no last sale of its own, opens and closes at the previous one
+	b = bars[1]
+	if b.O != 101 || b.C != 101 || b.H != 101 || b.L != 101 || b.V != 10 || b.VW != 110 {
+		t.Errorf("10:01 odd lot bar %+v, want OHLC at 101 with 10 shares at 110", b)
+	}
+
+	b = bars[2]
+	if b.O != 101 || b.C != 101 || b.V != 100 || b.AV != 460 {
+		t.Errorf("10:02 bar %+v, want OHLC at 101, 100 shares and 460 for the day", b)
+	}
+
+	// without conditions every trade updates everything
+	bars = buildBars(testConfig(t, "-interval", "1m", "-apply-conditions=false"), timeSpec(t, "1m"), trades...)
+	if b := bars[0]; b.N != 5 || b.V != 1450 || b.O != 100 || b.H != 200 || b.L != 95 || b.C != 200 {
+		t.Errorf("10:00 bar without conditions %+v", b)
+	}
+}
+
+func TestFirstBarWithoutLastSale(t *testing.T) {
+
+	// nothing before to carry over, the bar is made of its own trades
+	bars := buildBars(testConfig(t, "-interval", "1m"), timeSpec(t, "1m"),
+		Trade{T: ny(t, "2022-12-23 10:00:01"), P: 100, S: 10, C: []int{37}},
+		Trade{T: ny(t, "2022-12-23 10:00:02"), P: 99, S: 10, C: []int{37}},
+	)
+	if len(bars) != 1 {
+		t.Fatalf("got %v bars, want 1", len(bars))
+	}
+	if b := bars[0]; b.O != 100 || b.C != 99 || b.H != 100 || b.L != 99 || b.V != 20 {
+		t.Errorf("bar %+v, want 100 100 99 99 with 20 shares", b)
+	}
+}
+
+// trades - n trades a second apart from New York time at, price p and size s
+func trades(t *testing.T, at string, n int, p float64, s int64) []Trade {
+	t.Helper()
+
+	start := ny(t, at)
+	out := make([]Trade, n)
+	for i := range out {
+		out[i] = Trade{T: start + int64(i)*int64(time.Second), P: p, S: s}
+	}
+	return out
+}
+
+func TestThresholdBars(t *testing.T) {
+
+	cfg := testConfig(t, "-ticks", "3")
+
+	// a bar every 3 trades, starting with its first trade
+	bars := buildBars(cfg, BarSpec{Type: barTick, Name: "3", Threshold: 3}, trades(t, "2022-12-23 10:00:00", 7, 10, 100)...)
+	if len(bars) != 3 || bars[0].N != 3 || bars[1].N != 3 || bars[2].N != 1 {
+		t.Fatalf("tick bars %+v, want 3, 3 and 1 trades", bars)
+	}
+	if bars[1].T != ms(t, "2022-12-23 10:00:03") {
+		t.Errorf("second tick bar starts %v, want 10:00:03", bars[1].TAt)
+	}
+
+	// the trade that fills a volume bar stays in it
+	var tr []Trade
+	for i, s := range []int64{60, 50, 30, 80, 5} {
+		tr = append(tr, Trade{T: ny(t, "2022-12-23 10:00:00") + int64(i)*int64(time.Second), P: 10, S: s})
+	}
+	bars = buildBars(cfg, BarSpec{Type: barVolume, Name: "100", Threshold: 100}, tr...)
+	if len(bars) != 3 || bars[0].V != 110 || bars[1].V != 110 || bars[2].V != 5 {
+		t.Errorf("volume bars %+v, want 110, 110 and 5 shares", bars)
+	}
+
+	// dollar bars
+	bars = buildBars(cfg, BarSpec{Type: barDollar, Name: "1000", Threshold: 1000}, tr...)
+	if len(bars) != 3 || bars[0].DV != 1100 || bars[0].N != 2 || bars[1].DV != 1100 || bars[2].DV != 50 {
+		t.Errorf("dollar bars %+v, want $1100, $1100 and $50", bars)
+	}
+
+	// trades that don't count towards volume don't fill volume bars
+	tr[1].C = []int{38}
+	bars = buildBars(cfg, BarSpec{Type: barVolume, Name: "100", Threshold: 100}, tr...)
+	if len(bars) != 2 || bars[0].V != 170 || bars[0].N != 4 {
+		t.Errorf("volume bars with a corrected close %+v, want 170 shares from 4 trades first", bars)
+	}
+}
+
+func TestThresholdBarsDontStraddle(t *testing.T) {
+
+	cfg := testConfig(t, "-ticks", "3")
+	spec := BarSpec{Type: barTick, Name: "3", Threshold: 3}
+
+	// two trades before the open, two after, two after the close and two the next morning
+	var tr []Trade
+	tr = append(tr, trades(t, "2022-12-22 09:29:58", 2, 10, 100)...)
+	tr = append(tr, trades(t, "2022-12-22 09:30:00", 2, 11, 100)...)
+	tr = append(tr, trades(t, "2022-12-22 15:59:59", 2, 12, 100)...)
+	tr = append(tr, trades(t, "2022-12-23 04:00:00", 2, 13, 100)...)
+
+	bars := buildBars(cfg, spec, tr...)
+	want := []struct {
+		start, session string
+		n              int64
+	}{
+		{"2022-12-22 09:29:58", sessionPre, 2},
+		{"2022-12-22 09:30:00", sessionRegular, 3},
+		{"2022-12-22 16:00:00", sessionPost, 1},
+		{"2022-12-23 04:00:00", sessionPre, 2},
+	}
+	if len(bars) != len(want) {
+		t.Fatalf("got %v tick bars, want %v: %+v", len(bars), len(want), bars)
+	}
+	for i, w := range want {
+		if b := bars[i]; b.T != ms(t, w.start) || b.Session != w.session || b.N != w.n {
+			t.Errorf("tick bar %v: %v %v with %v trades, want %v %v with %v", i, b.TAt, b.Session, b.N, w.start, w.session, w.n)
+		}
+	}
+
+	// the session totals start over the next day
+	if b := bars[3]; b.AV != 200 || b.A != 13 {
+		t.Errorf("next day's bar has session volume %v at %v, want 200 at 13", b.AV, b.A)
+	}
+}
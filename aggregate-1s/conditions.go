@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// ConditionRule - what a trade with a given condition updates in a consolidated bar
+type ConditionRule struct {
+	HighLow bool // updates the high and low
+	Last    bool // updates the open/close (last sale)
+	Volume  bool // counts towards volume
+}
+
+// Conditions - update rules by condition id, ids missing from the table update everything
+type Conditions map[int]ConditionRule
+
+// defaultConditions - the SIP's consolidated update rules for stock trade
+// conditions, as served by https://api.polygon.io/v3/reference/conditions
+var defaultConditions = Conditions{
+	2:  {HighLow: false, Last: false, Volume: true},  // Average Price Trade
+	5:  {HighLow: true, Last: false, Volume: true},   // Bunched Sold Trade
+	7:  {HighLow: false, Last: false, Volume: true},  // Cash Sale
+	10: {HighLow: true, Last: false, Volume: true},   // Derivatively Priced
+	12: {HighLow: false, Last: false, Volume: true},  // Form T / Extended Hours
+	13: {HighLow: false, Last: false, Volume: true},  // Extended Trading Hours (Sold Out of Sequence)
+	15: {HighLow: false, Last: false, Volume: false}, // Market Center Official Close
+	16: {HighLow: false, Last: false, Volume: false}, // Market Center Official Open
+	20: {HighLow: false, Last: false, Volume: true},  // Next Day
+	21: {HighLow: false, Last: false, Volume: true},  // Price Variation Trade
+	22: {HighLow: true, Last: false, Volume: true},   // Prior Reference Price
+	29: {HighLow: false, Last: false, Volume: true},  // Seller
+	32: {HighLow: true, Last: false, Volume: true},   // Sold (Out of Sequence)
+	33: {HighLow: true, Last: false, Volume: true},   // Sold (Out of Sequence) and Stopped Stock
+	37: {HighLow: false, Last: false, Volume: true},  // Odd Lot Trade
+	38: {HighLow: true, Last: true, Volume: false},   // Corrected Consolidated Close (per listing market)
+	40: {HighLow: false, Last: false, Volume: false}, // Held
+	52: {HighLow: false, Last: false, Volume: true},  // Contingent Trade
+	53: {HighLow: false, Last: false, Volume: true},  // Qualified Contingent Trade
+}
+
+// rule - combined rule for a trade's conditions, a trade only updates
+// something if every one of its conditions allows it
+func (c Conditions) rule(conditions []int) ConditionRule {
+	r := ConditionRule{HighLow: true, Last: true, Volume: true}
+	for _, id := range conditions {
+		if cr, ok := c[id]; ok {
+			r.HighLow = r.HighLow && cr.HighLow
+			r.Last = r.Last && cr.Last
+			r.Volume = r.Volume && cr.Volume
+		}
+	}
+	return r
+}
+
+// updatesVolume - does a trade with these conditions count towards consolidated volume?
+func (c Conditions) updatesVolume(conditions []int) bool {
+	return c.rule(conditions).Volume
+}
+
+// conditionsResponse - the bits of /v3/reference/conditions we need
+type conditionsResponse struct {
+	Results []struct {
+		ID          int      `json:"id"`
+		Name        string   `json:"name"`
+		AssetClass  string   `json:"asset_class"`
+		DataTypes   []string `json:"data_types"`
+		UpdateRules *struct {
+			Consolidated struct {
+				UpdatesHighLow   bool `json:"updates_high_low"`
+				UpdatesOpenClose bool `json:"updates_open_close"`
+				UpdatesVolume    bool `json:"updates_volume"`
+			} `json:"consolidated"`
+		} `json:"update_rules"`
+	} `json:"results"`
+}
+
+// loadConditions - read the consolidated update rules for stock trade
+// conditions from a saved /v3/reference/conditions response
+func loadConditions(path string) (Conditions, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp conditionsResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+
+	c := Conditions{}
+	for _, r := range resp.Results {
+
+		// quote conditions and other asset classes share ids with trade conditions
+		if r.UpdateRules == nil || (r.AssetClass != "" && r.AssetClass != "stocks") || !isTrade(r.DataTypes) {
+			continue
+		}
+
+		u := r.UpdateRules.Consolidated
+		c[r.ID] = ConditionRule{HighLow: u.UpdatesHighLow, Last: u.UpdatesOpenClose, Volume: u.UpdatesVolume}
+	}
+
+	if len(c) == 0 {
+		return nil, fmt.Errorf("%v: no stock trade conditions with update rules", path)
+	}
+
+	return c, nil
+}
+
+// isTrade - do the data types include trades? (no data types means it applies to everything)
+func isTrade(dataTypes []string) bool {
+	if len(dataTypes) == 0 {
+		return true
+	}
+	for _, t := range dataTypes {
+		if t == "trade" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestConditionRules(t *testing.T) {
+
+	for _, c := range []struct {
+		name       string
+		conditions []int
+		want       ConditionRule
+	}{
+		{"regular sale", nil, ConditionRule{HighLow: true, Last: true, Volume: true}},
+		{"not in the table", []int{99}, ConditionRule{HighLow: true, Last: true, Volume: true}},
+		{"odd lot", []int{37}, ConditionRule{Volume: true}},
+		{"out of sequence", []int{32}, ConditionRule{HighLow: true, Volume: true}},
+		{"corrected consolidated close", []int{38}, ConditionRule{HighLow: true, Last: true}},
+		{"official open", []int{16}, ConditionRule{}},
+		{"official close", []int{15}, ConditionRule{}},
+		{"odd lot out of sequence", []int{32, 37}, ConditionRule{Volume: true}},
+		{"intermarket sweep odd lot", []int{14, 37}, ConditionRule{Volume: true}},
+		{"corrected close odd lot", []int{38, 37}, ConditionRule{}},
+	} {
+		if got := defaultConditions.rule(c.conditions); got != c.want {
+			t.Errorf("%v %v: got %+v, want %+v", c.name, c.conditions, got, c.want)
+		}
+	}
+
+	// no table lets everything through
+	var none Conditions
+	if got := none.rule([]int{16}); got != (ConditionRule{HighLow: true, Last: true, Volume: true}) {
+		t.Errorf("nil conditions: got %+v", got)
+	}
+}
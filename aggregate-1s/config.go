@@ -0,0 +1,232 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jweissig/polygon/tqfile"
+)
+
+// Config - everything an aggregation run needs, built from flags
+type Config struct {
+	Input  string    // trades file to aggregate
+	Format string    // input format, one of the format* constants
+	Bars   []BarSpec // bar types and sizes, all built in one pass
+
+	Conditions      Conditions // trade condition update rules
+	ApplyConditions bool       // let conditions decide which trades update high/low, last and volume
+	QualifiedVWAP   bool       // VWAP only from trades that count towards consolidated volume
+	RegularOnly     bool       // only trades in the regular session (9:30 to the close, New York time)
+
+	Output       string // file or directory to write the bars to, stdout when empty
+	OutputFormat string // one of the output* constants
+	Symbol       string // symbol to partition a directory output by
+
+	Fill string // what to do with time bar windows without trades, one of the fill* constants
+
+	Signing  string // how imbalance and run bars sign trades, one of the sign* constants
+	EWMASpan int    // bars the imbalance and run bar expectations are averaged over
+}
+
+// parseFlags - parse the command line into a Config
+func parseFlags(args []string) (*Config, error) {
+
+	fs := flag.NewFlagSet("aggregate-1s", flag.ContinueOnError)
+
+	input := fs.String("input", "", "trades file: a downloader .gob.lz4, a /v3/trades JSON dump or a legacy short key JSON dump")
+	format := fs.String("format", formatAuto, "input format: auto, gob, v3 or legacy")
+	interval := fs.String("interval", "", "comma separated time bar sizes, e.g. 100ms,5s,1m,5m,1h,1d (default 1s unless other bars are asked for)")
+	quotes := fs.String("quotes", "", "comma separated quote bar sizes, NBBO midpoint and spread bars for downloader files, e.g. 1s,1m")
+	combined := fs.String("combined", "", "comma separated combined bar sizes, trade and quote bars side by side, e.g. 1m")
+	output := fs.String("output", "", "file to write the bars to, or a directory (ending in /) to write a file per bar type, size, symbol and date (default stdout)")
+	outputFormat := fs.String("output-format", outputJSON, "output format: json, jsonl, csv or parquet")
+	symbol := fs.String("symbol", "", "symbol to partition directory output by (default from the input file name)")
+	fill := fs.String("fill", fillSkip, "time, quote and combined bar windows without trades (or quotes) over the regular session: skip, ffill or nan")
+	ticks := fs.String("ticks", "", "comma separated tick bar sizes, a bar every N trades")
+	volume := fs.String("volume", "", "comma separated volume bar sizes, a bar every N shares")
+	dollars := fs.String("dollars", "", "comma separated dollar bar sizes, a bar every $N traded")
+	tickImbalance := fs.String("tick-imbalance", "", "comma separated tick imbalance bar sizes, the first bar's size in trades")
+	volumeImbalance := fs.String("volume-imbalance", "", "comma separated volume imbalance bar sizes, the first bar's size in trades")
+	tickRuns := fs.String("tick-runs", "", "comma separated tick run bar sizes, the first bar's size in trades")
+	volumeRuns := fs.String("volume-runs", "", "comma separated volume run bar sizes, the first bar's size in trades")
+	ewmaSpan := fs.Int("ewma-span", 20, "bars the expected imbalance and run lengths are averaged over")
+	signing := fs.String("signing", signAuto, "how to sign trades for imbalance and run bars: auto, tick or quote")
+	conditions := fs.String("conditions", "", "saved /v3/reference/conditions response to take the update rules from (default built in SIP rules)")
+	applyConditions := fs.Bool("apply-conditions", true, "only update high/low, open/close and volume from trades whose conditions allow it")
+	regularOnly := fs.Bool("regular-only", false, "only use trades from the regular session, 9:30 to the close New York time")
+	qualifiedVWAP := fs.Bool("vwap-qualified", false, "only use trades whose conditions count towards consolidated volume for the VWAP")
+	zstdDict := fs.String("zstd-dict", "", "zstd dictionary a downloader file was compressed with")
+
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: aggregate-1s [flags] [file]\n\n")
+		fmt.Fprintf(out, "Create aggregate bars from historical stock trades.\n\n")
+		fmt.Fprintf(out, "Examples:\n")
+		fmt.Fprintf(out, "  aggregate-1s -input /scratch/historical/2022-12-22/AMC-2022-12-22.gob.lz4\n")
+		fmt.Fprintf(out, "  aggregate-1s -format legacy AMC-2022-12-22.json\n")
+		fmt.Fprintf(out, "  aggregate-1s -interval 1s,1m,5m AMC-2022-12-22.gob.lz4\n")
+		fmt.Fprintf(out, "  aggregate-1s -interval 1m -ticks 1000 -volume 100000 -dollars 1e6 AMC-2022-12-22.gob.lz4\n")
+		fmt.Fprintf(out, "  aggregate-1s -quotes 1s -combined 1m AMC-2022-12-22.gob.lz4\n")
+		fmt.Fprintf(out, "  aggregate-1s -interval 1s,1m -output-format parquet -output bars/ AMC-2022-12-22.gob.lz4\n")
+		fmt.Fprintf(out, "  aggregate-1s -tick-imbalance 500 -volume-runs 500 -signing quote AMC-2022-12-22.gob.lz4\n\n")
+		fmt.Fprintf(out, "Flags:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{
+		Input:  *input,
+		Format: strings.ToLower(*format),
+
+		Conditions:      defaultConditions,
+		ApplyConditions: *applyConditions,
+		QualifiedVWAP:   *qualifiedVWAP,
+		RegularOnly:     *regularOnly,
+
+		Output:       *output,
+		OutputFormat: strings.ToLower(*outputFormat),
+		Symbol:       *symbol,
+
+		Fill: strings.ToLower(*fill),
+
+		Signing:  strings.ToLower(*signing),
+		EWMASpan: *ewmaSpan,
+	}
+
+	// the file can also be given as the only argument
+	switch {
+	case fs.NArg() == 1 && cfg.Input == "":
+		cfg.Input = fs.Arg(0)
+	case fs.NArg() > 0:
+		return nil, fmt.Errorf("unexpected arguments: %v", strings.Join(fs.Args(), " "))
+	}
+	if cfg.Input == "" {
+		return nil, fmt.Errorf("missing input: use -input or pass the file as an argument")
+	}
+
+	switch cfg.Format {
+	case formatAuto, formatGob, formatV3, formatLegacy:
+	default:
+		return nil, fmt.Errorf("-format must be auto, gob, v3 or legacy, got %q", *format)
+	}
+
+	if _, ok := outputExt[cfg.OutputFormat]; !ok {
+		return nil, fmt.Errorf("-output-format must be json, jsonl, csv or parquet, got %q", *outputFormat)
+	}
+	if cfg.partitioned() && cfg.Symbol == "" {
+		if cfg.Symbol = symbolOf(cfg.Input); cfg.Symbol == "" {
+			return nil, fmt.Errorf("can't tell the symbol from %v, use -symbol", cfg.Input)
+		}
+	}
+
+	switch cfg.Fill {
+	case fillSkip, fillForward, fillNaN:
+	default:
+		return nil, fmt.Errorf("-fill must be skip, ffill or nan, got %q", *fill)
+	}
+
+	switch cfg.Signing {
+	case signAuto, signTick, signQuote:
+	default:
+		return nil, fmt.Errorf("-signing must be auto, tick or quote, got %q", *signing)
+	}
+	if cfg.EWMASpan < 1 {
+		return nil, fmt.Errorf("-ewma-span must be at least 1, got %v", cfg.EWMASpan)
+	}
+
+	if *zstdDict != "" {
+		if err := tqfile.LoadDict(*zstdDict); err != nil {
+			return nil, fmt.Errorf("-zstd-dict: %w", err)
+		}
+	}
+	if *conditions != "" {
+		c, err := loadConditions(*conditions)
+		if err != nil {
+			return nil, fmt.Errorf("-conditions: %w", err)
+		}
+		cfg.Conditions = c
+	}
+
+	// time, quote and combined bars first, then the rest, in the order given
+	if *interval == "" && *quotes == "" && *combined == "" && *ticks == "" && *volume == "" && *dollars == "" &&
+		*tickImbalance == "" && *volumeImbalance == "" && *tickRuns == "" && *volumeRuns == "" {
+		*interval = "1s"
+	}
+	for _, f := range []struct{ name, typ, value string }{
+		{"interval", barTime, *interval},
+		{"quotes", barQuote, *quotes},
+		{"combined", barCombined, *combined},
+	} {
+		if f.value == "" {
+			continue
+		}
+		intervals, err := parseIntervals(f.value)
+		if err != nil {
+			return nil, fmt.Errorf("-%v: %w", f.name, err)
+		}
+		for _, iv := range intervals {
+			cfg.Bars = append(cfg.Bars, BarSpec{Type: f.typ, Name: iv.Name, Interval: iv})
+		}
+	}
+	for _, f := range []struct{ name, typ, value string }{
+		{"ticks", barTick, *ticks},
+		{"volume", barVolume, *volume},
+		{"dollars", barDollar, *dollars},
+		{"tick-imbalance", barTickImbalance, *tickImbalance},
+		{"volume-imbalance", barVolumeImbalance, *volumeImbalance},
+		{"tick-runs", barTickRuns, *tickRuns},
+		{"volume-runs", barVolumeRuns, *volumeRuns},
+	} {
+		specs, err := parseThresholds(f.typ, f.value)
+		if err != nil {
+			return nil, fmt.Errorf("-%v: %w", f.name, err)
+		}
+		cfg.Bars = append(cfg.Bars, specs...)
+	}
+
+	// only json holds more than one kind of bar in a file
+	if cfg.OutputFormat != outputJSON && !cfg.partitioned() && len(cfg.Bars) > 1 {
+		return nil, fmt.Errorf("-output-format %v holds one bar type and size, ask for one or give -output a directory", cfg.OutputFormat)
+	}
+
+	return cfg, nil
+}
+
+// signs - are there any bars that need signed trades?
+func (cfg *Config) signs() bool {
+	for _, spec := range cfg.Bars {
+		switch spec.Type {
+		case barTickImbalance, barVolumeImbalance, barTickRuns, barVolumeRuns:
+			return true
+		}
+	}
+	return false
+}
+
+// quotes - are there any bars that need quotes?
+func (cfg *Config) quotes() bool {
+	for _, spec := range cfg.Bars {
+		if spec.Type == barQuote || spec.Type == barCombined {
+			return true
+		}
+	}
+	return false
+}
+
+// partitioned - is the output a directory of files by symbol and date?
+func (cfg *Config) partitioned() bool {
+	if cfg.Output == "" {
+		return false
+	}
+	if strings.HasSuffix(cfg.Output, "/") || strings.HasSuffix(cfg.Output, string(filepath.Separator)) {
+		return true
+	}
+	fi, err := os.Stat(cfg.Output)
+	return err == nil && fi.IsDir()
+}
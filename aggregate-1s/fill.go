@@ -0,0 +1,65 @@
+package main
+
+// empty bar policies
+const (
+	fillSkip    = "skip"  // no bar for a window without trades (or quotes)
+	fillForward = "ffill" // carry the last close (or quote) forward with zero volume
+	fillNaN     = "nan"   // prices are NaN, volume zero
+)
+
+// gapFiller - walks the session grid of a time bar builder and hands out
+// the windows it skipped
+//
+// The grid is every window of the regular session, 9:30 to the close, of
+// each trading day in the input. Daily bars have a single window per day.
+type gapFiller struct {
+	iv   Interval
+	day  nyDay // the day being walked
+	next int64 // start (Unix NS) of the next window not accounted for
+	end  int64 // end of the day's grid, 0 for days without one
+}
+
+// fill - hand emit the windows on day d that start before until and have no bar
+func (g *gapFiller) fill(d *nyDay, until int64, emit func(start int64)) {
+
+	// a new day, finish off the last one first
+	if g.day.start != d.start {
+		g.finish(emit)
+		g.day = *d
+		g.next, g.end = 0, 0
+		switch {
+		case g.iv.D >= day:
+			g.next, g.end = d.start, d.end
+		case d.open:
+			g.next, g.end = d.regular[0], d.regular[1]
+		}
+	}
+
+	for g.next < until && g.next < g.end {
+		emit(g.next)
+		g.next = g.iv.end(&g.day, g.next)
+	}
+}
+
+// skip - a bar starting at start was built, the grid carries on after it
+func (g *gapFiller) skip(start int64) {
+	if start >= g.next {
+		g.next = g.iv.end(&g.day, start)
+	}
+}
+
+// finish - hand emit the rest of the day's windows
+func (g *gapFiller) finish(emit func(start int64)) {
+	for g.next < g.end {
+		emit(g.next)
+		g.next = g.iv.end(&g.day, g.next)
+	}
+}
+
+// label - session label of a filled window
+func (g *gapFiller) label(start int64, regular bool) string {
+	if g.iv.D >= day && !regular {
+		return sessionDay
+	}
+	return g.day.label(start)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFillNaN(t *testing.T) {
+
+	bars := buildBars(testConfig(t, "-interval", "1m", "-fill", "nan"), timeSpec(t, "1m"),
+		Trade{T: ny(t, "2022-12-23 08:00:00"), P: 10, S: 100},
+		Trade{T: ny(t, "2022-12-23 10:00:30"), P: 11, S: 100},
+	)
+
+	// the pre market bar, then every minute of the regular session
+	if len(bars) != 1+390 {
+		t.Fatalf("got %v bars, want 391", len(bars))
+	}
+	if b := bars[0]; b.Session != sessionPre || b.Filled {
+		t.Errorf("first bar %+v, want the pre market trade", b)
+	}
+	for i, b := range bars[1:] {
+		if want := ms(t, "2022-12-23 09:30:00") + int64(i)*60000; b.T != want || b.Session != sessionRegular {
+			t.Fatalf("regular bar %v at %v %v, want %v", i, b.TAt, b.Session, want)
+		}
+		if traded := b.T == ms(t, "2022-12-23 10:00:00"); b.Filled == traded {
+			t.Errorf("bar at %v filled %v", b.TAt, b.Filled)
+		}
+		if b.Filled && (!math.IsNaN(b.O) || !math.IsNaN(b.C) || !math.IsNaN(b.VW) || b.V != 0 || b.N != 0) {
+			t.Errorf("filled bar at %v: %+v", b.TAt, b)
+		}
+	}
+
+	// the day's running numbers carry on through the gaps
+	if b := bars[len(bars)-1]; b.AV != 200 || b.A != 10.5 {
+		t.Errorf("last bar session volume %v at %v, want 200 at 10.5", b.AV, b.A)
+	}
+}
+
+func TestFillEarlyClose(t *testing.T) {
+
+	bars := buildBars(testConfig(t, "-interval", "1m", "-fill", "nan"), timeSpec(t, "1m"),
+		Trade{T: ny(t, "2022-11-25 10:00:30"), P: 11, S: 100},
+		Trade{T: ny(t, "2022-11-25 13:30:00"), P: 12, S: 100},
+	)
+
+	// 9:30 to 1pm, then the post market trade
+	if len(bars) != 210+1 {
+		t.Fatalf("got %v bars, want 211", len(bars))
+	}
+	if b := bars[209]; b.T != ms(t, "2022-11-25 12:59:00") || b.Session != sessionRegular || !b.Filled {
+		t.Errorf("last regular bar %+v, want a filled 12:59", b)
+	}
+	if b := bars[210]; b.T != ms(t, "2022-11-25 13:30:00") || b.Session != sessionPost || b.Filled {
+		t.Errorf("post market bar %+v", b)
+	}
+
+	// the half hour bars end at 1pm too, 7 of them
+	bars = buildBars(testConfig(t, "-interval", "30m", "-fill", "nan"), timeSpec(t, "30m"),
+		Trade{T: ny(t, "2022-11-25 10:00:30"), P: 11, S: 100},
+	)
+	if len(bars) != 7 || bars[6].T != ms(t, "2022-11-25 12:30:00") {
+		t.Errorf("got %v 30m bars, want 7 ending with 12:30", len(bars))
+	}
+}
+
+func TestFillForward(t *testing.T) {
+
+	bars := buildBars(testConfig(t, "-interval", "1m", "-fill", "ffill"), timeSpec(t, "1m"),
+		Trade{T: ny(t, "2022-12-22 15:59:00"), P: 100, S: 100},
+		Trade{T: ny(t, "2022-12-23 10:00:00"), P: 101, S: 100},
+	)
+	if len(bars) != 2*390 {
+		t.Fatalf("got %v bars, want 780", len(bars))
+	}
+
+	// nothing to carry forward before the first trade
+	if b := bars[0]; !b.Filled || !math.IsNaN(b.C) {
+		t.Errorf("first bar %+v, want NaN", b)
+	}
+
+	// the next morning starts from the previous day's close, with a fresh session
+	b := bars[390]
+	if b.T != ms(t, "2022-12-23 09:30:00") || !b.Filled || b.O != 100 || b.H != 100 || b.L != 100 || b.C != 100 || b.V != 0 {
+		t.Errorf("first bar of the second day %+v, want 100 carried forward", b)
+	}
+	if b.AV != 0 || b.A != 0 {
+		t.Errorf("first bar of the second day has session volume %v at %v, want none", b.AV, b.A)
+	}
+	if b := bars[len(bars)-1]; !b.Filled || b.C != 101 || b.AV != 100 {
+		t.Errorf("last bar %+v, want 101 carried forward with 100 shares for the day", b)
+	}
+}
+
+func TestFillQuotes(t *testing.T) {
+
+	bars := buildQuoteBars(testConfig(t, "-quotes", "1m", "-fill", "ffill"), timeSpec(t, "1m"),
+		Quote{T: ny(t, "2022-12-23 09:45:10"), BP: 10, BS: 1, AP: 10.2, AS: 2},
+	)
+	if len(bars) != 390 {
+		t.Fatalf("got %v quote bars, want 390", len(bars))
+	}
+
+	// no quote in force before the first one, then it carries forward
+	if b := bars[14]; !b.Filled || !math.IsNaN(b.MC) || !math.IsNaN(b.SP) {
+		t.Errorf("9:44 bar %+v, want NaN", b)
+	}
+	if b := bars[15]; b.Filled || b.QN != 1 {
+		t.Errorf("9:45 bar %+v, want the quote", b)
+	}
+	if b := bars[16]; !b.Filled || b.MC != 10.1 || !near(b.SP, 0.2) || b.BS != 1 || b.AS != 2 {
+		t.Errorf("9:46 bar %+v, want the 9:45 quote carried forward", b)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import "math"
+
+// trade signing methods
+const (
+	signAuto  = "auto"  // quotes when the input has them, the tick rule otherwise
+	signTick  = "tick"  // tick rule only
+	signQuote = "quote" // quote rule (Lee-Ready), tick rule at the midpoint or with no quote
+)
+
+// tradeSigner - classifies trades as buyer (+1) or seller (-1) initiated
+//
+// The quote rule compares the price to the NBBO prevailing when the trade
+// printed: above the midpoint is a buy, below is a sell. The tick rule (used
+// at the midpoint, without quotes, or for everything with -signing tick)
+// compares it to the previous trade: an uptick is a buy, a downtick a sell
+// and an unchanged price keeps the previous sign.
+type tradeSigner struct {
+	quotes   bool    // use the quote rule when there's a quote
+	bid, ask float64 // prevailing NBBO, 0 before the first quote
+	last     float64 // previous trade price
+	sign     int     // previous trade sign
+}
+
+// quote - the NBBO changed
+func (s *tradeSigner) quote(q Quote) {
+	s.bid, s.ask = q.BP, q.AP
+}
+
+// classify - sign a trade
+func (s *tradeSigner) classify(t *Trade) {
+
+	if t.P == 0 {
+		return
+	}
+
+	// quote rule, skipped for locked/crossed or one sided markets
+	if s.quotes && s.bid > 0 && s.ask > s.bid {
+		mid := (s.bid + s.ask) / 2
+		switch {
+		case t.P > mid:
+			s.sign = 1
+		case t.P < mid:
+			s.sign = -1
+		default:
+			s.sign = s.tick(t.P)
+		}
+	} else {
+		s.sign = s.tick(t.P)
+	}
+
+	s.last = t.P
+	t.Sign = s.sign
+}
+
+// tick - tick rule sign for price p
+func (s *tradeSigner) tick(p float64) int {
+	switch {
+	case s.last == 0:
+		return 0
+	case p > s.last:
+		return 1
+	case p < s.last:
+		return -1
+	}
+	return s.sign
+}
+
+// information driven bar types, López de Prado's Advances in Financial Machine Learning ch. 2.3
+const (
+	barTickImbalance   = "tick-imbalance"   // close when the signed trade count runs past what's expected
+	barVolumeImbalance = "volume-imbalance" // close when the signed volume runs past what's expected
+	barTickRuns        = "tick-runs"        // close when the longer of the buy/sell trade counts runs past what's expected
+	barVolumeRuns      = "volume-runs"      // close when the larger of the buy/sell volumes runs past what's expected
+)
+
+// infoBars - imbalance/run state for one information driven bar builder
+//
+// Expectations are exponentially weighted moving averages over closed bars:
+// the number of trades per bar, the bar's imbalance per trade, the share of
+// buys and the mean buy and sell volumes. Until the first bar closes there's
+// nothing to expect, so it closes after the initial size like a plain tick
+// bar and seeds the averages.
+//
+// Unlike the book the imbalance is averaged as an absolute value: buying and
+// selling bars cancel out otherwise, the expected imbalance heads to zero and
+// the bars collapse into single trades.
+type infoBars struct {
+	kind    string
+	initial float64 // trades in the first bar
+	alpha   float64 // EWMA weight of the latest bar
+
+	// expectations, valid once bars > 0
+	bars     int
+	expT     float64 // trades per bar
+	expB     float64 // absolute imbalance per trade
+	expBuy   float64 // share of trades that are buys
+	expBuyV  float64 // volume per buy
+	expSellV float64 // volume per sell
+
+	// the open bar
+	n               int
+	signed, signedV float64 // sum of signs and of signed volume
+	nBuy, nSell     int     // buys and sells
+	buyV, sellV     float64 // buy and sell volume
+}
+
+// newInfoBars - imbalance/run state, span is the EWMA span in bars
+func newInfoBars(kind string, initial float64, span int) *infoBars {
+	return &infoBars{kind: kind, initial: initial, alpha: 2 / (float64(span) + 1)}
+}
+
+// add - fold in a signed trade of v shares
+func (ib *infoBars) add(sign int, v int64) {
+
+	ib.n++
+	b, vol := float64(sign), float64(v)
+
+	ib.signed += b
+	ib.signedV += b * vol
+	switch {
+	case sign > 0:
+		ib.nBuy++
+		ib.buyV += vol
+	case sign < 0:
+		ib.nSell++
+		ib.sellV += vol
+	}
+}
+
+// full - has the open bar's imbalance (or run) reached the expected one?
+func (ib *infoBars) full() bool {
+
+	if ib.bars == 0 {
+		return float64(ib.n) >= ib.initial
+	}
+
+	// a bar of unsigned (or perfectly balanced) trades has no imbalance or
+	// run to speak of, even when nothing much is expected
+	switch ib.kind {
+	case barTickImbalance:
+		return ib.signed != 0 && math.Abs(ib.signed) >= ib.expT*ib.expB
+	case barVolumeImbalance:
+		return ib.signedV != 0 && math.Abs(ib.signedV) >= ib.expT*ib.expB
+	case barTickRuns:
+		run := max(ib.nBuy, ib.nSell)
+		return run > 0 && float64(run) >= ib.expT*math.Max(ib.expBuy, 1-ib.expBuy)
+	case barVolumeRuns:
+		run := math.Max(ib.buyV, ib.sellV)
+		return run > 0 && run >= ib.expT*math.Max(ib.expBuy*ib.expBuyV, (1-ib.expBuy)*ib.expSellV)
+	}
+
+	return false
+}
+
+// learn - update the expectations from the open bar, which just filled up
+func (ib *infoBars) learn() {
+
+	if ib.n == 0 {
+		return
+	}
+
+	n := float64(ib.n)
+	b := math.Abs(ib.signed) / n
+	if ib.kind == barVolumeImbalance {
+		b = math.Abs(ib.signedV) / n
+	}
+	buy := float64(ib.nBuy) / n
+	buyV, sellV := ib.expBuyV, ib.expSellV
+	if ib.nBuy > 0 {
+		buyV = ib.buyV / float64(ib.nBuy)
+	}
+	if ib.nSell > 0 {
+		sellV = ib.sellV / float64(ib.nSell)
+	}
+
+	if ib.bars == 0 {
+		ib.expT, ib.expB, ib.expBuy, ib.expBuyV, ib.expSellV = n, b, buy, buyV, sellV
+	} else {
+		ib.expT = ewma(ib.expT, n, ib.alpha)
+		ib.expB = ewma(ib.expB, b, ib.alpha)
+		ib.expBuy = ewma(ib.expBuy, buy, ib.alpha)
+		ib.expBuyV = ewma(ib.expBuyV, buyV, ib.alpha)
+		ib.expSellV = ewma(ib.expSellV, sellV, ib.alpha)
+	}
+	ib.bars++
+}
+
+// reset - start a new bar, keeping the expectations
+func (ib *infoBars) reset() {
+	ib.n = 0
+	ib.nBuy, ib.nSell = 0, 0
+	ib.buyV, ib.sellV = 0, 0
+	ib.signed, ib.signedV = 0, 0
+}
+
+// ewma - exponentially weighted moving average step
+func ewma(prev, x, alpha float64) float64 {
+	return alpha*x + (1-alpha)*prev
+}
+
+// max - the larger of two ints
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
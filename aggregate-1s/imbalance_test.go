@@ -0,0 +1,154 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTickRule(t *testing.T) {
+
+	s := &tradeSigner{}
+	for i, c := range []struct {
+		p    float64
+		want int
+	}{
+		{10, 0},    // nothing to compare with
+		{10.1, 1},  // uptick
+		{10.1, 1},  // zero tick keeps the last sign
+		{10, -1},   // downtick
+		{10, -1},   // zero tick
+		{0, -1},    // no price, left alone
+		{10.05, 1}, // compared with the last priced trade
+	} {
+		tr := Trade{P: c.p, Sign: -1}
+		s.classify(&tr)
+		if tr.Sign != c.want {
+			t.Errorf("trade %v at %v: sign %v, want %v", i, c.p, tr.Sign, c.want)
+		}
+	}
+}
+
+func TestQuoteRule(t *testing.T) {
+
+	s := &tradeSigner{quotes: true}
+	for i, c := range []struct {
+		q    Quote // the NBBO changes to this before the trade, unless it's empty
+		p    float64
+		want int
+	}{
+		{Quote{}, 10, 0},                      // no quote yet, tick rule with nothing to compare
+		{Quote{BP: 9.9, AP: 10.1}, 10.05, 1},  // above the midpoint
+		{Quote{}, 9.95, -1},                   // below
+		{Quote{}, 10, 1},                      // at the midpoint, uptick from 9.95
+		{Quote{}, 10, 1},                      // at the midpoint, zero tick
+		{Quote{BP: 10.2, AP: 10.1}, 10.15, 1}, // crossed, uptick
+		{Quote{BP: 10, AP: 10}, 9.9, -1},      // locked, downtick
+		{Quote{BP: 10}, 9.8, -1},              // one sided, downtick
+		{Quote{BP: 9.9, AP: 10.1}, 10.5, 1},   // a two sided quote again
+		{Quote{}, 9.91, -1},                   // below the midpoint
+	} {
+		if c.q != (Quote{}) {
+			s.quote(c.q)
+		}
+		tr := Trade{P: c.p}
+		s.classify(&tr)
+		if tr.Sign != c.want {
+			t.Errorf("trade %v at %v: sign %v, want %v", i, c.p, tr.Sign, c.want)
+		}
+	}
+}
+
+// signed - a trade a second after the last one (or at 10:00) with a sign
+func signed(t *testing.T, tr []Trade, signs ...int) []Trade {
+	t.Helper()
+
+	at := ny(t, "2022-12-23 10:00:00")
+	if len(tr) > 0 {
+		at = tr[len(tr)-1].T + int64(time.Second)
+	}
+	for _, s := range signs {
+		tr = append(tr, Trade{T: at, P: 10, S: 100, Sign: s})
+		at += int64(time.Second)
+	}
+	return tr
+}
+
+func TestImbalanceBars(t *testing.T) {
+
+	// span 3 weighs the latest bar by half
+	cfg := testConfig(t, "-tick-imbalance", "4", "-ewma-span", "3")
+	spec := BarSpec{Type: barTickImbalance, Name: "4", Threshold: 4}
+
+	// the first bar closes after the initial 4 trades: expect 4 trades with an imbalance of 2/4 each
+	tr := signed(t, nil, 1, 1, 1, -1)
+
+	// |imbalance| reaches 4 * 0.5 = 2 after 6 trades: expect 0.5*6 + 0.5*4 = 5 trades
+	// with 0.5*(2/6) + 0.5*(2/4) = 5/12 each, so 2.08
+	tr = signed(t, tr, -1, 1, 1, -1, 1, 1)
+
+	// a selling bar closes on the absolute imbalance, at -3
+	tr = signed(t, tr, -1, -1, -1)
+
+	// unsigned trades never close a bar
+	tr = signed(t, tr, 0, 0, 0, 0, 0)
+
+	bars := buildBars(cfg, spec, tr...)
+	want := []int64{4, 6, 3, 5}
+	if len(bars) != len(want) {
+		t.Fatalf("got %v bars, want %v", len(bars), len(want))
+	}
+	for i, n := range want {
+		if bars[i].N != n {
+			t.Errorf("bar %v has %v trades, want %v", i, bars[i].N, n)
+		}
+	}
+
+	// with span 1 only the last bar counts: 3 trades at |3|/3 each, so an imbalance of 3
+	cfg = testConfig(t, "-tick-imbalance", "4", "-ewma-span", "1")
+	tr = signed(t, nil, 1, 1, 1, -1) // expect 4 * 0.5
+	tr = signed(t, tr, -1, -1)       // expect 2 * 1
+	tr = signed(t, tr, 1, -1, 1, 1)  // |2| after 4, expect 4 * 0.5
+	tr = signed(t, tr, -1, -1, 1)    // -2 closes, the last trade is left open
+	bars = buildBars(cfg, spec, tr...)
+	want = []int64{4, 2, 4, 2, 1}
+	if len(bars) != len(want) {
+		t.Fatalf("span 1: got %v bars, want %v", len(bars), len(want))
+	}
+	for i, n := range want {
+		if bars[i].N != n {
+			t.Errorf("span 1: bar %v has %v trades, want %v", i, bars[i].N, n)
+		}
+	}
+}
+
+func TestRunBars(t *testing.T) {
+
+	cfg := testConfig(t, "-tick-runs", "4", "-ewma-span", "1")
+	spec := BarSpec{Type: barTickRuns, Name: "4", Threshold: 4}
+
+	// 3 buys in 4 trades: expect a run of 4 * 0.75 = 3
+	tr := signed(t, nil, 1, 1, -1, 1)
+
+	// runs count buys and sells however they're interleaved, the third sell closes it
+	tr = signed(t, tr, 1, -1, -1, 1, -1, 1)
+
+	bars := buildBars(cfg, spec, tr...)
+	if len(bars) != 3 || bars[0].N != 4 || bars[1].N != 5 || bars[2].N != 1 {
+		t.Fatalf("tick run bars %+v, want 4, 5 and 1 trades", bars)
+	}
+
+	// volume runs weigh the run by size: 2 buys of 100 and 2 sells of 300
+	// expect 4 * max(0.5*100, 0.5*300) = 600 shares on one side
+	cfg = testConfig(t, "-volume-runs", "4", "-ewma-span", "1")
+	spec = BarSpec{Type: barVolumeRuns, Name: "4", Threshold: 4}
+	tr = signed(t, nil, 1, 1, -1, -1)
+	tr[2].S, tr[3].S = 300, 300
+	tr = signed(t, tr, -1, -1, -1, -1, -1, -1, 1)
+	for i := 4; i < len(tr); i++ {
+		tr[i].S = 100
+	}
+	bars = buildBars(cfg, spec, tr...)
+	if len(bars) != 3 || bars[0].N != 4 || bars[1].N != 6 || bars[2].N != 1 {
+		t.Fatalf("volume run bars %+v, want 4, 6 and 1 trades", bars)
+	}
+}
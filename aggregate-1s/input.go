@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/jweissig/polygon/polygon"
+	"github.com/jweissig/polygon/tqfile"
+)
+
+// input formats
+const (
+	formatAuto   = "auto"   // work it out from the file
+	formatGob    = "gob"    // the downloader's trades + quotes .gob.lz4
+	formatV3     = "v3"     // /v3/trades JSON (sip_timestamp, price, size, ...)
+	formatLegacy = "legacy" // old short key trades JSON (t, p, s, ...)
+)
+
+// lz4 frame magic number, first 4 bytes of a version 1 downloader file
+const lz4FrameMagic = 0x184D2204
+
+// Trade - one trade, whatever format it was read from
+type Trade struct {
+	T int64   // SIP timestamp (Unix NS)
+	P float64 // price
+	S int64   // size
+	X int     // exchange ID
+	C []int   // conditions
+	Z int     // tape
+	Q int     // sequence number, orders trades with the same timestamp
+
+	Sign int // +1 buyer initiated, -1 seller initiated, 0 unknown (see tradeSigner)
+}
+
+// Quote - one NBBO quote, only downloader files have these
+type Quote struct {
+	T  int64   // SIP timestamp (Unix NS)
+	BP float64 // bid price
+	BS int     // bid size (round lots)
+	BX int     // bid exchange ID
+	AP float64 // ask price
+	AS int     // ask size (round lots)
+	AX int     // ask exchange ID
+}
+
+// LegacyTrades - old short key trades dump
+type LegacyTrades struct {
+	Results []struct {
+		T int64   `json:"t"` // The nanosecond accuracy SIP Unix Timestamp. This is the timestamp of when the SIP received this message from the exchange which produced it.
+		Y int64   `json:"y"` // The nanosecond accuracy Participant/Exchange Unix Timestamp. This is the timestamp of when the quote was actually generated at the exchange.
+		Q int     `json:"q"` // The sequence number representing the sequence in which trade events happened. These are increasing and unique per ticker symbol, but will not always be sequential (e.g., 1, 2, 6, 9, 10, 11).
+		I string  `json:"i"` // The Trade ID which uniquely identifies a trade. These are unique per combination of ticker, exchange, and TRF. For example: A trade for AAPL executed on NYSE and a trade for AAPL executed on NASDAQ could potentially have the same Trade ID.
+		X int     `json:"x"` // The exchange ID. See Exchanges for Polygon.io's mapping of exchange IDs.
+		S int     `json:"s"` // The size of a trade (also known as volume) as a number of whole shares traded.
+		C []int   `json:"c"` // conditions
+		P float64 `json:"p"` // The price of the trade. This is the actual dollar value per whole share of this trade. A trade of 100 shares with a price of $2.00 would be worth a total dollar value of $200.00.
+		Z int     `json:"z"` // There are 3 tapes which define which exchange the ticker is listed on. These are integers in our objects which represent the letter of the alphabet. Eg: 1 = A, 2 = B, 3 = C.
+	} `json:"results"`
+}
+
+// V3Trades - /v3/trades response (or several pages of results concatenated)
+type V3Trades struct {
+	Results []polygon.Trade `json:"results"`
+}
+
+// readInput - call onTrade with every trade in path in time order, and onQuote
+// with the quotes in between if the file has any and onQuote isn't nil
+//
+// The downloader's files are already sorted and are streamed a batch at a
+// time. JSON dumps are loaded whole and sorted by timestamp then sequence
+// number, since polygon hands them out newest first by default.
+func readInput(path, format string, onTrade func(Trade) error, onQuote func(Quote) error) error {
+
+	if format == formatAuto {
+		var err error
+		format, err = detectFormat(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch format {
+	case formatGob:
+		return readGob(path, onTrade, onQuote)
+	case formatV3, formatLegacy:
+		return readJSON(path, format, onTrade)
+	}
+
+	return fmt.Errorf("unknown input format %q", format)
+}
+
+// detectFormat - gob if it's a tqfile (or, for old files, lz4 compressed), v3 or legacy by the JSON keys
+func detectFormat(path string) (string, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	head := make([]byte, 4096)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	head = head[:n]
+
+	if len(head) >= 4 && (string(head[:4]) == tqfile.Magic || binary.LittleEndian.Uint32(head) == lz4FrameMagic) {
+		return formatGob, nil
+	}
+
+	switch {
+	case bytes.Contains(head, []byte(`"sip_timestamp"`)):
+		return formatV3, nil
+	case bytes.Contains(head, []byte(`"t"`)):
+		return formatLegacy, nil
+	}
+
+	return "", fmt.Errorf("%v: can't tell the input format, use -format", path)
+}
+
+// readGob - stream the trades (and quotes) out of a downloader file
+func readGob(path string, onTrade func(Trade) error, onQuote func(Quote) error) error {
+
+	r, err := tqfile.Open(path)
+	if err != nil {
+		return fmt.Errorf("%v: %w", path, err)
+	}
+	defer r.Close()
+
+	filter := tqfile.Filter{Events: tqfile.Trade}
+	if onQuote != nil {
+		filter.Events = ""
+	}
+
+	it := r.Iter(filter)
+	for it.Next() {
+
+		e := it.Event()
+
+		var err error
+		if t := e.Trade; t != nil {
+			err = onTrade(Trade{T: t.SipTimestamp, P: t.Price, S: t.Size, X: t.Exchange, C: t.Conditions, Z: t.Tape, Q: t.SequenceNumber})
+		} else {
+			q := e.Quote
+			err = onQuote(Quote{T: q.SipTimestamp, BP: q.BidPrice, BS: q.BidSize, BX: q.BidExchange, AP: q.AskPrice, AS: q.AskSize, AX: q.AskExchange})
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := it.Err(); err != nil {
+		return fmt.Errorf("%v: %w", path, err)
+	}
+	return nil
+}
+
+// readJSON - load a v3 or legacy JSON dump, either a response object with
+// "results" or a bare array of results
+func readJSON(path, format string, fn func(Trade) error) error {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(bufio.NewReader(f))
+	if err != nil {
+		return err
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return fmt.Errorf("%v: empty file", path)
+	}
+
+	// wrap a bare array so both shapes decode the same way
+	if data[0] == '[' {
+		data = append(append([]byte(`{"results":`), data...), '}')
+	}
+
+	var trades []Trade
+
+	switch format {
+	case formatV3:
+		var v V3Trades
+		if err := json.Unmarshal(data, &v); err != nil {
+			return fmt.Errorf("%v: %w", path, err)
+		}
+		trades = make([]Trade, len(v.Results))
+		for i, t := range v.Results {
+			trades[i] = Trade{T: t.SipTimestamp, P: t.Price, S: t.Size, X: t.Exchange, C: t.Conditions, Z: t.Tape, Q: t.SequenceNumber}
+		}
+	case formatLegacy:
+		var v LegacyTrades
+		if err := json.Unmarshal(data, &v); err != nil {
+			return fmt.Errorf("%v: %w", path, err)
+		}
+		trades = make([]Trade, len(v.Results))
+		for i, t := range v.Results {
+			trades[i] = Trade{T: t.T, P: t.P, S: int64(t.S), X: t.X, C: t.C, Z: t.Z, Q: t.Q}
+		}
+	}
+
+	if len(trades) > 0 && trades[0].T == 0 {
+		return fmt.Errorf("%v: trades have no timestamps, is it really %v? (use -format)", path, format)
+	}
+
+	sort.SliceStable(trades, func(i, j int) bool {
+		if trades[i].T != trades[j].T {
+			return trades[i].T < trades[j].T
+		}
+		return trades[i].Q < trades[j].Q
+	})
+
+	for _, t := range trades {
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jweissig/polygon/tqfile"
+	"github.com/pierrec/lz4"
+)
+
+// inputTrades - what every fixture below holds, in time order; the JSON
+// dumps list them newest first like polygon does
+var inputTrades = []Trade{
+	{T: 1671805800000000000, P: 131.5, S: 100, X: 4, C: []int{12}, Z: 3, Q: 7},
+	{T: 1671805800000000000, P: 131.51, S: 5, X: 11, Z: 3, Q: 8},
+	{T: 1671805801500000000, P: 131.49, S: 200, X: 4, C: []int{14, 41}, Z: 3, Q: 12},
+}
+
+// writeInput - data in a file in a temp dir, its path
+func writeInput(t *testing.T, name string, data []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readTrades - every trade (and the number of quotes) readInput finds in path
+func readTrades(path, format string) ([]Trade, int, error) {
+
+	var trades []Trade
+	var quotes int
+	err := readInput(path, format, func(t Trade) error {
+		trades = append(trades, t)
+		return nil
+	}, func(Quote) error {
+		quotes++
+		return nil
+	})
+	return trades, quotes, err
+}
+
+const (
+	v3Results = `[
+		{"sip_timestamp":1671805801500000000,"price":131.49,"size":200,"exchange":4,"conditions":[14,41],"tape":3,"sequence_number":12,"id":"3"},
+		{"sip_timestamp":1671805800000000000,"price":131.51,"size":5,"exchange":11,"tape":3,"sequence_number":8,"id":"2"},
+		{"sip_timestamp":1671805800000000000,"price":131.5,"size":100,"exchange":4,"conditions":[12],"tape":3,"sequence_number":7,"id":"1"}
+	]`
+	legacyResults = `[
+		{"t":1671805801500000000,"p":131.49,"s":200,"x":4,"c":[14,41],"z":3,"q":12,"i":"3"},
+		{"t":1671805800000000000,"p":131.51,"s":5,"x":11,"z":3,"q":8,"i":"2"},
+		{"t":1671805800000000000,"p":131.5,"s":100,"x":4,"c":[12],"z":3,"q":7,"i":"1"}
+	]`
+)
+
+func TestReadJSON(t *testing.T) {
+
+	for _, c := range []struct {
+		name   string
+		data   string
+		format string
+	}{
+		{"v3 response", `{"status":"OK","request_id":"x","results":` + v3Results + `}`, formatV3},
+		{"v3 bare array", v3Results, formatV3},
+		{"legacy response", `{"results":` + legacyResults + `,"ticker":"AAPL"}`, formatLegacy},
+		{"legacy bare array", "\n  " + legacyResults + "\n", formatLegacy},
+	} {
+		path := writeInput(t, "trades.json", []byte(c.data))
+
+		format, err := detectFormat(path)
+		if err != nil || format != c.format {
+			t.Errorf("%v: detected %q, %v", c.name, format, err)
+		}
+
+		got, quotes, err := readTrades(path, formatAuto)
+		if err != nil {
+			t.Errorf("%v: %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, inputTrades) || quotes != 0 {
+			t.Errorf("%v: got %+v and %v quotes\nwant %+v", c.name, got, quotes, inputTrades)
+		}
+	}
+}
+
+func TestReadGob(t *testing.T) {
+
+	// the downloader's current files, with a quote in between the trades
+	path := filepath.Join(t.TempDir(), tqfile.FileName("AAPL", "2022-12-23"))
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := tqfile.NewWriter(f, tqfile.Header{Symbol: "AAPL", Date: "2022-12-23"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tr := range inputTrades {
+		rec := tqfile.TradeRecord{SipTimestamp: tr.T, Price: tr.P, Size: tr.S, Exchange: tr.X, Conditions: tr.C, Tape: tr.Z, SequenceNumber: tr.Q}
+		if err := w.Write(tqfile.Event{Trade: &rec}); err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 {
+			q := tqfile.QuoteRecord{SipTimestamp: tr.T + 1, BidPrice: 131.48, BidSize: 3, AskPrice: 131.52, AskSize: 2}
+			if err := w.Write(tqfile.Event{Quote: &q}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// and version 1 files, a bare lz4 frame of gob encoded records
+	var records []tqfile.TradesQuotesCombined
+	for _, tr := range inputTrades {
+		records = append(records, tqfile.TradesQuotesCombined{EV: tqfile.Trade, Sym: "AAPL", T: tr.T, TP: tr.P, TS: tr.S, TX: tr.X, TC: tr.C, TZ: tr.Z, TQ: tr.Q})
+	}
+	var buf bytes.Buffer
+	zw := lz4.NewWriter(&buf)
+	if err := gob.NewEncoder(zw).Encode(records); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	v1 := writeInput(t, tqfile.FileName("AAPL", "2022-12-23"), buf.Bytes())
+
+	for _, c := range []struct {
+		name   string
+		path   string
+		quotes int
+	}{
+		{"current", path, 1},
+		{"version 1", v1, 0},
+	} {
+		if format, err := detectFormat(c.path); err != nil || format != formatGob {
+			t.Errorf("%v: detected %q, %v", c.name, format, err)
+		}
+
+		got, quotes, err := readTrades(c.path, formatAuto)
+		if err != nil {
+			t.Errorf("%v: %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, inputTrades) || quotes != c.quotes {
+			t.Errorf("%v: got %+v and %v quotes\nwant %+v and %v", c.name, got, quotes, inputTrades, c.quotes)
+		}
+	}
+
+	// without a quote callback the quotes are skipped
+	var n int
+	if err := readInput(path, formatGob, func(Trade) error { n++; return nil }, nil); err != nil || n != len(inputTrades) {
+		t.Errorf("trades only: %v trades, %v", n, err)
+	}
+}
+
+func TestUnrecognisedInput(t *testing.T) {
+
+	for _, c := range []struct {
+		name   string
+		data   string
+		format string
+		want   string
+	}{
+		{"empty", "", formatAuto, "can't tell the input format"},
+		{"text", "sym,t,p,s\nAAPL,1,2,3\n", formatAuto, "can't tell the input format"},
+		{"JSON without trades", `{"results":[],"status":"OK"}`, formatAuto, "can't tell the input format"},
+		{"unknown format", v3Results, "csv", "unknown input format"},
+		{"legacy read as v3", legacyResults, formatV3, "no timestamps"},
+		{"empty with a format", " \n", formatV3, "empty file"},
+		{"broken JSON", `{"results":[{"sip_timestamp":1,`, formatV3, "unexpected end"},
+	} {
+		path := writeInput(t, "trades.json", []byte(c.data))
+		if _, _, err := readTrades(path, c.format); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%v: got %v, want %q", c.name, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// marshalJSON - json.Marshal for bars, except NaN floats (from -fill nan)
+// are written as null instead of failing
+//
+// Only what bars are made of is handled: structs (embedded ones are
+// flattened), slices, interfaces and anything json.Marshal can do on its own.
+// Fields come out in the same order and with the same names as json.Marshal.
+func marshalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encodeJSON(&buf, reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// encodeJSON - write v to buf
+func encodeJSON(buf *bytes.Buffer, v reflect.Value) error {
+
+	switch {
+	case !v.IsValid():
+		buf.WriteString("null")
+		return nil
+	case v.Kind() == reflect.Float64 && math.IsNaN(v.Float()):
+		buf.WriteString("null")
+		return nil
+	case v.Kind() == reflect.Interface:
+		return encodeJSON(buf, v.Elem())
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Struct:
+		if v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+		buf.WriteByte('[')
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeJSON(buf, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+		return nil
+	case v.Kind() == reflect.Struct && !v.Type().Implements(marshalerType):
+		buf.WriteByte('{')
+		first := true
+		if err := encodeFields(buf, v, &first); err != nil {
+			return err
+		}
+		buf.WriteByte('}')
+		return nil
+	}
+
+	b, err := json.Marshal(v.Interface())
+	if err != nil {
+		return err
+	}
+	buf.Write(b)
+	return nil
+}
+
+// encodeFields - write the exported fields of struct v, and those of its embedded structs
+func encodeFields(buf *bytes.Buffer, v reflect.Value, first *bool) error {
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			if err := encodeFields(buf, v.Field(i), first); err != nil {
+				return err
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name, opts := f.Name, ""
+		if tag, ok := f.Tag.Lookup("json"); ok {
+			if tag == "-" {
+				continue
+			}
+			if i := strings.Index(tag, ","); i >= 0 {
+				tag, opts = tag[:i], tag[i:]
+			}
+			if tag != "" {
+				name = tag
+			}
+		}
+		if strings.Contains(opts, "omitempty") && v.Field(i).IsZero() {
+			continue
+		}
+
+		if !*first {
+			buf.WriteByte(',')
+		}
+		*first = false
+
+		key, _ := json.Marshal(name)
+		buf.Write(key)
+		buf.WriteByte(':')
+		if err := encodeJSON(buf, v.Field(i)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMarshalJSON(t *testing.T) {
+
+	at := time.Date(2022, 12, 23, 14, 30, 0, 0, time.UTC)
+	agg := Agg{V: 300, VW: 100.25, O: 100, C: 100.5, H: 101, L: 99.75, X: []int{4, 11}, N: 3, T: at.UnixNano() / int64(time.Millisecond), DV: 30075, A: 100.1, AV: 1200, ADV: 120120, TAt: at, Session: sessionRegular}
+	stats := QuoteStats{MO: 100.01, MH: 100.2, ML: 99.9, MC: 100.1, SP: 0.02, SPL: 0.01, SPH: 0.05, BS: 3.5, AS: 2.25, QN: 42}
+	quote := QuoteAgg{T: agg.T, QuoteStats: stats, TAt: at, Session: sessionRegular}
+	filled := agg
+	filled.X, filled.N, filled.V, filled.Filled = nil, 0, 0, true
+
+	for _, c := range []struct {
+		name string
+		v    interface{}
+	}{
+		{"trade bar", agg},
+		{"filled trade bar", filled},
+		{"quote bar", quote},
+		{"combined bar", CombinedAgg{Agg: agg, QuoteStats: stats}},
+		{"bars", AggData{Type: barTime, Interval: "1s", Aggregates: []Agg{agg, filled}}},
+		{"quote bars", AggData{Type: barQuote, Interval: "1s", Aggregates: []QuoteAgg{quote}}},
+		{"combined bars", AggData{Type: barCombined, Interval: "1s", Aggregates: []CombinedAgg{{Agg: agg, QuoteStats: stats}}}},
+		{"no bars", AggData{Type: barTime, Interval: "1s", Aggregates: []Agg(nil)}},
+	} {
+		want, err := json.Marshal(c.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := marshalJSON(c.v)
+		if err != nil {
+			t.Errorf("%v: %v", c.name, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%v:\n got %s\nwant %s", c.name, got, want)
+		}
+	}
+}
+
+func TestMarshalJSONNaN(t *testing.T) {
+
+	// -fill nan bars have NaN prices and VWAPs, which json.Marshal refuses
+	nan := math.NaN()
+	agg := Agg{VW: nan, O: nan, C: nan, H: nan, L: nan, T: 1671805800000, A: 100.1, AV: 1200, ADV: 120120, Session: sessionRegular, Filled: true}
+	quote := QuoteAgg{T: 1671805800000, QuoteStats: QuoteStats{MO: nan, MH: nan, ML: nan, MC: nan, SP: nan, SPL: nan, SPH: nan, BS: nan, AS: nan}, Session: sessionRegular, Filled: true}
+
+	for _, c := range []struct {
+		name  string
+		v     interface{}
+		nulls []string
+	}{
+		{"trade bar", agg, []string{"vw", "o", "c", "h", "l"}},
+		{"quote bar", quote, []string{"mo", "mh", "ml", "mc", "sp", "spl", "sph", "bs", "as"}},
+		{"combined bar", CombinedAgg{Agg: agg, QuoteStats: quote.QuoteStats}, []string{"vw", "o", "c", "h", "l", "mo", "mh", "ml", "mc", "sp", "spl", "sph", "bs", "as"}},
+	} {
+		if _, err := json.Marshal(c.v); err == nil {
+			t.Fatalf("%v: json.Marshal took a NaN", c.name)
+		}
+		got, err := marshalJSON(c.v)
+		if err != nil {
+			t.Errorf("%v: %v", c.name, err)
+			continue
+		}
+
+		var m map[string]interface{}
+		if err := json.Unmarshal(got, &m); err != nil {
+			t.Errorf("%v: %v in %s", c.name, err, got)
+			continue
+		}
+
+		// NaNs come out as null, everything else as json.Marshal has it
+		// with the NaNs zeroed
+		want := map[string]interface{}{}
+		zeroed := reflect.New(reflect.TypeOf(c.v)).Elem()
+		zeroed.Set(reflect.ValueOf(c.v))
+		zeroNaNs(zeroed)
+		b, err := json.Marshal(zeroed.Interface())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(b, &want); err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range c.nulls {
+			want[k] = nil
+		}
+		if !reflect.DeepEqual(m, want) {
+			t.Errorf("%v:\n got %s\nwant %v", c.name, got, want)
+		}
+	}
+}
+
+// zeroNaNs - set the NaN floats in struct v (and its embedded structs) to 0
+func zeroNaNs(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		switch f := v.Field(i); f.Kind() {
+		case reflect.Struct:
+			zeroNaNs(f)
+		case reflect.Float64:
+			if math.IsNaN(f.Float()) {
+				f.SetFloat(0)
+			}
+		}
+	}
+}
@@ -1,204 +1,140 @@
 package main
 
 import (
-	"encoding/json"
+	"flag"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"time"
 )
 
 // Agg -
 type Agg struct {
-	V   int64     `json:"v"`  // Tick Volume
-	VW  float64   `json:"vw"` // VWAP (Volume Weighted Average Price)
-	O   float64   `json:"o"`  // Tick Open Price
-	C   float64   `json:"c"`  // Tick Close Price
-	H   float64   `json:"h"`  // Tick High Price
-	L   float64   `json:"l"`  // Tick Low Price
-	X   []int     `json:"x"`  // exchanges
-	N   int64     `json:"n"`  // Number of Ticks
-	T   int64     `json:"t"`  // Timestamp ( Unix MS )
+	V   int64     `json:"v"`   // Tick Volume
+	VW  float64   `json:"vw"`  // VWAP (Volume Weighted Average Price)
+	O   float64   `json:"o"`   // Tick Open Price
+	C   float64   `json:"c"`   // Tick Close Price
+	H   float64   `json:"h"`   // Tick High Price
+	L   float64   `json:"l"`   // Tick Low Price
+	X   []int     `json:"x"`   // exchanges
+	N   int64     `json:"n"`   // Number of Ticks
+	T   int64     `json:"t"`   // Timestamp ( Unix MS ) of the start of the bar
+	DV  float64   `json:"dv"`  // Dollar Volume (sum of price * size)
+	A   float64   `json:"a"`   // Session VWAP as of the end of the bar
+	AV  int64     `json:"av"`  // Session Accumulated Volume
+	ADV float64   `json:"adv"` // Session Accumulated Dollar Volume
 	TAt time.Time // Timestamp ( Unix MS )
-}
 
-// AggData -
-type AggData struct {
-	Aggregates []struct {
-		V   int64     `json:"v"`  // Tick Volume
-		VW  float64   `json:"vw"` // VWAP (Volume Weighted Average Price)
-		O   float64   `json:"o"`  // Tick Open Price
-		C   float64   `json:"c"`  // Tick Close Price
-		H   float64   `json:"h"`  // Tick High Price
-		L   float64   `json:"l"`  // Tick Low Price
-		X   []int     `json:"x"`  // exchanges
-		N   int64     `json:"n"`  // Number of Ticks
-		T   int64     `json:"t"`  // Timestamp ( Unix MS )
-		TAt time.Time // Timestamp ( Unix MS )
-	} `json:"results"`
+	Session string `json:"session"`          // pre, regular or post (New York time), day for daily bars
+	Filled  bool   `json:"filled,omitempty"` // a window without trades, see -fill
 }
 
-// Trades - https://polygon.io/docs/stocks/get_v3_trades__stockticker
-type Trades struct {
-	Results []struct {
-		T int64   `json:"t"` // The nanosecond accuracy SIP Unix Timestamp. This is the timestamp of when the SIP received this message from the exchange which produced it.
-		Y int64   `json:"y"` // The nanosecond accuracy Participant/Exchange Unix Timestamp. This is the timestamp of when the quote was actually generated at the exchange.
-		Q int     `json:"q"` // The sequence number representing the sequence in which trade events happened. These are increasing and unique per ticker symbol, but will not always be sequential (e.g., 1, 2, 6, 9, 10, 11).
-		I string  `json:"i"` // The Trade ID which uniquely identifies a trade. These are unique per combination of ticker, exchange, and TRF. For example: A trade for AAPL executed on NYSE and a trade for AAPL executed on NASDAQ could potentially have the same Trade ID.
-		X int     `json:"x"` // The exchange ID. See Exchanges for Polygon.io's mapping of exchange IDs.
-		S int     `json:"s"` // The size of a trade (also known as volume) as a number of whole shares traded.
-		C []int   `json:"c"` // conditions
-		P float64 `json:"p"` // The price of the trade. This is the actual dollar value per whole share of this trade. A trade of 100 shares with a price of $2.00 would be worth a total dollar value of $200.00.
-		Z int     `json:"z"` // There are 3 tapes which define which exchange the ticker is listed on. These are integers in our objects which represent the letter of the alphabet. Eg: 1 = A, 2 = B, 3 = C.
-	} `json:"results"`
+// AggData - the bars of one type and size
+type AggData struct {
+	Type       string      `json:"type"`     // time, tick, volume, dollar, quote, combined, or one of the imbalance and run bar types
+	Interval   string      `json:"interval"` // bar size, e.g. "1s" or "1000"
+	Aggregates interface{} `json:"results"`  // []Agg, []QuoteAgg or []CombinedAgg
 }
 
 func main() {
 
-	var data Trades
-	var agg AggData
-
-	jsonFile, err := os.Open("AMC-2022-12-22.json")
-
+	cfg, err := parseFlags(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, "error:", err)
+		fmt.Fprintln(os.Stderr, "run with -help for usage")
+		os.Exit(2)
 	}
 
-	defer jsonFile.Close()
-
-	// read in data dump
-	byteValue, _ := ioutil.ReadAll(jsonFile)
-	json.Unmarshal(byteValue, &data)
-
-	// logic
-	// read in raw tick data
-	// loop through each tick tracking hour,min,sec
-	// if event in this second
-	//
-	// ticks volume
-	// open
-	// close
-	// high
-	// low
-	// timestamp unix
-	// time.Time
-
-	total := len(data.Results) // total results
-	var hour int               // record hour
-	var minute int             // record min
-	var second int             // record sec
-	var size int64             // volume
-	var open float64           // first record
-	var close float64          // last
-	var high float64           // sec high
-	var low float64            // sec low
-	var count int64
-	var exchanges []int // exchanges seen
-
-	for i := 0; i < total; i++ {
-
-		// parse time
-		t := data.Results[i].T / 1000000
-		tickTime := time.Unix(0, t*int64(time.Millisecond)) // time.Unix(0, msg.T)
-		//tickTime := time.Unix(0, int64(data.Results[i].T)) // time.Unix(0, msg.T)
-
-		// first tick - set our initial values
-		if i == 0 {
-
-			// timing
-			hour = tickTime.Hour()
-			minute = tickTime.Minute()
-			second = tickTime.Second()
-
-			// metadata
-			open = data.Results[i].P
-			close = data.Results[i].P
-			high = data.Results[i].P
-			low = data.Results[i].P
-			size = int64(data.Results[i].S) // init volume
-			exchanges = appendStringIfMissing(exchanges, data.Results[i].X)
-
-			count++
-
-			// next loop
-			continue
+	// one bar builder per bar type and size, all fed in the same pass over the trades (and quotes)
+	builders := make([]builder, len(cfg.Bars))
+	for i, spec := range cfg.Bars {
+		switch spec.Type {
+		case barQuote:
+			builders[i] = newQuoteBuilder(cfg, spec)
+		case barCombined:
+			builders[i] = &combinedBuilder{trades: newBarBuilder(cfg, spec), quotes: newQuoteBuilder(cfg, spec)}
+		default:
+			builders[i] = newBarBuilder(cfg, spec)
 		}
+	}
 
-		// are we still in the same time window (as the previose loop)?
-		if tickTime.Hour() == hour && tickTime.Minute() == minute && tickTime.Second() == second {
-			//if tickTime.Hour() == hour && tickTime.Minute() == minute {
-
-			// we set this at every loop, but the last one will remain
-			close = data.Results[i].P
-
-			// high
-			if high < data.Results[i].P && data.Results[i].P != 0 {
-				high = data.Results[i].P
-			}
-
-			// low
-			if low > data.Results[i].P && data.Results[i].P != 0 {
-				low = data.Results[i].P
+	// imbalance and run bars need signed trades, from the quotes in between
+	// them when the file has some and we're allowed to use them
+	signer := &tradeSigner{quotes: cfg.Signing != signTick && cfg.signs()}
+	var onQuote func(Quote) error
+	quotes := 0
+	if signer.quotes || cfg.quotes() {
+		onQuote = func(q Quote) error {
+			quotes++
+			signer.quote(q)
+			for _, b := range builders {
+				b.quote(q)
 			}
+			return nil
+		}
+	}
 
-			// add exchange
-			exchanges = appendStringIfMissing(exchanges, data.Results[i].X)
-
-			// same time window
-			size = size + int64(data.Results[i].S) // add to vomume for window
-
-			count++
-
-			// next loop
-			continue
-
-		} else {
-
-			// new time window; save prev record
-			var agm Agg
-			agm.V = size // Tick Size Volume
-			agm.O = open // Tick Open Price
-			//agm.C = data.Results[i-1].P // Tick Close Price (set to last tick price)
-			agm.C = close // Tick Close Price (set to last tick price)
-			agm.H = high  // Tick High Price
-			agm.L = low   // Tick Low Price
-			agm.N = count
-			agm.X = exchanges // set exchanges
-
-			// get last tick timestamp
-			ct := data.Results[i-1].T / 1000000
-			ctTime := time.Unix(0, ct*int64(time.Millisecond)) // time.Unix(0, msg.T)
-			agm.T = ct                                         // Timestamp ( Unix MS ) // remove 6 ditigs from the right
-			agm.TAt = ctTime
-
-			// append record
-			agg.Aggregates = append(agg.Aggregates, agm)
-			//fmt.Println(agm)
-
-			// reset timing
-			hour = tickTime.Hour()
-			minute = tickTime.Minute()
-			second = tickTime.Second()
-
-			// reset metadata
-			open = data.Results[i].P
-			close = data.Results[i].P
-			high = data.Results[i].P
-			low = data.Results[i].P
-			exchanges = nil
-			exchanges = appendStringIfMissing(exchanges, data.Results[i].X)
-			size = int64(data.Results[i].S) // init volume for window
-
-			count = 0
+	err = readInput(cfg.Input, cfg.Format, func(t Trade) error {
+		signer.classify(&t)
+		for _, b := range builders {
+			b.trade(t)
 		}
+		return nil
+	}, onQuote)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+	if cfg.quotes() && quotes == 0 {
+		fmt.Fprintln(os.Stderr, "warning: no quotes in", cfg.Input, "(only downloader files have them), quote bars are empty")
+	}
 
+	results := make([]AggData, len(builders))
+	for i, b := range builders {
+		results[i] = b.result()
 	}
+	if err := writeOutput(cfg, results); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+
+}
 
-	x, _ := json.Marshal(agg)
-	fmt.Println(string(x))
+// builder - builds one type and size of bar from the trades and quotes in time order
+type builder interface {
+	trade(t Trade)
+	quote(q Quote)
+	result() AggData // close the open bar and return the lot
+}
+
+func (b *barBuilder) trade(t Trade) { b.add(t) }
+func (b *barBuilder) quote(q Quote) {}
+func (b *barBuilder) result() AggData {
+	b.finish()
+	return AggData{Type: b.spec.Type, Interval: b.spec.Name, Aggregates: b.bars}
+}
 
-	//fmt.Println("done")
+func (b *quoteBuilder) trade(t Trade) {}
+func (b *quoteBuilder) quote(q Quote) { b.add(q) }
+func (b *quoteBuilder) result() AggData {
+	b.finish()
+	return AggData{Type: b.spec.Type, Interval: b.spec.Name, Aggregates: b.bars}
+}
+
+// combinedBuilder - trade and quote bars over the same windows, side by side
+type combinedBuilder struct {
+	trades *barBuilder
+	quotes *quoteBuilder
+}
 
+func (b *combinedBuilder) trade(t Trade) { b.trades.add(t) }
+func (b *combinedBuilder) quote(q Quote) { b.quotes.add(q) }
+func (b *combinedBuilder) result() AggData {
+	b.trades.finish()
+	b.quotes.finish()
+	return AggData{Type: barCombined, Interval: b.trades.spec.Name, Aggregates: combine(b.trades.bars, b.quotes.bars)}
 }
 
 func appendStringIfMissing(slice []int, i int) []int {
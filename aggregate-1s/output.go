@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jweissig/polygon/calendar"
+	"github.com/jweissig/polygon/tqfile"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// output formats
+const (
+	outputJSON    = "json"    // one line per bar type and size, {"type": ..., "interval": ..., "results": [...]}
+	outputJSONL   = "jsonl"   // one line per bar
+	outputCSV     = "csv"     // a header, then one row per bar
+	outputParquet = "parquet" // typed columns, one row per bar
+)
+
+// outputExt - file extension by output format
+var outputExt = map[string]string{
+	outputJSON:    ".json",
+	outputJSONL:   ".jsonl",
+	outputCSV:     ".csv",
+	outputParquet: ".parquet",
+}
+
+// parquetRowGroupSize - bytes of bars buffered per parquet row group
+const parquetRowGroupSize = 64 << 20
+
+// writeOutput - write every bar type and size to stdout, a file, or a
+// directory partitioned by symbol and New York date
+func writeOutput(cfg *Config, results []AggData) error {
+
+	if cfg.partitioned() {
+		for _, data := range results {
+			for _, part := range splitByDate(data) {
+				dir := filepath.Join(cfg.Output, "symbol="+cfg.Symbol, "date="+part.date)
+				if err := os.MkdirAll(dir, 0755); err != nil {
+					return err
+				}
+				path := filepath.Join(dir, data.Type+"_"+data.Interval+outputExt[cfg.OutputFormat])
+				if err := writeFile(path, cfg.OutputFormat, []AggData{part.data}); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	if cfg.Output == "" {
+		w := bufio.NewWriter(os.Stdout)
+		if err := writeBars(w, cfg.OutputFormat, results); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+
+	return writeFile(cfg.Output, cfg.OutputFormat, results)
+}
+
+// writeFile - write bars to path
+func writeFile(path, format string, results []AggData) error {
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	if err := writeBars(w, format, results); err != nil {
+		f.Close()
+		return fmt.Errorf("%v: %w", path, err)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// writeBars - write bars in format, only json can hold more than one bar type and size
+func writeBars(w io.Writer, format string, results []AggData) error {
+
+	if format == outputJSON {
+		for _, data := range results {
+			x, err := marshalJSON(data)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintln(w, string(x)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if len(results) != 1 {
+		return fmt.Errorf("%v holds one bar type and size, got %v", format, len(results))
+	}
+
+	rows := reflect.ValueOf(results[0].Aggregates)
+	switch format {
+	case outputJSONL:
+		return writeJSONL(w, rows)
+	case outputCSV:
+		return writeCSV(w, rows)
+	case outputParquet:
+		return writeParquet(w, rows)
+	}
+
+	return fmt.Errorf("unknown output format %q", format)
+}
+
+// writeJSONL - a line of JSON per bar
+func writeJSONL(w io.Writer, rows reflect.Value) error {
+	for i := 0; i < rows.Len(); i++ {
+		x, err := marshalJSON(rows.Index(i).Interface())
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintln(w, string(x)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCSV - a header with the JSON names, then a row per bar
+//
+// Floats are written in full (NaN for -fill nan), times as RFC 3339 and
+// exchanges as a JSON array.
+func writeCSV(w io.Writer, rows reflect.Value) error {
+
+	cols := columnsOf(rows.Type().Elem())
+	cw := csv.NewWriter(w)
+
+	record := make([]string, len(cols))
+	for i, c := range cols {
+		record[i] = c.name
+	}
+	if err := cw.Write(record); err != nil {
+		return err
+	}
+
+	for i := 0; i < rows.Len(); i++ {
+		row := rows.Index(i)
+		for j, c := range cols {
+			switch v := row.FieldByIndex(c.index).Interface().(type) {
+			case int64:
+				record[j] = strconv.FormatInt(v, 10)
+			case float64:
+				record[j] = strconv.FormatFloat(v, 'f', -1, 64)
+			case bool:
+				record[j] = strconv.FormatBool(v)
+			case string:
+				record[j] = v
+			case time.Time:
+				record[j] = v.UTC().Format(time.RFC3339Nano)
+			case []int:
+				if v == nil {
+					v = []int{}
+				}
+				x, _ := json.Marshal(v)
+				record[j] = string(x)
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeParquet - a row per bar, with typed columns named as in the JSON
+//
+// Times are millisecond timestamps, exchanges a list of ints, and floats
+// are nullable since NaN (from -fill nan) is written as null.
+func writeParquet(w io.Writer, rows reflect.Value) error {
+
+	cols := columnsOf(rows.Type().Elem())
+
+	pw, err := writer.NewJSONWriterFromWriter(parquetSchema(cols), w, 1)
+	if err != nil {
+		return err
+	}
+	pw.RowGroupSize = parquetRowGroupSize
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+
+	rec := make(map[string]interface{}, len(cols))
+	for i := 0; i < rows.Len(); i++ {
+		row := rows.Index(i)
+		for _, c := range cols {
+			switch v := row.FieldByIndex(c.index).Interface().(type) {
+			case float64:
+				if math.IsNaN(v) {
+					rec[c.name] = nil
+				} else {
+					rec[c.name] = v
+				}
+			case time.Time:
+				rec[c.name] = v.UnixNano() / int64(time.Millisecond)
+			case []int:
+				if v == nil {
+					v = []int{}
+				}
+				rec[c.name] = v
+			default:
+				rec[c.name] = v
+			}
+		}
+		x, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		if err := pw.Write(string(x)); err != nil {
+			return err
+		}
+	}
+
+	return pw.WriteStop()
+}
+
+// parquetSchema - parquet-go JSON schema for the columns
+func parquetSchema(cols []column) string {
+
+	type field struct {
+		Tag    string
+		Fields []field `json:",omitempty"`
+	}
+
+	root := field{Tag: "name=parquet_go_root, repetitiontype=REQUIRED"}
+	for _, c := range cols {
+		tag := "name=" + c.name + ", "
+		switch c.typ.Kind() {
+		case reflect.Int64:
+			root.Fields = append(root.Fields, field{Tag: tag + "type=INT64, repetitiontype=REQUIRED"})
+		case reflect.Float64:
+			root.Fields = append(root.Fields, field{Tag: tag + "type=DOUBLE, repetitiontype=OPTIONAL"})
+		case reflect.Bool:
+			root.Fields = append(root.Fields, field{Tag: tag + "type=BOOLEAN, repetitiontype=REQUIRED"})
+		case reflect.String:
+			root.Fields = append(root.Fields, field{Tag: tag + "type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=REQUIRED"})
+		case reflect.Struct: // time.Time
+			root.Fields = append(root.Fields, field{Tag: tag + "type=INT64, logicaltype=TIMESTAMP, logicaltype.isadjustedtoutc=true, logicaltype.unit=MILLIS, repetitiontype=REQUIRED"})
+		case reflect.Slice: // []int
+			root.Fields = append(root.Fields, field{
+				Tag:    tag + "type=LIST, repetitiontype=REQUIRED",
+				Fields: []field{{Tag: "name=element, type=INT32, repetitiontype=REQUIRED"}},
+			})
+		}
+	}
+
+	x, _ := json.Marshal(root)
+	return string(x)
+}
+
+// column - a bar field, by its JSON name
+type column struct {
+	name  string
+	index []int // for reflect.Value.FieldByIndex
+	typ   reflect.Type
+}
+
+// columnsOf - the fields of a bar struct in JSON order, embedded structs flattened
+func columnsOf(t reflect.Type) []column {
+
+	var cols []column
+	for i := 0; i < t.NumField(); i++ {
+
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			for _, c := range columnsOf(f.Type) {
+				c.index = append([]int{i}, c.index...)
+				cols = append(cols, c)
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := f.Name
+		if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag != "" {
+			name = tag
+		}
+		cols = append(cols, column{name: name, index: []int{i}, typ: f.Type})
+	}
+
+	return cols
+}
+
+// datePart - a day's worth of bars
+type datePart struct {
+	date string
+	data AggData
+}
+
+// splitByDate - bars split by the New York date they start on, bars are in time order
+func splitByDate(data AggData) []datePart {
+
+	rows := reflect.ValueOf(data.Aggregates)
+	if rows.Kind() != reflect.Slice {
+		return nil
+	}
+
+	var parts []datePart
+	start := 0
+	for i := 0; i < rows.Len(); i++ {
+
+		ms := rows.Index(i).FieldByName("T").Int()
+		date := time.Unix(0, ms*int64(time.Millisecond)).In(calendar.NewYork).Format("2006-01-02")
+		if len(parts) > 0 && date == parts[len(parts)-1].date {
+			continue
+		}
+
+		if len(parts) > 0 {
+			parts[len(parts)-1].data.Aggregates = rows.Slice(start, i).Interface()
+		}
+		parts = append(parts, datePart{date: date, data: AggData{Type: data.Type, Interval: data.Interval}})
+		start = i
+	}
+	if len(parts) > 0 {
+		parts[len(parts)-1].data.Aggregates = rows.Slice(start, rows.Len()).Interface()
+	}
+
+	return parts
+}
+
+// symbolOf - the symbol from an input file named <SYM>-<date>.gob.lz4 (or .json)
+func symbolOf(path string) string {
+
+	name := filepath.Base(path)
+	if !strings.HasSuffix(name, tqfile.Ext) {
+		name = strings.TrimSuffix(name, filepath.Ext(name)) + tqfile.Ext
+	}
+
+	symbol, _, ok := tqfile.ParseFileName(name)
+	if !ok {
+		return ""
+	}
+	return symbol
+}
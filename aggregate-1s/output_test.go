@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/csv"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+// testBars - a traded bar and a -fill nan bar on 2022-12-22, a traded bar on 2022-12-23
+func testBars(t *testing.T) AggData {
+	t.Helper()
+
+	nan := math.NaN()
+	bar := func(at string, p float64, v int64, x []int, filled bool) Agg {
+		ts := ny(t, at)
+		return Agg{
+			T: ts / int64(time.Millisecond), TAt: time.Unix(0, ts).UTC(),
+			O: p, H: p, L: p, C: p, VW: p, V: v, N: v / 100, X: x, DV: p * float64(v),
+			Session: sessionRegular, Filled: filled,
+		}
+	}
+
+	return AggData{Type: barTime, Interval: "1m", Aggregates: []Agg{
+		bar("2022-12-22 15:58:00", 10.5, 200, []int{4, 12}, false),
+		bar("2022-12-22 15:59:00", nan, 0, []int{}, true),
+		bar("2022-12-23 09:30:00", 11, 100, []int{11}, false),
+	}}
+}
+
+func TestWriteCSV(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "bars.csv")
+	if err := writeFile(path, outputCSV, []AggData{testBars(t)}); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{
+		{"v", "vw", "o", "c", "h", "l", "x", "n", "t", "dv", "a", "av", "adv", "TAt", "session", "filled"},
+		{"200", "10.5", "10.5", "10.5", "10.5", "10.5", "[4,12]", "2", "1671742680000", "2100", "0", "0", "0", "2022-12-22T20:58:00Z", "regular", "false"},
+		{"0", "NaN", "NaN", "NaN", "NaN", "NaN", "[]", "0", "1671742740000", "NaN", "0", "0", "0", "2022-12-22T20:59:00Z", "regular", "true"},
+		{"100", "11", "11", "11", "11", "11", "[11]", "1", "1671805800000", "1100", "0", "0", "0", "2022-12-23T14:30:00Z", "regular", "false"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("csv\n got %q\nwant %q", records, want)
+	}
+}
+
+// parquetBar - the columns of a parquet bar file the tests look at
+type parquetBar struct {
+	V       int64    `parquet:"name=v, type=INT64"`
+	O       *float64 `parquet:"name=o, type=DOUBLE, repetitiontype=OPTIONAL"`
+	C       *float64 `parquet:"name=c, type=DOUBLE, repetitiontype=OPTIONAL"`
+	X       []int32  `parquet:"name=x, type=LIST, valuetype=INT32"`
+	T       int64    `parquet:"name=t, type=INT64"`
+	TAt     int64    `parquet:"name=TAt, type=INT64"`
+	Session string   `parquet:"name=session, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Filled  bool     `parquet:"name=filled, type=BOOLEAN"`
+}
+
+func TestWriteParquet(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "bars.parquet")
+	if err := writeFile(path, outputParquet, []AggData{testBars(t)}); err != nil {
+		t.Fatal(err)
+	}
+
+	fr, err := local.NewLocalFileReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fr.Close()
+	pr, err := reader.NewParquetReader(fr, new(parquetBar), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+
+	rows := make([]parquetBar, pr.GetNumRows())
+	if err := pr.Read(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("got %v rows, want 3", len(rows))
+	}
+
+	// NaN is written as null, exchanges as a list and times in milliseconds
+	if r := rows[0]; r.O == nil || *r.O != 10.5 || r.V != 200 || !reflect.DeepEqual(r.X, []int32{4, 12}) || r.Filled {
+		t.Errorf("first row %+v", r)
+	}
+	if r := rows[1]; r.O != nil || r.C != nil || len(r.X) != 0 || !r.Filled {
+		t.Errorf("filled row %+v, want null prices and no exchanges", r)
+	}
+	if r := rows[2]; r.T != ms(t, "2022-12-23 09:30:00") || r.TAt != r.T || r.Session != sessionRegular {
+		t.Errorf("last row %+v", r)
+	}
+}
+
+func TestPartitionedOutput(t *testing.T) {
+
+	dir := t.TempDir()
+	cfg := testConfig(t, "-interval", "1m", "-output", dir+"/", "-output-format", "csv", "-symbol", "AAA")
+
+	quotes := AggData{Type: barQuote, Interval: "1m", Aggregates: []QuoteAgg{{T: ms(t, "2022-12-23 09:30:00")}}}
+	if err := writeOutput(cfg, []AggData{testBars(t), quotes}); err != nil {
+		t.Fatal(err)
+	}
+
+	var files []string
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+
+	want := []string{
+		"symbol=AAA/date=2022-12-22/time_1m.csv",
+		"symbol=AAA/date=2022-12-23/quote_1m.csv",
+		"symbol=AAA/date=2022-12-23/time_1m.csv",
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Fatalf("files\n got %v\nwant %v", files, want)
+	}
+
+	// each day's file has that day's bars
+	for _, c := range []struct {
+		file string
+		rows int
+	}{
+		{want[0], 2},
+		{want[2], 1},
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, c.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Count(string(data), "\n") - 1; n != c.rows {
+			t.Errorf("%v has %v bars, want %v", c.file, n, c.rows)
+		}
+	}
+}
+
+func TestSplitByDate(t *testing.T) {
+
+	// the post market runs past midnight UTC but not New York
+	bars := []Agg{
+		{T: ms(t, "2022-12-22 19:59:00")},
+		{T: ms(t, "2022-12-22 23:59:00")},
+		{T: ms(t, "2022-12-23 00:00:00")},
+	}
+	parts := splitByDate(AggData{Type: barTime, Interval: "1m", Aggregates: bars})
+	if len(parts) != 2 || parts[0].date != "2022-12-22" || parts[1].date != "2022-12-23" {
+		t.Fatalf("parts %+v", parts)
+	}
+	if n := len(parts[0].data.Aggregates.([]Agg)); n != 2 {
+		t.Errorf("2022-12-22 has %v bars, want 2", n)
+	}
+
+	if parts := splitByDate(AggData{Aggregates: []Agg{}}); len(parts) != 0 {
+		t.Errorf("no bars split into %v", parts)
+	}
+}
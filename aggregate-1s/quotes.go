@@ -0,0 +1,250 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// QuoteStats - what the NBBO did over a bar
+type QuoteStats struct {
+	MO  float64 `json:"mo"`  // Midpoint Open
+	MH  float64 `json:"mh"`  // Midpoint High
+	ML  float64 `json:"ml"`  // Midpoint Low
+	MC  float64 `json:"mc"`  // Midpoint Close
+	SP  float64 `json:"sp"`  // Time Weighted Average Spread
+	SPL float64 `json:"spl"` // Lowest Spread
+	SPH float64 `json:"sph"` // Highest Spread
+	BS  float64 `json:"bs"`  // Time Weighted Average Bid Size (round lots)
+	AS  float64 `json:"as"`  // Time Weighted Average Ask Size (round lots)
+	QN  int64   `json:"qn"`  // Number of Quotes
+}
+
+// QuoteAgg - a quote bar
+type QuoteAgg struct {
+	T int64 `json:"t"` // Timestamp ( Unix MS ) of the start of the bar
+	QuoteStats
+	TAt time.Time // Timestamp ( Unix MS )
+
+	Session string `json:"session"`          // pre, regular or post (New York time), day for daily bars
+	Filled  bool   `json:"filled,omitempty"` // a window without quotes, see -fill
+}
+
+// CombinedAgg - a trade bar and a quote bar over the same window
+type CombinedAgg struct {
+	Agg
+	QuoteStats
+}
+
+// quoteBuilder - builds time bars from NBBO quotes in time order
+//
+// A quote is in force from its timestamp until the next one, so the quote
+// in force when a bar starts counts towards it too, and the last one in a
+// bar counts until the bar ends.
+type quoteBuilder struct {
+	spec    BarSpec
+	regular bool       // only build bars in the regular session
+	day     nyDay      // New York day and sessions of the latest quote
+	start   int64      // start of the open bar (Unix NS)
+	end     int64      // end of the open bar (Unix NS)
+	label   string     // session of the open bar
+	n       int64      // quotes in the open bar
+	cur     Quote      // the quote in force, cleared at New York midnight
+	at      int64      // Unix NS cur has been weighed up to
+	mid     priceRange // the open bar's midpoints
+	spread  priceRange // the open bar's spreads, for the low and high
+	tw      [3]float64 // time weighted sums of spread, bid size and ask size
+	dur     int64      // time the open bar had a two sided quote (ns)
+	bars    []QuoteAgg // finished bars
+	fill    string     // what to do with windows without quotes, one of the fill* constants
+	gap     *gapFiller // the session grid, when filling empty windows
+}
+
+// newQuoteBuilder - quote bar builder for a time bar spec
+func newQuoteBuilder(cfg *Config, spec BarSpec) *quoteBuilder {
+	b := &quoteBuilder{spec: spec, regular: cfg.RegularOnly, fill: cfg.Fill}
+	if cfg.Fill != fillSkip {
+		b.gap = &gapFiller{iv: spec.Interval}
+	}
+	return b
+}
+
+// add - fold a quote into the open bar, closing it first if the quote is past its window
+func (b *quoteBuilder) add(q Quote) {
+
+	prev := b.day.start
+	b.day.update(q.T)
+	label := b.day.label(q.T)
+	if b.spec.Interval.D >= day && !b.regular {
+		label = sessionDay
+	}
+
+	// new bar; save prev bar
+	if b.n > 0 && b.spec.Interval.start(&b.day, q.T) != b.start {
+		b.flush()
+	}
+
+	// last night's quotes aren't in force in the morning
+	if b.day.start != prev {
+		if b.gap != nil {
+			b.gap.finish(b.empty)
+		}
+		b.cur = Quote{}
+	}
+
+	// quotes outside the regular session still set the quote in force at the open
+	if b.regular && label != sessionRegular {
+		b.cur = q
+		return
+	}
+
+	// first quote in the bar, which starts with the quote in force
+	if b.n == 0 {
+		b.start = b.spec.Interval.start(&b.day, q.T)
+		b.end = b.spec.Interval.end(&b.day, b.start)
+		b.label = label
+		b.at = b.start
+		b.price(b.cur)
+		if b.gap != nil {
+			b.gap.fill(&b.day, b.start, b.empty)
+			b.gap.skip(b.start)
+		}
+	}
+
+	b.weigh(q.T)
+	b.cur = q
+	b.price(q)
+	b.n++
+}
+
+// price - fold a quote's midpoint and spread into the open bar, if it has both sides
+func (b *quoteBuilder) price(q Quote) {
+	if !twoSided(q) {
+		return
+	}
+	b.mid.add((q.BP + q.AP) / 2)
+	b.spread.addSpread(q.AP - q.BP)
+}
+
+// weigh - count the quote in force from where it was last weighed up to ts
+func (b *quoteBuilder) weigh(ts int64) {
+
+	if twoSided(b.cur) && ts > b.at {
+		d := float64(ts - b.at)
+		b.tw[0] += (b.cur.AP - b.cur.BP) * d
+		b.tw[1] += float64(b.cur.BS) * d
+		b.tw[2] += float64(b.cur.AS) * d
+		b.dur += ts - b.at
+	}
+
+	b.at = ts
+}
+
+// flush - close the open bar, if any
+func (b *quoteBuilder) flush() {
+
+	if b.n == 0 {
+		return
+	}
+
+	// the last quote is in force until the end of the bar
+	b.weigh(b.end)
+
+	bar := QuoteAgg{
+		T:       b.start / int64(time.Millisecond),
+		TAt:     time.Unix(0, b.start).UTC(),
+		Session: b.label,
+		QuoteStats: QuoteStats{
+			MO: b.mid.o, MH: b.mid.h, ML: b.mid.l, MC: b.mid.c,
+			SPL: b.spread.l, SPH: b.spread.h,
+			QN: b.n,
+		},
+	}
+	if b.dur > 0 {
+		d := float64(b.dur)
+		bar.SP, bar.BS, bar.AS = b.tw[0]/d, b.tw[1]/d, b.tw[2]/d
+	}
+
+	b.bars = append(b.bars, bar)
+	b.n = 0
+	b.mid, b.spread = priceRange{}, priceRange{}
+	b.tw = [3]float64{}
+	b.dur = 0
+}
+
+// finish - close the open bar and fill in the rest of the day's windows
+func (b *quoteBuilder) finish() {
+	b.flush()
+	if b.gap != nil {
+		b.gap.finish(b.empty)
+	}
+}
+
+// empty - add a bar for a window without quotes, made of the quote in force
+// or NaNs, depending on the fill policy
+func (b *quoteBuilder) empty(start int64) {
+
+	bar := QuoteAgg{
+		T:       start / int64(time.Millisecond),
+		TAt:     time.Unix(0, start).UTC(),
+		Session: b.gap.label(start, b.regular),
+		Filled:  true,
+	}
+
+	q := b.cur
+	if b.fill == fillForward && twoSided(q) {
+		mid, spread := (q.BP+q.AP)/2, q.AP-q.BP
+		bar.MO, bar.MH, bar.ML, bar.MC = mid, mid, mid, mid
+		bar.SP, bar.SPL, bar.SPH = spread, spread, spread
+		bar.BS, bar.AS = float64(q.BS), float64(q.AS)
+	} else {
+		nan := math.NaN()
+		bar.MO, bar.MH, bar.ML, bar.MC = nan, nan, nan, nan
+		bar.SP, bar.SPL, bar.SPH = nan, nan, nan
+		bar.BS, bar.AS = nan, nan
+	}
+
+	b.bars = append(b.bars, bar)
+}
+
+// twoSided - does the quote have a bid and an ask?
+func twoSided(q Quote) bool {
+	return q.BP > 0 && q.AP > 0
+}
+
+// addSpread - fold in a spread, unlike prices locked (zero) and crossed
+// (negative) spreads count
+func (r *priceRange) addSpread(s float64) {
+
+	if r.n == 0 {
+		r.o, r.h, r.l = s, s, s
+	}
+	r.c = s
+	r.h = math.Max(r.h, s)
+	r.l = math.Min(r.l, s)
+	r.n++
+}
+
+// combine - line up trade bars and quote bars over the same windows, a
+// window with only one of them has zeros for the other
+func combine(trades []Agg, quotes []QuoteAgg) []CombinedAgg {
+
+	combined := make([]CombinedAgg, 0, len(trades))
+	i, j := 0, 0
+	for i < len(trades) || j < len(quotes) {
+		switch {
+		case j == len(quotes) || (i < len(trades) && trades[i].T < quotes[j].T):
+			combined = append(combined, CombinedAgg{Agg: trades[i]})
+			i++
+		case i == len(trades) || quotes[j].T < trades[i].T:
+			q := quotes[j]
+			combined = append(combined, CombinedAgg{Agg: Agg{T: q.T, TAt: q.TAt, Session: q.Session}, QuoteStats: q.QuoteStats})
+			j++
+		default:
+			combined = append(combined, CombinedAgg{Agg: trades[i], QuoteStats: quotes[j].QuoteStats})
+			i++
+			j++
+		}
+	}
+
+	return combined
+}
@@ -0,0 +1,109 @@
+package main
+
+import "testing"
+
+// buildQuoteBars - feed quotes to a quote builder for spec and return its bars
+func buildQuoteBars(cfg *Config, spec BarSpec, quotes ...Quote) []QuoteAgg {
+	b := newQuoteBuilder(cfg, spec)
+	for _, q := range quotes {
+		b.add(q)
+	}
+	b.finish()
+	return b.bars
+}
+
+func TestQuoteBars(t *testing.T) {
+
+	quotes := []Quote{
+		{T: ny(t, "2022-12-23 10:00:00"), BP: 10, BS: 1, AP: 10.2, AS: 2},
+		{T: ny(t, "2022-12-23 10:00:30"), BP: 10, BS: 3, AP: 10.1, AS: 4},
+
+		// in force from the start of the next bar
+		{T: ny(t, "2022-12-23 10:01:15"), BP: 10.1, BS: 5, AP: 10.3, AS: 6},
+
+		// one sided quotes have no midpoint or spread
+		{T: ny(t, "2022-12-23 10:02:00"), BP: 10, BS: 1},
+		{T: ny(t, "2022-12-23 10:02:30"), BP: 10, BS: 2, AP: 10.2, AS: 2},
+
+		// crossed quotes still count towards the spread
+		{T: ny(t, "2022-12-23 10:03:00"), BP: 10.3, BS: 1, AP: 10.2, AS: 1},
+	}
+
+	bars := buildQuoteBars(testConfig(t, "-quotes", "1m"), timeSpec(t, "1m"), quotes...)
+	want := []struct {
+		start                string
+		mo, mh, ml, mc       float64
+		sp, spl, sph, bs, as float64
+		qn                   int64
+	}{
+		// half a minute at 0.2, half at 0.1
+		{"2022-12-23 10:00:00", 10.1, 10.1, 10.05, 10.05, 0.15, 0.1, 0.2, 2, 3, 2},
+		// 15s of the last quote, 45s of the new one
+		{"2022-12-23 10:01:00", 10.05, 10.2, 10.05, 10.2, (0.1*15 + 0.2*45) / 60, 0.1, 0.2, (3*15 + 5*45) / 60.0, (4*15 + 6*45) / 60.0, 1},
+		// the one sided 30s doesn't count, averages are over the two sided 30s
+		{"2022-12-23 10:02:00", 10.2, 10.2, 10.1, 10.1, 0.2, 0.2, 0.2, 2, 2, 2},
+		// a crossed market all minute
+		{"2022-12-23 10:03:00", 10.1, 10.25, 10.1, 10.25, -0.1, -0.1, 0.2, 1, 1, 1},
+	}
+	if len(bars) != len(want) {
+		t.Fatalf("got %v quote bars, want %v", len(bars), len(want))
+	}
+	for i, w := range want {
+		b := bars[i]
+		if b.T != ms(t, w.start) || b.Session != sessionRegular || b.QN != w.qn {
+			t.Errorf("bar %v: %v %v with %v quotes, want %v regular with %v", i, b.TAt, b.Session, b.QN, w.start, w.qn)
+		}
+		got := []float64{b.MO, b.MH, b.ML, b.MC, b.SP, b.SPL, b.SPH, b.BS, b.AS}
+		exp := []float64{w.mo, w.mh, w.ml, w.mc, w.sp, w.spl, w.sph, w.bs, w.as}
+		for j := range got {
+			if !near(got[j], exp[j]) {
+				t.Errorf("bar %v (%v): got mo mh ml mc sp spl sph bs as %v, want %v", i, w.start, got, exp)
+				break
+			}
+		}
+	}
+}
+
+func TestQuotesStartOverEachDay(t *testing.T) {
+
+	// last night's quote isn't in force in the morning
+	bars := buildQuoteBars(testConfig(t, "-quotes", "1h"), timeSpec(t, "1h"),
+		Quote{T: ny(t, "2022-12-22 19:59:00"), BP: 10, BS: 1, AP: 10.2, AS: 1},
+		Quote{T: ny(t, "2022-12-23 09:45:00"), BP: 11, BS: 1, AP: 11.2, AS: 1},
+	)
+	if len(bars) != 2 {
+		t.Fatalf("got %v bars, want 2", len(bars))
+	}
+	if b := bars[1]; b.T != ms(t, "2022-12-23 09:30:00") || b.MO != 11.1 || b.QN != 1 || !near(b.SP, 0.2) {
+		t.Errorf("morning bar %+v, want it to open at the morning's quote", b)
+	}
+}
+
+func TestCombinedBars(t *testing.T) {
+
+	at := func(s string) Agg { return Agg{T: ms(t, s)} }
+	quote := func(s string, mid float64) QuoteAgg { return QuoteAgg{T: ms(t, s), QuoteStats: QuoteStats{MC: mid}} }
+
+	combined := combine(
+		[]Agg{at("2022-12-23 10:00:00"), at("2022-12-23 10:02:00")},
+		[]QuoteAgg{quote("2022-12-23 10:00:00", 1), quote("2022-12-23 10:01:00", 2), quote("2022-12-23 10:03:00", 3)},
+	)
+
+	want := []struct {
+		start string
+		mc    float64
+	}{
+		{"2022-12-23 10:00:00", 1},
+		{"2022-12-23 10:01:00", 2},
+		{"2022-12-23 10:02:00", 0},
+		{"2022-12-23 10:03:00", 3},
+	}
+	if len(combined) != len(want) {
+		t.Fatalf("got %v combined bars, want %v", len(combined), len(want))
+	}
+	for i, w := range want {
+		if combined[i].Agg.T != ms(t, w.start) || combined[i].MC != w.mc {
+			t.Errorf("combined bar %v: %v with mc %v, want %v with %v", i, combined[i].Agg.T, combined[i].MC, w.start, w.mc)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"time"
+
+	"github.com/jweissig/polygon/calendar"
+)
+
+// session labels
+const (
+	sessionPre     = "pre"     // midnight until the regular open, officially from 4am
+	sessionRegular = "regular" // 9:30 until the close (4pm, or 1pm on early close days)
+	sessionPost    = "post"    // the close until midnight, officially until 8pm
+	sessionClosed  = "closed"  // weekends and holidays
+	sessionDay     = "day"     // daily bars, which span every session
+)
+
+// nyDay - the New York day a timestamp falls on and its trading sessions
+//
+// Everything is compared as UTC nanoseconds, the New York clock is only
+// used to work out where the day and its sessions start and end, so the
+// output is the same whatever zone the machine running this is in.
+type nyDay struct {
+	start, end int64    // Unix NS of midnight and the next midnight in New York
+	open       bool     // is it a trading day?
+	regular    [2]int64 // Unix NS of the regular open and close
+}
+
+// update - move to the day ts falls on, if it isn't already there
+func (d *nyDay) update(ts int64) {
+
+	if d.end != 0 && ts >= d.start && ts < d.end {
+		return
+	}
+
+	t := time.Unix(0, ts).In(calendar.NewYork)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, calendar.NewYork)
+
+	*d = nyDay{start: midnight.UnixNano(), end: midnight.AddDate(0, 0, 1).UnixNano()}
+	if s, ok := calendar.SessionOn(midnight); ok {
+		d.open = true
+		d.regular = [2]int64{s.Open.UnixNano(), s.Close.UnixNano()}
+	}
+}
+
+// label - which session ts (on this day) is in
+func (d *nyDay) label(ts int64) string {
+	switch {
+	case !d.open:
+		return sessionClosed
+	case ts < d.regular[0]:
+		return sessionPre
+	case ts < d.regular[1]:
+		return sessionRegular
+	}
+	return sessionPost
+}
+
+// sessionStart - Unix NS the session ts is in started
+func (d *nyDay) sessionStart(ts int64) int64 {
+	switch d.label(ts) {
+	case sessionRegular:
+		return d.regular[0]
+	case sessionPost:
+		return d.regular[1]
+	}
+	return d.start
+}
+
+// sessionEnd - Unix NS the session ts is in ends
+func (d *nyDay) sessionEnd(ts int64) int64 {
+	switch d.label(ts) {
+	case sessionPre:
+		return d.regular[0]
+	case sessionRegular:
+		return d.regular[1]
+	}
+	return d.end
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jweissig/polygon/calendar"
+)
+
+func TestSessionLabels(t *testing.T) {
+
+	for _, c := range []struct {
+		at, want string
+	}{
+		{"2022-03-11 09:29:59", sessionPre},
+		{"2022-03-11 09:30:00", sessionRegular},
+		{"2022-03-11 16:00:00", sessionPost},
+		{"2022-03-13 12:00:00", sessionClosed}, // the Sunday clocks go forward
+		{"2022-03-14 00:00:00", sessionPre},
+		{"2022-03-14 15:59:59", sessionRegular},
+		{"2022-11-25 12:59:59", sessionRegular}, // early close
+		{"2022-11-25 13:00:00", sessionPost},
+		{"2022-11-24 12:00:00", sessionClosed}, // Thanksgiving
+	} {
+		var d nyDay
+		ts := ny(t, c.at)
+		d.update(ts)
+		if got := d.label(ts); got != c.want {
+			t.Errorf("%v: got %v, want %v", c.at, got, c.want)
+		}
+	}
+
+	// the day clocks go forward is 23 hours long, the day they go back 25
+	for _, c := range []struct {
+		at   string
+		want time.Duration
+	}{
+		{"2022-03-13 12:00:00", 23 * time.Hour},
+		{"2022-11-06 12:00:00", 25 * time.Hour},
+		{"2022-12-23 12:00:00", 24 * time.Hour},
+	} {
+		var d nyDay
+		d.update(ny(t, c.at))
+		if got := time.Duration(d.end - d.start); got != c.want {
+			t.Errorf("%v: day is %v long, want %v", c.at, got, c.want)
+		}
+	}
+}
+
+func TestIntervalWindows(t *testing.T) {
+
+	for _, c := range []struct {
+		interval   string
+		at         string
+		start, end string
+	}{
+		// cut at the open, 9:00-9:30 pre and 9:30-10:00 regular, either side of DST
+		{"1h", "2022-03-11 09:10:00", "2022-03-11 09:00:00", "2022-03-11 09:30:00"},
+		{"1h", "2022-03-11 09:40:00", "2022-03-11 09:30:00", "2022-03-11 10:00:00"},
+		{"1h", "2022-03-14 09:10:00", "2022-03-14 09:00:00", "2022-03-14 09:30:00"},
+		{"1h", "2022-03-14 09:40:00", "2022-03-14 09:30:00", "2022-03-14 10:00:00"},
+		{"1h", "2022-03-14 16:20:00", "2022-03-14 16:00:00", "2022-03-14 17:00:00"},
+		{"1m", "2022-03-14 15:59:30", "2022-03-14 15:59:00", "2022-03-14 16:00:00"},
+
+		// 4h windows line up with UTC, so they're cut at both ends of the session
+		{"4h", "2022-03-14 09:40:00", "2022-03-14 09:30:00", "2022-03-14 12:00:00"},
+		{"4h", "2022-03-14 15:00:00", "2022-03-14 12:00:00", "2022-03-14 16:00:00"},
+
+		// the early close cuts at 1pm
+		{"1h", "2022-11-25 12:45:00", "2022-11-25 12:00:00", "2022-11-25 13:00:00"},
+		{"4h", "2022-11-25 12:45:00", "2022-11-25 11:00:00", "2022-11-25 13:00:00"},
+		{"4h", "2022-11-25 13:20:00", "2022-11-25 13:00:00", "2022-11-25 15:00:00"},
+
+		// the last window of a day ends at New York midnight
+		{"4h", "2022-12-23 22:30:00", "2022-12-23 19:00:00", "2022-12-23 23:00:00"},
+		{"4h", "2022-12-23 23:30:00", "2022-12-23 23:00:00", "2022-12-24 00:00:00"},
+
+		// daily bars start at New York midnight, however long the day
+		{"1d", "2022-03-11 19:30:00", "2022-03-11 00:00:00", "2022-03-12 00:00:00"},
+		{"1d", "2022-03-13 23:30:00", "2022-03-13 00:00:00", "2022-03-14 00:00:00"},
+		{"1d", "2022-03-14 00:10:00", "2022-03-14 00:00:00", "2022-03-15 00:00:00"},
+	} {
+		// a trade at the time, one just before the window ends and one as it ends
+		end := ny(t, c.end)
+		bars := buildBars(testConfig(t), timeSpec(t, c.interval),
+			Trade{T: ny(t, c.at), P: 10, S: 100},
+			Trade{T: end - 1, P: 10, S: 100},
+			Trade{T: end, P: 10, S: 100},
+		)
+		if len(bars) != 2 || bars[0].T != ms(t, c.start) || bars[0].N != 2 || bars[1].T != end/int64(time.Millisecond) {
+			for i := range bars {
+				t.Logf("bar %v at %v with %v trades", i, time.Unix(0, bars[i].T*int64(time.Millisecond)).In(calendar.NewYork), bars[i].N)
+			}
+			t.Errorf("%v at %v: want a bar from %v to %v", c.interval, c.at, c.start, c.end)
+		}
+	}
+}
+
+func TestBarsCutAtSessions(t *testing.T) {
+
+	trades := []Trade{
+		{T: ny(t, "2022-03-14 09:10:00"), P: 10, S: 100},
+		{T: ny(t, "2022-03-14 09:40:00"), P: 11, S: 100},
+		{T: ny(t, "2022-03-14 16:30:00"), P: 12, S: 100},
+		{T: ny(t, "2022-03-14 23:59:00"), P: 13, S: 100}, // 03:59 UTC the next day
+		{T: ny(t, "2022-03-15 00:01:00"), P: 14, S: 100},
+	}
+
+	bars := buildBars(testConfig(t, "-interval", "1h"), timeSpec(t, "1h"), trades...)
+	want := []struct {
+		start, session string
+	}{
+		{"2022-03-14 09:00:00", sessionPre},
+		{"2022-03-14 09:30:00", sessionRegular},
+		{"2022-03-14 16:00:00", sessionPost},
+		{"2022-03-14 23:00:00", sessionPost},
+		{"2022-03-15 00:00:00", sessionPre},
+	}
+	if len(bars) != len(want) {
+		t.Fatalf("got %v 1h bars, want %v", len(bars), len(want))
+	}
+	for i, w := range want {
+		if bars[i].T != ms(t, w.start) || bars[i].Session != w.session {
+			t.Errorf("1h bar %v: %v %v, want %v %v", i, bars[i].TAt, bars[i].Session, w.start, w.session)
+		}
+	}
+
+	// daily bars hold the whole New York day, post market included
+	bars = buildBars(testConfig(t, "-interval", "1d"), timeSpec(t, "1d"), trades...)
+	if len(bars) != 2 {
+		t.Fatalf("got %v 1d bars, want 2", len(bars))
+	}
+	if b := bars[0]; b.T != ms(t, "2022-03-14 00:00:00") || b.Session != sessionDay || b.N != 4 || b.O != 10 || b.C != 13 {
+		t.Errorf("first 1d bar %+v", b)
+	}
+	if b := bars[1]; b.T != ms(t, "2022-03-15 00:00:00") || b.N != 1 || b.AV != 100 {
+		t.Errorf("second 1d bar %+v, session volume starts over", b)
+	}
+
+	// the regular session only on an early close day
+	trades = []Trade{
+		{T: ny(t, "2022-11-25 12:59:00"), P: 10, S: 100},
+		{T: ny(t, "2022-11-25 13:00:00"), P: 11, S: 100},
+	}
+	bars = buildBars(testConfig(t, "-interval", "1d", "-regular-only"), timeSpec(t, "1d"), trades...)
+	if len(bars) != 1 || bars[0].N != 1 || bars[0].Session != sessionRegular {
+		t.Errorf("regular only 1d bars on an early close day %+v", bars)
+	}
+}
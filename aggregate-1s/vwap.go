@@ -0,0 +1,36 @@
+package main
+
+// vwapSum - running sums for a volume weighted average price
+type vwapSum struct {
+	pv float64 // sum of price * size
+	v  int64   // sum of size
+}
+
+// add - fold in a trade
+func (s *vwapSum) add(p float64, size int64) {
+	s.pv += p * float64(size)
+	s.v += size
+}
+
+// value - the VWAP so far, 0 before any volume
+func (s *vwapSum) value() float64 {
+	if s.v == 0 {
+		return 0
+	}
+	return s.pv / float64(s.v)
+}
+
+// session - running totals since the start of the New York trading day
+type session struct {
+	day     int64   // Unix NS of the New York midnight the totals started from
+	vwap    vwapSum // session VWAP
+	volume  int64   // accumulated volume
+	dollars float64 // accumulated dollar volume
+}
+
+// roll - start over if d is a different day
+func (s *session) roll(d *nyDay) {
+	if s.day != d.start {
+		*s = session{day: d.start}
+	}
+}
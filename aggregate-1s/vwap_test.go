@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestQualifiedVWAP(t *testing.T) {
+
+	trades := []Trade{
+		{T: ny(t, "2022-12-23 10:00:01"), P: 100, S: 100, X: 4},
+		{T: ny(t, "2022-12-23 10:00:02"), P: 110, S: 50, X: 4, C: []int{38}},  // corrected close, no volume
+		{T: ny(t, "2022-12-23 10:00:30"), P: 98, S: 200, X: 11, C: []int{37}}, // odd lot, counts towards volume
+		{T: ny(t, "2022-12-23 10:01:05"), P: 104, S: 100, X: 4},
+		{T: ny(t, "2022-12-23 10:01:10"), P: 200, S: 300, X: 12, C: []int{16}}, // official open, no volume
+		{T: ny(t, "2022-12-27 09:30:00"), P: 50, S: 10, X: 4},                  // the next trading day starts over
+	}
+
+	// vw, v, dv, then the day so far: a, av, adv
+	type totals [6]float64
+
+	for _, c := range []struct {
+		name string
+		args []string
+		want []totals
+	}{
+		{
+			// every trade counts towards volume, so the VWAP takes them all
+			"every trade", []string{"-apply-conditions=false"},
+			[]totals{
+				{35100.0 / 350, 350, 35100, 35100.0 / 350, 350, 35100},
+				{70400.0 / 400, 400, 70400, 105500.0 / 750, 750, 105500},
+				{50, 10, 500, 50, 10, 500},
+			},
+		},
+		{
+			// the volume is the same, the corrected close and official open
+			// are left out of vw and a
+			"qualified", []string{"-apply-conditions=false", "-vwap-qualified"},
+			[]totals{
+				{29600.0 / 300, 350, 35100, 29600.0 / 300, 350, 35100},
+				{104, 400, 70400, 40000.0 / 400, 750, 105500},
+				{50, 10, 500, 50, 10, 500},
+			},
+		},
+		{
+			// applying conditions already leaves them out of the volume
+			"conditions", nil,
+			[]totals{
+				{29600.0 / 300, 300, 29600, 29600.0 / 300, 300, 29600},
+				{104, 100, 10400, 40000.0 / 400, 400, 40000},
+				{50, 10, 500, 50, 10, 500},
+			},
+		},
+	} {
+		args := append([]string{"-interval", "1m"}, c.args...)
+		bars := buildBars(testConfig(t, args...), timeSpec(t, "1m"), trades...)
+		if len(bars) != len(c.want) {
+			t.Fatalf("%v: got %v bars, want %v", c.name, len(bars), len(c.want))
+		}
+		for i, b := range bars {
+			got := totals{b.VW, float64(b.V), b.DV, b.A, float64(b.AV), b.ADV}
+			for j := range got {
+				if !near(got[j], c.want[i][j]) {
+					t.Errorf("%v: bar %v vw, v, dv, a, av, adv = %v, want %v", c.name, i, got, c.want[i])
+					break
+				}
+			}
+		}
+
+		// the qualified VWAP changes nothing once conditions are applied
+		if c.args == nil {
+			qualified := buildBars(testConfig(t, "-interval", "1m", "-vwap-qualified"), timeSpec(t, "1m"), trades...)
+			if !reflect.DeepEqual(qualified, bars) {
+				t.Errorf("-vwap-qualified with conditions applied changed the bars")
+			}
+		}
+	}
+}
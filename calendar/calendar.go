@@ -0,0 +1,284 @@
+// Package calendar is the US equities (NYSE/Nasdaq) trading calendar.
+//
+// It knows the full day market holidays, the 1:00pm early close days and the
+// pre-market / regular / after-hours session times for any day from 2000 on.
+// Holidays are computed from the exchange rules (observed weekend shifts,
+// Good Friday, Juneteenth from 2022) plus a short list of one-off closures.
+//
+// Functions look at the calendar date of a time in its own location, so pass
+// days built with Date/Parse or convert timestamps with t.In(NewYork) first.
+package calendar
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	_ "time/tzdata" // make sure America/New_York exists on minimal boxes
+)
+
+// DateLayout - the YYYY-MM-DD format used throughout polygon
+const DateLayout = "2006-01-02"
+
+// session times as an offset from midnight New York time
+const (
+	PreMarketOpen   = 4 * time.Hour                // 04:00
+	RegularOpen     = 9*time.Hour + 30*time.Minute // 09:30
+	RegularClose    = 16 * time.Hour               // 16:00
+	EarlyCloseTime  = 13 * time.Hour               // 13:00 on half days
+	AfterHoursClose = 20 * time.Hour               // 20:00
+	EarlyPostClose  = 17 * time.Hour               // 17:00 on half days
+)
+
+// rule validity
+const (
+	firstYear       = 2000 // rules below are not checked before this
+	lastYear        = 2100 // sanity bound
+	firstJuneteenth = 2022 // first year NYSE closed for Juneteenth
+)
+
+// NewYork - the exchange time zone
+var NewYork = mustLoad("America/New_York")
+
+// Session - the trading hours for one day, all times in America/New_York
+type Session struct {
+	Date       time.Time // midnight New York time
+	PreOpen    time.Time // pre-market open, 04:00
+	Open       time.Time // regular session open, 09:30
+	Close      time.Time // regular session close, 16:00 or 13:00 on early close days
+	PostClose  time.Time // after-hours close, 20:00 or 17:00 on early close days
+	EarlyClose bool      // true on half days
+}
+
+// special closures that don't follow the rules
+var specialClosures = map[string]string{
+	"2001-09-11": "September 11",
+	"2001-09-12": "September 11",
+	"2001-09-13": "September 11",
+	"2001-09-14": "September 11",
+	"2004-06-11": "Reagan National Day of Mourning",
+	"2007-01-02": "Ford National Day of Mourning",
+	"2012-10-29": "Hurricane Sandy",
+	"2012-10-30": "Hurricane Sandy",
+	"2018-12-05": "Bush National Day of Mourning",
+	"2025-01-09": "Carter National Day of Mourning",
+}
+
+// year - holidays and early closes for one year keyed by YYYY-MM-DD
+type year struct {
+	holidays map[string]string
+	early    map[string]string
+}
+
+var (
+	mu    sync.Mutex
+	years = map[int]*year{}
+)
+
+// Date - midnight New York time for the given calendar day
+func Date(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, 0, 0, 0, 0, NewYork)
+}
+
+// Parse - parse a YYYY-MM-DD string into midnight New York time
+func Parse(s string) (time.Time, error) {
+	return time.ParseInLocation(DateLayout, s, NewYork)
+}
+
+// Holiday - the holiday name if the exchanges are closed all day on t
+func Holiday(t time.Time) (string, bool) {
+	name, ok := yearOf(t).holidays[key(t)]
+	return name, ok
+}
+
+// EarlyClose - the reason if the exchanges close at 1:00pm on t
+func EarlyClose(t time.Time) (string, bool) {
+	name, ok := yearOf(t).early[key(t)]
+	return name, ok
+}
+
+// IsTradingDay - true if t is a weekday and not a market holiday
+func IsTradingDay(t time.Time) bool {
+	if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		return false
+	}
+	_, closed := Holiday(t)
+	return !closed
+}
+
+// SessionOn - the trading session for the day of t, false if the market is closed
+func SessionOn(t time.Time) (Session, bool) {
+
+	if !IsTradingDay(t) {
+		return Session{}, false
+	}
+
+	midnight := Date(t.Year(), t.Month(), t.Day())
+	at := func(offset time.Duration) time.Time {
+		h, m := int(offset/time.Hour), int(offset%time.Hour/time.Minute)
+		return time.Date(t.Year(), t.Month(), t.Day(), h, m, 0, 0, NewYork)
+	}
+
+	s := Session{
+		Date:      midnight,
+		PreOpen:   at(PreMarketOpen),
+		Open:      at(RegularOpen),
+		Close:     at(RegularClose),
+		PostClose: at(AfterHoursClose),
+	}
+
+	if _, ok := EarlyClose(t); ok {
+		s.EarlyClose = true
+		s.Close = at(EarlyCloseTime)
+		s.PostClose = at(EarlyPostClose)
+	}
+
+	return s, true
+}
+
+// TradingDays - every trading day from..to inclusive
+func TradingDays(from, to time.Time) []time.Time {
+
+	var days []time.Time
+
+	start := Date(from.Year(), from.Month(), from.Day())
+	end := Date(to.Year(), to.Month(), to.Day())
+
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		if IsTradingDay(d) {
+			days = append(days, d)
+		}
+	}
+
+	return days
+}
+
+// yearOf - cached holidays for the year of t
+func yearOf(t time.Time) *year {
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	y, ok := years[t.Year()]
+	if !ok {
+		y = build(t.Year())
+		years[t.Year()] = y
+	}
+
+	return y
+}
+
+// build - work out the holidays and early closes for a year
+func build(y int) *year {
+
+	yr := &year{holidays: map[string]string{}, early: map[string]string{}}
+	if y < firstYear || y > lastYear {
+		return yr
+	}
+
+	add := func(t time.Time, name string) { yr.holidays[key(t)] = name }
+
+	// New Year's Day, Sunday moves to Monday but Saturday is not observed (NYSE rule 7.2)
+	if ny := Date(y, time.January, 1); ny.Weekday() != time.Saturday {
+		add(observed(ny), "New Year's Day")
+	}
+
+	add(nthWeekday(y, time.January, time.Monday, 3), "Martin Luther King Jr. Day")
+	add(nthWeekday(y, time.February, time.Monday, 3), "Washington's Birthday")
+	add(easter(y).AddDate(0, 0, -2), "Good Friday")
+	add(lastWeekday(y, time.May, time.Monday), "Memorial Day")
+
+	if y >= firstJuneteenth {
+		add(observed(Date(y, time.June, 19)), "Juneteenth")
+	}
+
+	add(observed(Date(y, time.July, 4)), "Independence Day")
+	add(nthWeekday(y, time.September, time.Monday, 1), "Labor Day")
+
+	thanksgiving := nthWeekday(y, time.November, time.Thursday, 4)
+	add(thanksgiving, "Thanksgiving Day")
+
+	add(observed(Date(y, time.December, 25)), "Christmas Day")
+
+	for k, name := range specialClosures {
+		if strings.HasPrefix(k, fmt.Sprintf("%d-", y)) {
+			yr.holidays[k] = name
+		}
+	}
+
+	// early closes, only when the day itself is a normal weekday session
+	early := func(t time.Time, name string) {
+		if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+			return
+		}
+		if _, closed := yr.holidays[key(t)]; closed {
+			return
+		}
+		yr.early[key(t)] = name
+	}
+
+	early(Date(y, time.July, 3), "Independence Day Eve")
+	early(thanksgiving.AddDate(0, 0, 1), "Day after Thanksgiving")
+	early(Date(y, time.December, 24), "Christmas Eve")
+
+	return yr
+}
+
+// observed - Saturday holidays move to Friday, Sunday holidays to Monday
+func observed(t time.Time) time.Time {
+	switch t.Weekday() {
+	case time.Saturday:
+		return t.AddDate(0, 0, -1)
+	case time.Sunday:
+		return t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+// nthWeekday - the nth (1 based) weekday of a month, e.g. 3rd Monday of January
+func nthWeekday(y int, m time.Month, wd time.Weekday, n int) time.Time {
+	t := Date(y, m, 1)
+	offset := (int(wd) - int(t.Weekday()) + 7) % 7
+	return t.AddDate(0, 0, offset+(n-1)*7)
+}
+
+// lastWeekday - the last weekday of a month, e.g. last Monday of May
+func lastWeekday(y int, m time.Month, wd time.Weekday) time.Time {
+	t := Date(y, m+1, 1).AddDate(0, 0, -1)
+	offset := (int(t.Weekday()) - int(wd) + 7) % 7
+	return t.AddDate(0, 0, -offset)
+}
+
+// easter - Easter Sunday (anonymous Gregorian algorithm)
+func easter(y int) time.Time {
+	a := y % 19
+	b := y / 100
+	c := y % 100
+	d := b / 4
+	e := b % 4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i := c / 4
+	k := c % 4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	dom := (h+l-7*m+114)%31 + 1
+	return Date(y, time.Month(month), dom)
+}
+
+// key - YYYY-MM-DD of t in its own location
+func key(t time.Time) string {
+	return t.Format(DateLayout)
+}
+
+// mustLoad - load a time zone or panic, tzdata is embedded so this can't fail
+func mustLoad(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}
@@ -0,0 +1,162 @@
+package calendar_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jweissig/polygon/calendar"
+)
+
+// the published NYSE holiday and early close lists
+var published = []struct {
+	year     int
+	holidays []string
+	early    []string
+}{
+	{
+		year: 2021,
+		// Jul 4 is a Sunday and Dec 25 a Saturday, 2022's New Year's Day (a Saturday) isn't observed on Dec 31
+		holidays: []string{"2021-01-01", "2021-01-18", "2021-02-15", "2021-04-02", "2021-05-31", "2021-07-05", "2021-09-06", "2021-11-25", "2021-12-24"},
+		early:    []string{"2021-11-26"},
+	},
+	{
+		year: 2022,
+		// first Juneteenth, on a Sunday, Christmas on a Sunday
+		holidays: []string{"2022-01-17", "2022-02-21", "2022-04-15", "2022-05-30", "2022-06-20", "2022-07-04", "2022-09-05", "2022-11-24", "2022-12-26"},
+		early:    []string{"2022-11-25"},
+	},
+	{
+		year:     2023,
+		holidays: []string{"2023-01-02", "2023-01-16", "2023-02-20", "2023-04-07", "2023-05-29", "2023-06-19", "2023-07-04", "2023-09-04", "2023-11-23", "2023-12-25"},
+		early:    []string{"2023-07-03", "2023-11-24"},
+	},
+	{
+		year:     2024,
+		holidays: []string{"2024-01-01", "2024-01-15", "2024-02-19", "2024-03-29", "2024-05-27", "2024-06-19", "2024-07-04", "2024-09-02", "2024-11-28", "2024-12-25"},
+		early:    []string{"2024-07-03", "2024-11-29", "2024-12-24"},
+	},
+	{
+		year: 2025,
+		// one-off closure for the Carter day of mourning
+		holidays: []string{"2025-01-01", "2025-01-09", "2025-01-20", "2025-02-17", "2025-04-18", "2025-05-26", "2025-06-19", "2025-07-04", "2025-09-01", "2025-11-27", "2025-12-25"},
+		early:    []string{"2025-07-03", "2025-11-28", "2025-12-24"},
+	},
+}
+
+func TestPublishedCalendars(t *testing.T) {
+
+	for _, p := range published {
+		var holidays, early []string
+		for d := calendar.Date(p.year, time.January, 1); d.Year() == p.year; d = d.AddDate(0, 0, 1) {
+			if _, ok := calendar.Holiday(d); ok {
+				holidays = append(holidays, d.Format(calendar.DateLayout))
+			}
+			if _, ok := calendar.EarlyClose(d); ok {
+				early = append(early, d.Format(calendar.DateLayout))
+			}
+		}
+		if !reflect.DeepEqual(holidays, p.holidays) {
+			t.Errorf("%v holidays\n got %v\nwant %v", p.year, holidays, p.holidays)
+		}
+		if !reflect.DeepEqual(early, p.early) {
+			t.Errorf("%v early closes\n got %v\nwant %v", p.year, early, p.early)
+		}
+	}
+}
+
+func TestObservedShifts(t *testing.T) {
+
+	for _, c := range []struct {
+		date   string
+		name   string
+		closed bool
+	}{
+		{"2021-12-31", "", false},                // Saturday New Year's Day isn't observed
+		{"2022-06-20", "Juneteenth", true},       // Sunday moves to Monday
+		{"2021-06-18", "", false},                // no Juneteenth before 2022
+		{"2022-12-26", "Christmas Day", true},    // Sunday moves to Monday
+		{"2021-12-24", "Christmas Day", true},    // Saturday moves to Friday
+		{"2021-07-05", "Independence Day", true}, // Sunday moves to Monday
+		{"2020-07-03", "Independence Day", true}, // Saturday moves to Friday
+		{"2017-01-02", "New Year's Day", true},   // Sunday moves to Monday
+		{"2012-10-29", "Hurricane Sandy", true},  // one-off
+		{"2001-09-11", "September 11", true},     // one-off
+		{"2022-12-23", "", false},                // an ordinary Friday
+	} {
+		d, err := calendar.Parse(c.date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name, closed := calendar.Holiday(d)
+		if name != c.name || closed != c.closed {
+			t.Errorf("%v: got %q %v, want %q %v", c.date, name, closed, c.name, c.closed)
+		}
+		if calendar.IsTradingDay(d) == c.closed {
+			t.Errorf("%v: IsTradingDay %v", c.date, !c.closed)
+		}
+	}
+}
+
+func TestSessionOn(t *testing.T) {
+
+	at := func(s string) time.Time {
+		t.Helper()
+		ts, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+
+	for _, c := range []struct {
+		date                            string
+		preOpen, open, close, postClose string
+		early                           bool
+	}{
+		// winter, New York is UTC-5
+		{"2022-12-23", "2022-12-23T09:00:00Z", "2022-12-23T14:30:00Z", "2022-12-23T21:00:00Z", "2022-12-24T01:00:00Z", false},
+		// the Monday after DST starts, UTC-4
+		{"2022-03-14", "2022-03-14T08:00:00Z", "2022-03-14T13:30:00Z", "2022-03-14T20:00:00Z", "2022-03-15T00:00:00Z", false},
+		// half day
+		{"2022-11-25", "2022-11-25T09:00:00Z", "2022-11-25T14:30:00Z", "2022-11-25T18:00:00Z", "2022-11-25T22:00:00Z", true},
+	} {
+		d, _ := calendar.Parse(c.date)
+		s, ok := calendar.SessionOn(d)
+		if !ok {
+			t.Errorf("%v: no session", c.date)
+			continue
+		}
+		if !s.PreOpen.Equal(at(c.preOpen)) || !s.Open.Equal(at(c.open)) || !s.Close.Equal(at(c.close)) || !s.PostClose.Equal(at(c.postClose)) || s.EarlyClose != c.early {
+			t.Errorf("%v: got %+v", c.date, s)
+		}
+	}
+
+	for _, date := range []string{"2022-12-24", "2022-12-26"} {
+		d, _ := calendar.Parse(date)
+		if _, ok := calendar.SessionOn(d); ok {
+			t.Errorf("%v: got a session", date)
+		}
+	}
+}
+
+func TestTradingDays(t *testing.T) {
+
+	// published NYSE trading days per year
+	for y, want := range map[int]int{2021: 252, 2022: 251, 2023: 250, 2024: 252} {
+		days := calendar.TradingDays(calendar.Date(y, time.January, 1), calendar.Date(y, time.December, 31))
+		if len(days) != want {
+			t.Errorf("%v: %v trading days, want %v", y, len(days), want)
+		}
+	}
+
+	// a range over a long weekend
+	days := calendar.TradingDays(calendar.Date(2022, time.December, 22), calendar.Date(2022, time.December, 27))
+	var got []string
+	for _, d := range days {
+		got = append(got, d.Format(calendar.DateLayout))
+	}
+	if want := []string{"2022-12-22", "2022-12-23", "2022-12-27"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
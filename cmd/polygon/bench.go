@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jweissig/polygon/tqfile"
+)
+
+// benchCodec - a codec and level to benchmark
+type benchCodec struct {
+	label string
+	codec tqfile.Codec
+}
+
+// benchResult - how a codec did on the sample
+type benchResult struct {
+	bytes  int64         // compressed
+	encode time.Duration // fastest run
+	decode time.Duration // fastest run
+}
+
+// runBench - polygon bench
+func runBench(args []string) error {
+
+	fs := flag.NewFlagSet("polygon bench", flag.ContinueOnError)
+	codecs := fs.String("codecs", "lz4,lz4:9,zstd:1,zstd,zstd:9,zstd:19,none", "comma separated codecs to compare, as codec or codec:level")
+	zstdDict := fs.String("zstd-dict", "", "zstd dictionary made with `zstd --train`, zstd is benchmarked with and without it")
+	files := fs.Int("files", 20, "files to sample, spread over the files found (0 = all)")
+	maxMB := fs.Int("max-mb", 1024, "stop sampling files once this many MB of uncompressed blocks are held in memory")
+	runs := fs.Int("runs", 3, "times each codec compresses and decompresses the sample, the fastest run counts")
+	samples := fs.String("samples", "", "also write the sampled blocks, uncompressed, to this directory for `zstd --train`")
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: polygon bench [flags] FILE|DIR...\n\n")
+		fmt.Fprintf(fs.Output(), "Compare block codecs on a sample of .gob.lz4 files: compression ratio and\n")
+		fmt.Fprintf(fs.Output(), "encode/decode throughput (MB/s of uncompressed blocks, one core).\n\n")
+		fmt.Fprintf(fs.Output(), "Examples:\n  polygon bench /scratch/historical/2022-12-23/\n")
+		fmt.Fprintf(fs.Output(), "  polygon bench -samples /tmp/samples /scratch/historical/2022-12-23/\n")
+		fmt.Fprintf(fs.Output(), "  zstd --train -B128KiB /tmp/samples/* -o tq.dict\n")
+		fmt.Fprintf(fs.Output(), "  polygon bench -codecs zstd,zstd:9 -zstd-dict tq.dict /scratch/historical/2022-12-23/\n\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no files given")
+	}
+	if *files < 0 {
+		return fmt.Errorf("-files can't be negative, got %v", *files)
+	}
+	if *maxMB < 1 {
+		return fmt.Errorf("-max-mb must be at least 1, got %v", *maxMB)
+	}
+	if *runs < 1 {
+		return fmt.Errorf("-runs must be at least 1, got %v", *runs)
+	}
+
+	// read the dictionary first, files compressed with it need it to be read
+	var dict []byte
+	if *zstdDict != "" {
+		var err error
+		if dict, err = ioutil.ReadFile(*zstdDict); err != nil {
+			return fmt.Errorf("-zstd-dict: %w", err)
+		}
+		if _, err := tqfile.AddDict(dict); err != nil {
+			return fmt.Errorf("-zstd-dict: %w", err)
+		}
+	}
+	candidates, err := benchCodecs(*codecs, dict)
+	if err != nil {
+		return fmt.Errorf("-codecs: %w", err)
+	}
+
+	jobs, err := convertJobs(fs.Args(), "")
+	if err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		return errors.New("no .gob.lz4 files found")
+	}
+	paths := make([]string, len(jobs))
+	for i, job := range jobs {
+		paths[i] = job.src
+	}
+	paths = sampleFiles(paths, *files)
+
+	// the blocks as the writer would compress them
+	var blocks [][]byte
+	var raw, disk int64
+	var events, sampled int
+	for _, path := range paths {
+		if raw >= int64(*maxMB)<<20 {
+			break
+		}
+		b, n, err := benchBlocks(path)
+		if err != nil {
+			return fmt.Errorf("%v: %w", path, err)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		for _, block := range b {
+			raw += int64(len(block))
+		}
+		blocks = append(blocks, b...)
+		events += n
+		disk += fi.Size()
+		sampled++
+	}
+	if raw == 0 {
+		return errors.New("the sampled files hold no events")
+	}
+
+	if *samples != "" {
+		if err := writeSamples(*samples, blocks); err != nil {
+			return fmt.Errorf("-samples: %w", err)
+		}
+	}
+
+	fmt.Printf("%v of %v files, %v events in %v blocks, %.1f MB uncompressed, %.1f MB on disk\n\n",
+		sampled, len(jobs), events, len(blocks), mb(raw), mb(disk))
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "codec\tMB\tratio\tencode MB/s\tdecode MB/s\t\n")
+	for _, c := range candidates {
+		res, err := benchOne(c.codec, blocks, *runs)
+		if err != nil {
+			return fmt.Errorf("%v: %w", c.label, err)
+		}
+		fmt.Fprintf(tw, "%v\t%.1f\t%.2f\t%.0f\t%.0f\t\n", c.label, mb(res.bytes), float64(raw)/float64(res.bytes),
+			mb(raw)/res.encode.Seconds(), mb(raw)/res.decode.Seconds())
+	}
+
+	return tw.Flush()
+}
+
+// benchCodecs - the codecs named in list, zstd ones twice when there's a dictionary
+func benchCodecs(list string, dict []byte) ([]benchCodec, error) {
+
+	var codecs []benchCodec
+	for _, s := range strings.Split(list, ",") {
+
+		s = strings.TrimSpace(strings.ToLower(s))
+		if s == "" {
+			continue
+		}
+		name, level := s, 0
+		if i := strings.Index(s, ":"); i >= 0 {
+			var err error
+			name = s[:i]
+			if level, err = strconv.Atoi(s[i+1:]); err != nil {
+				return nil, fmt.Errorf("bad level in %q", s)
+			}
+		}
+
+		c, err := tqfile.NewCodec(name, level, nil)
+		if err != nil {
+			return nil, err
+		}
+		codecs = append(codecs, benchCodec{label: s, codec: c})
+
+		if dict != nil && name == tqfile.CodecZstd {
+			c, err := tqfile.NewCodec(name, level, dict)
+			if err != nil {
+				return nil, err
+			}
+			codecs = append(codecs, benchCodec{label: s + "+dict", codec: c})
+		}
+	}
+	if len(codecs) == 0 {
+		return nil, errors.New("no codecs given")
+	}
+
+	return codecs, nil
+}
+
+// sampleFiles - n of paths spread evenly over them, all of them for n 0
+func sampleFiles(paths []string, n int) []string {
+
+	if n == 0 || n >= len(paths) {
+		return paths
+	}
+
+	sample := make([]string, n)
+	for i := range sample {
+		sample[i] = paths[i*len(paths)/n]
+	}
+
+	return sample
+}
+
+// benchBlocks - a file's events as uncompressed blocks of up to BlockSize events
+func benchBlocks(path string) ([][]byte, int, error) {
+
+	r, err := tqfile.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer r.Close()
+
+	var blocks [][]byte
+	var events []tqfile.Event
+	n := 0
+	flush := func() error {
+		if len(events) == 0 {
+			return nil
+		}
+		b, err := tqfile.EncodeEvents(events)
+		if err != nil {
+			return err
+		}
+		blocks = append(blocks, b)
+		n += len(events)
+		events = events[:0]
+		return nil
+	}
+
+	it := r.Iter(tqfile.Filter{})
+	for it.Next() {
+		events = append(events, it.Event())
+		if len(events) == tqfile.BlockSize {
+			if err := flush(); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, 0, err
+	}
+	if err := flush(); err != nil {
+		return nil, 0, err
+	}
+
+	return blocks, n, nil
+}
+
+// benchOne - compress and decompress every block runs times, checking the
+// round trip on the first run
+func benchOne(c tqfile.Codec, blocks [][]byte, runs int) (benchResult, error) {
+
+	var res benchResult
+	compressed := make([][]byte, len(blocks))
+	var buf []byte
+
+	for run := 0; run < runs; run++ {
+
+		var size int64
+		start := time.Now()
+		for i, b := range blocks {
+			out, err := c.Encode(compressed[i][:0], b)
+			if err != nil {
+				return res, err
+			}
+			compressed[i] = out
+			size += int64(len(out))
+		}
+		if d := time.Since(start); run == 0 || d < res.encode {
+			res.encode = d
+		}
+		res.bytes = size
+
+		start = time.Now()
+		for i, b := range compressed {
+			out, err := c.Decode(buf[:0], b)
+			if err != nil {
+				return res, err
+			}
+			if run == 0 && !bytes.Equal(out, blocks[i]) {
+				return res, fmt.Errorf("block %v doesn't round trip", i)
+			}
+			buf = out
+		}
+		if d := time.Since(start); run == 0 || d < res.decode {
+			res.decode = d
+		}
+	}
+
+	return res, nil
+}
+
+// writeSamples - every block as a file in dir
+func writeSamples(dir string, blocks [][]byte) error {
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for i, b := range blocks {
+		if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("block-%05d", i)), b, 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// mb - bytes in MB
+func mb(n int64) float64 {
+	return float64(n) / (1 << 20)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jweissig/polygon/tqfile"
+)
+
+// writeCurrent - records in a current version file at path
+func writeCurrent(t *testing.T, path string, records []tqfile.TradesQuotesCombined) {
+	t.Helper()
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w, err := tqfile.NewWriter(f, tqfile.Header{Symbol: testSymbol, Date: testDate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range records {
+		if err := w.Write(r.Event()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// captureStdout - what fn prints, and its error
+func captureStdout(t *testing.T, fn func() error) (string, error) {
+	t.Helper()
+
+	f, err := ioutil.TempFile(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	stdout := os.Stdout
+	os.Stdout = f
+	err = fn()
+	os.Stdout = stdout
+
+	out, rerr := ioutil.ReadFile(f.Name())
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	return string(out), err
+}
+
+func TestBench(t *testing.T) {
+
+	dir := t.TempDir()
+	writeCurrent(t, filepath.Join(dir, tqfile.FileName(testSymbol, testDate)), testRecords(3000))
+
+	// every codec and level in the default list gets a row
+	out, err := captureStdout(t, func() error { return runBench([]string{"-runs", "1", dir}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := map[string]bool{}
+	for _, line := range strings.Split(out, "\n") {
+		if fields := strings.Fields(line); len(fields) == 5 {
+			rows[fields[0]] = true
+		}
+	}
+	for _, label := range []string{"lz4", "lz4:9", "zstd:1", "zstd", "zstd:9", "zstd:19", "none"} {
+		if !rows[label] {
+			t.Errorf("no row for %v in\n%v", label, out)
+		}
+	}
+	if !strings.Contains(out, "1 of 1 files, 3000 events in 1 blocks") {
+		t.Errorf("no sample summary in\n%v", out)
+	}
+
+	for _, c := range []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"unknown codec", []string{"-codecs", "lz4,brotli"}, "unknown codec"},
+		{"lz4 level too high", []string{"-codecs", "lz4:10"}, "lz4 level"},
+		{"zstd level too high", []string{"-codecs", "zstd:23"}, "zstd level"},
+		{"negative level", []string{"-codecs", "zstd:-1"}, "zstd level"},
+		{"level for none", []string{"-codecs", "none:1"}, "no levels"},
+		{"level that isn't a number", []string{"-codecs", "zstd:max"}, "bad level"},
+		{"no codecs", []string{"-codecs", " , "}, "no codecs"},
+		{"no runs", []string{"-runs", "0"}, "-runs"},
+	} {
+		_, err := captureStdout(t, func() error { return runBench(append(c.args, dir)) })
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%v: got %v, want %q", c.name, err, c.want)
+		}
+	}
+}
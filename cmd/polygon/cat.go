@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jweissig/polygon/calendar"
+	"github.com/jweissig/polygon/tqfile"
+)
+
+// runCat - polygon cat
+func runCat(args []string) error {
+
+	fs := flag.NewFlagSet("polygon cat", flag.ContinueOnError)
+	format := fs.String("format", "jsonl", "output format: jsonl or csv")
+	events := fs.String("events", "all", "which events to print: trades, quotes or all")
+	from := fs.String("from", "", "first time to print: RFC3339, unix nanoseconds or a New York clock time like 15:59:50")
+	to := fs.String("to", "", "time to stop before, same formats as -from")
+	limit := fs.Int("limit", 0, "stop after this many records per file (0 = no limit)")
+	header := fs.Bool("header", false, "print each file's header as a JSON line instead of its records")
+	zstdDict := fs.String("zstd-dict", "", "zstd dictionary the files were compressed with")
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: polygon cat [flags] FILE...\n\n")
+		fmt.Fprintf(fs.Output(), "Dump records from the downloader's <SYM>-<date>.gob.lz4 files.\n\n")
+		fmt.Fprintf(fs.Output(), "Example:\n  polygon cat -events trades -from 15:59:50 -to 16:00:10 -format csv SPY-2022-12-23.gob.lz4\n\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no files given")
+	}
+
+	if *zstdDict != "" {
+		if err := tqfile.LoadDict(*zstdDict); err != nil {
+			return fmt.Errorf("-zstd-dict: %w", err)
+		}
+	}
+
+	filter := tqfile.Filter{}
+	switch *events {
+	case "all", "":
+	case "trades", "T":
+		filter.Events = tqfile.Trade
+	case "quotes", "Q":
+		filter.Events = tqfile.Quote
+	default:
+		return fmt.Errorf("-events must be trades, quotes or all, got %q", *events)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	var p printer
+	switch *format {
+	case "jsonl":
+		p = &jsonPrinter{enc: json.NewEncoder(out)}
+	case "csv":
+		p = &csvPrinter{w: csv.NewWriter(out)}
+	default:
+		return fmt.Errorf("-format must be jsonl or csv, got %q", *format)
+	}
+
+	for _, path := range fs.Args() {
+
+		if *header {
+			if err := catHeader(path, out); err != nil {
+				return fmt.Errorf("%v: %w", path, err)
+			}
+			continue
+		}
+
+		// clock times are relative to the file's trading day
+		_, date, _ := tqfile.ParseFileName(path)
+
+		f := filter
+		var err error
+		if f.From, err = parseTime(*from, date); err != nil {
+			return fmt.Errorf("-from: %w", err)
+		}
+		if f.To, err = parseTime(*to, date); err != nil {
+			return fmt.Errorf("-to: %w", err)
+		}
+
+		if err := catFile(path, f, *limit, p); err != nil {
+			return fmt.Errorf("%v: %w", path, err)
+		}
+	}
+
+	return p.flush()
+}
+
+// catFile - print the matching records of one file
+func catFile(path string, filter tqfile.Filter, limit int, p printer) error {
+
+	r, err := tqfile.Open(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	n := 0
+	it := r.Iter(filter)
+	for it.Next() {
+		rec := it.Record()
+		if err := p.print(&rec); err != nil {
+			return err
+		}
+		n++
+		if limit > 0 && n >= limit {
+			break
+		}
+	}
+
+	return it.Err()
+}
+
+// catHeader - print a file's version and header as a JSON line
+func catHeader(path string, out io.Writer) error {
+
+	r, err := tqfile.Open(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return json.NewEncoder(out).Encode(struct {
+		File    string `json:"file"`
+		Version int    `json:"version"`
+		tqfile.Header
+	}{path, r.Version(), r.Header()})
+}
+
+// parseTime - RFC3339, unix ns, or a New York clock time on date; 0 when empty
+func parseTime(v, date string) (int64, error) {
+
+	if v == "" {
+		return 0, nil
+	}
+	if ns, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return ns, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return t.UnixNano(), nil
+	}
+
+	if date == "" {
+		return 0, fmt.Errorf("can't use clock time %q, file name has no date", v)
+	}
+	for _, layout := range []string{"15:04:05.999999999", "15:04:05", "15:04"} {
+		if t, err := time.ParseInLocation(calendar.DateLayout+" "+layout, date+" "+v, calendar.NewYork); err == nil {
+			return t.UnixNano(), nil
+		}
+	}
+
+	return 0, fmt.Errorf("can't parse time %q", v)
+}
+
+// printer - writes records in some format
+type printer interface {
+	print(r *tqfile.TradesQuotesCombined) error
+	flush() error
+}
+
+// jsonPrinter - one JSON object per line, only the fields for the event type
+type jsonPrinter struct {
+	enc *json.Encoder
+}
+
+// jsonTrade - trade fields of a record
+type jsonTrade struct {
+	Sym string  `json:"sym"`
+	EV  string  `json:"ev"`
+	T   int64   `json:"t"`
+	TF  int64   `json:"tf,omitempty"`
+	TQ  int     `json:"tq"`
+	TY  int64   `json:"ty"`
+	TE  int     `json:"te,omitempty"`
+	TI  string  `json:"ti"`
+	TP  float64 `json:"tp"`
+	TS  int64   `json:"ts"`
+	TC  []int   `json:"tc,omitempty"`
+	TX  int     `json:"tx"`
+	TR  int     `json:"tr,omitempty"`
+	TZ  int     `json:"tz"`
+}
+
+// jsonQuote - quote fields of a record
+type jsonQuote struct {
+	Sym string  `json:"sym"`
+	EV  string  `json:"ev"`
+	T   int64   `json:"t"`
+	QF  int64   `json:"qf,omitempty"`
+	QQ  int     `json:"qq"`
+	QY  int64   `json:"qy"`
+	QI  []int   `json:"qi,omitempty"`
+	BX  int     `json:"bx"`
+	BP  float64 `json:"bp"`
+	BS  int     `json:"bs"`
+	AX  int     `json:"ax"`
+	AP  float64 `json:"ap"`
+	AS  int     `json:"as"`
+	BSC []int   `json:"bsc,omitempty"`
+	BSZ int     `json:"bsz"`
+}
+
+func (p *jsonPrinter) print(r *tqfile.TradesQuotesCombined) error {
+	if r.EV == tqfile.Trade {
+		return p.enc.Encode(jsonTrade{r.Sym, r.EV, r.T, r.TF, r.TQ, r.TY, r.TE, r.TI, r.TP, r.TS, r.TC, r.TX, r.TR, r.TZ})
+	}
+	return p.enc.Encode(jsonQuote{r.Sym, r.EV, r.T, r.QF, r.QQ, r.QY, r.QI, r.BX, r.BP, r.BS, r.AX, r.AP, r.AS, r.BSC, r.BSZ})
+}
+
+func (p *jsonPrinter) flush() error { return nil }
+
+// csvPrinter - every field of the record, one row per record, lists joined with ;
+type csvPrinter struct {
+	w      *csv.Writer
+	header bool
+}
+
+var csvHeader = []string{
+	"sym", "ev", "t",
+	"tf", "tq", "ty", "te", "ti", "tp", "ts", "tc", "tx", "tr", "tz",
+	"qf", "qq", "qy", "qi", "bx", "bp", "bs", "ax", "ap", "as", "bsc", "bsz",
+}
+
+func (p *csvPrinter) print(r *tqfile.TradesQuotesCombined) error {
+
+	if !p.header {
+		p.header = true
+		if err := p.w.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+
+	i64 := func(v int64) string { return strconv.FormatInt(v, 10) }
+	f64 := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+
+	row := []string{r.Sym, r.EV, i64(r.T)}
+	if r.EV == tqfile.Trade {
+		row = append(row,
+			i64(r.TF), strconv.Itoa(r.TQ), i64(r.TY), strconv.Itoa(r.TE), r.TI, f64(r.TP), i64(r.TS),
+			joinInts(r.TC), strconv.Itoa(r.TX), strconv.Itoa(r.TR), strconv.Itoa(r.TZ),
+			"", "", "", "", "", "", "", "", "", "", "", "")
+	} else {
+		row = append(row,
+			"", "", "", "", "", "", "", "", "", "", "",
+			i64(r.QF), strconv.Itoa(r.QQ), i64(r.QY), joinInts(r.QI), strconv.Itoa(r.BX), f64(r.BP), strconv.Itoa(r.BS),
+			strconv.Itoa(r.AX), f64(r.AP), strconv.Itoa(r.AS), joinInts(r.BSC), strconv.Itoa(r.BSZ))
+	}
+
+	return p.w.Write(row)
+}
+
+func (p *csvPrinter) flush() error {
+	p.w.Flush()
+	return p.w.Error()
+}
+
+// joinInts - 1;12;37
+func joinInts(v []int) string {
+	s := make([]string, len(v))
+	for i, x := range v {
+		s[i] = strconv.Itoa(x)
+	}
+	return strings.Join(s, ";")
+}
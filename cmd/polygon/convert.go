@@ -0,0 +1,489 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/jweissig/polygon/tqfile"
+)
+
+// manifestName - the downloader's checkpoint file in every day dir
+const manifestName = "manifest.json"
+
+// convertJob - a file to convert and where the result goes
+type convertJob struct {
+	src string
+	dst string
+}
+
+// converted - what convertFile did with a file
+type converted struct {
+	job     convertJob
+	skipped bool // already the current version and codec, copied as is with -out
+	trades  int
+	quotes  int
+	before  int64 // bytes
+	after   int64 // bytes
+	sha256  string
+	err     error
+}
+
+// runConvert - polygon convert
+func runConvert(args []string) error {
+
+	fs := flag.NewFlagSet("polygon convert", flag.ContinueOnError)
+	out := fs.String("out", "", "write converted files under this directory instead of replacing them in place")
+	workers := fs.Int("workers", runtime.NumCPU(), "files converted at the same time")
+	codecName := fs.String("codec", tqfile.CodecLZ4, "compress blocks with lz4, zstd or none")
+	level := fs.Int("codec-level", 0, "compression level: lz4 1 (fast) to 9 (small, same as 8), zstd 1 to 22 as in the zstd command (0 = the codec's default)")
+	zstdDict := fs.String("zstd-dict", "", "zstd dictionary made with `zstd --train`, to read files compressed with it and to compress with for -codec zstd")
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: polygon convert [flags] FILE|DIR...\n\n")
+		fmt.Fprintf(fs.Output(), "Rewrite older <SYM>-<date>.gob.lz4 files in the current format (version %v), or with another codec.\n", tqfile.Version)
+		fmt.Fprintf(fs.Output(), "Directories are searched recursively, files already in the current format and codec are left alone\n")
+		fmt.Fprintf(fs.Output(), "(or copied as they are with -out).\n\n")
+		fmt.Fprintf(fs.Output(), "Examples:\n  polygon convert /scratch/historical/\n  polygon convert -codec zstd -codec-level 9 /scratch/historical/2022-12-23/\n\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no files given")
+	}
+	if *workers < 1 {
+		return fmt.Errorf("-workers must be at least 1, got %v", *workers)
+	}
+	name, dict := strings.ToLower(*codecName), ""
+	if *zstdDict != "" {
+		if err := tqfile.LoadDict(*zstdDict); err != nil {
+			return fmt.Errorf("-zstd-dict: %w", err)
+		}
+		if name == tqfile.CodecZstd {
+			dict = *zstdDict
+		}
+	}
+	codec, err := tqfile.LoadCodec(name, *level, dict)
+	if err != nil {
+		return fmt.Errorf("-codec: %w", err)
+	}
+
+	jobs, err := convertJobs(fs.Args(), *out)
+	if err != nil {
+		return err
+	}
+
+	// convert in parallel, report in order
+	results := make([]converted, len(jobs))
+	sem := make(chan bool, *workers)
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		wg.Add(1)
+		sem <- true
+		go func(i int, job convertJob) {
+			defer func() { <-sem; wg.Done() }()
+			results[i] = convertFile(job, codec)
+		}(i, job)
+	}
+	wg.Wait()
+
+	var failed, skipped int
+	updates := map[string]map[string]converted{} // dir -> file name -> result, for manifests
+	for _, res := range results {
+		name := res.job.src
+		switch {
+		case res.err != nil:
+			failed++
+			fmt.Printf("%v: %v\n", name, res.err)
+		case res.skipped:
+			skipped++
+		default:
+			fmt.Printf("%v: %v trades, %v quotes, %v -> %v bytes\n", name, res.trades, res.quotes, res.before, res.after)
+			if res.job.dst == res.job.src {
+				dir := filepath.Dir(res.job.src)
+				if updates[dir] == nil {
+					updates[dir] = map[string]converted{}
+				}
+				updates[dir][filepath.Base(res.job.src)] = res
+			}
+		}
+	}
+
+	// files converted in place no longer match the size and checksum the
+	// downloader recorded, which would make it fetch them again
+	for dir, files := range updates {
+		if err := updateManifest(dir, files); err != nil {
+			failed++
+			fmt.Printf("%v: %v\n", filepath.Join(dir, manifestName), err)
+		}
+	}
+
+	fmt.Printf("converted: %v, already version %v with %v: %v, failed: %v\n", len(jobs)-skipped-failed, tqfile.Version, codec.Name(), skipped, failed)
+	if failed > 0 {
+		return fmt.Errorf("%v failed", failed)
+	}
+	return nil
+}
+
+// convertJobs - every file to convert, files in directories keep their
+// place under out
+func convertJobs(args []string, out string) ([]convertJob, error) {
+
+	var jobs []convertJob
+	for _, arg := range args {
+
+		fi, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		if !fi.IsDir() {
+			dst := arg
+			if out != "" {
+				dst = filepath.Join(out, filepath.Base(arg))
+			}
+			jobs = append(jobs, convertJob{src: arg, dst: dst})
+			continue
+		}
+
+		err = filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			name := info.Name()
+			if info.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, tqfile.Ext) {
+				return nil
+			}
+			dst := path
+			if out != "" {
+				rel, err := filepath.Rel(arg, path)
+				if err != nil {
+					return err
+				}
+				dst = filepath.Join(out, rel)
+			}
+			jobs = append(jobs, convertJob{src: path, dst: dst})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return jobs, nil
+}
+
+// convertFile - rewrite an older file in the current version, with codec
+//
+// The new file goes to a temp file next to the destination, is read back
+// and compared event by event with the original, and only then renamed
+// into place. Files that need no converting are copied to the destination
+// if it's somewhere else.
+func convertFile(job convertJob, codec tqfile.Codec) converted {
+
+	res := converted{job: job}
+
+	r, err := tqfile.Open(job.src)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	defer r.Close()
+
+	if h := r.Header(); r.Version() == tqfile.Version && h.Codec == codec.Name() && h.ZstdDict == tqfile.DictID(codec) {
+		res.skipped = true
+		if job.dst != job.src {
+			res.err = copyFile(job.src, job.dst)
+		}
+		return res
+	}
+
+	fi, err := os.Stat(job.src)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	res.before = fi.Size()
+
+	// version 1 files only have the symbol in their records, the name has
+	// both; where and when they were downloaded isn't known
+	it := r.Iter(tqfile.Filter{})
+	more := it.Next()
+	old := r.Header()
+	h := tqfile.Header{Symbol: old.Symbol, Date: old.Date, Source: old.Source, Downloaded: old.Downloaded}
+	if symbol, date, ok := tqfile.ParseFileName(job.src); ok {
+		h.Date = date
+		if h.Symbol == "" {
+			h.Symbol = symbol
+		}
+	}
+
+	dir, name := filepath.Split(job.dst)
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			res.err = err
+			return res
+		}
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+name+".*.tmp")
+	if err != nil {
+		res.err = err
+		return res
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	tw, err := tqfile.NewWriter(tmp, h, tqfile.WithCodec(codec))
+	if err != nil {
+		res.err = err
+		return res
+	}
+
+	var last int64
+	for ; more; more = it.Next() {
+		e := it.Event()
+		if e.T() < last {
+			res.err = fmt.Errorf("records out of order at %v", e.T())
+			return res
+		}
+		last = e.T()
+		if e.Trade != nil {
+			res.trades++
+		} else {
+			res.quotes++
+		}
+		if err := tw.Write(e); err != nil {
+			res.err = err
+			return res
+		}
+	}
+	if err := it.Err(); err != nil {
+		res.err = err
+		return res
+	}
+
+	if err := tw.Close(); err != nil {
+		res.err = err
+		return res
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		res.err = err
+		return res
+	}
+	if err := tmp.Sync(); err != nil {
+		res.err = err
+		return res
+	}
+	if err := tmp.Close(); err != nil {
+		res.err = err
+		return res
+	}
+
+	// read it back before it replaces anything
+	if err := checkConverted(job.src, tmp.Name(), tw.Header()); err != nil {
+		res.err = fmt.Errorf("checking converted file: %w", err)
+		return res
+	}
+
+	if res.after, res.sha256, err = tqfile.HashFile(tmp.Name()); err != nil {
+		res.err = err
+		return res
+	}
+
+	if err := os.Rename(tmp.Name(), job.dst); err != nil {
+		res.err = err
+		return res
+	}
+	ok = true
+
+	return res
+}
+
+// checkConverted - the new file at path is complete, has the header that
+// was written and holds the same events as src in the same order; records
+// of version 1 files must also come back exactly as they were, or something
+// was lost mapping them to trades and quotes
+func checkConverted(src, path string, h tqfile.Header) error {
+
+	if err := tqfile.Verify(path); err != nil {
+		return err
+	}
+
+	old, err := tqfile.Open(src)
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+
+	r, err := tqfile.Open(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	got := r.Header()
+	if got.Symbol != h.Symbol || got.Date != h.Date || got.Trades != h.Trades || got.Quotes != h.Quotes {
+		return fmt.Errorf("header %+v, wrote %+v", got, h)
+	}
+
+	var nt, nq int
+	want, have := old.Iter(tqfile.Filter{}), r.Iter(tqfile.Filter{})
+	for n := 0; ; n++ {
+
+		more, moreNew := want.Next(), have.Next()
+		if !more || !moreNew {
+			if err := want.Err(); err != nil {
+				return fmt.Errorf("reading the original: %w", err)
+			}
+			if err := have.Err(); err != nil {
+				return err
+			}
+			if more {
+				return fmt.Errorf("the original has more than the %v records read back", n)
+			}
+			if moreNew {
+				return fmt.Errorf("read back more than the original's %v records", n)
+			}
+			break
+		}
+
+		e := want.Event()
+		if !reflect.DeepEqual(e, have.Event()) {
+			return fmt.Errorf("record %v at %v differs from the original", n, e.T())
+		}
+		if old.Version() == 1 && !reflect.DeepEqual(want.Record(), have.Record()) {
+			return fmt.Errorf("record %v at %v can't be stored as a %v without changing it", n, e.T(), e.Kind())
+		}
+		if e.Trade != nil {
+			nt++
+		} else {
+			nq++
+		}
+	}
+	if nt != h.Trades || nq != h.Quotes {
+		return fmt.Errorf("read back %v trades and %v quotes, wrote %v and %v", nt, nq, h.Trades, h.Quotes)
+	}
+
+	return nil
+}
+
+// copyFile - copy a file that needs no converting to dst, by way of a temp
+// file next to it
+func copyFile(src, dst string) error {
+
+	dir, name := filepath.Dir(dst), filepath.Base(dst)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp, err := ioutil.TempFile(dir, "."+name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err := io.Copy(tmp, in); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := tqfile.Verify(tmp.Name()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return err
+	}
+	ok = true
+
+	return nil
+}
+
+// updateManifest - point the downloader's manifest in dir at the converted files
+//
+// Only the size and checksum of the converted files change, everything
+// else in the manifest is kept as is.
+func updateManifest(dir string, files map[string]converted) error {
+
+	path := filepath.Join(dir, manifestName)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	var symbols map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(m["symbols"], &symbols); err != nil {
+		return err
+	}
+
+	for _, e := range symbols {
+		var file string
+		json.Unmarshal(e["file"], &file)
+		res, ok := files[file]
+		if !ok {
+			continue
+		}
+		e["bytes"], _ = json.Marshal(res.after)
+		e["sha256"], _ = json.Marshal(res.sha256)
+	}
+
+	if m["symbols"], err = json.Marshal(symbols); err != nil {
+		return err
+	}
+	if data, err = json.MarshalIndent(m, "", "  "); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
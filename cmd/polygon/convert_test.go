@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jweissig/polygon/tqfile"
+	"github.com/pierrec/lz4"
+)
+
+const (
+	testSymbol = "AAA"
+	testDate   = "2022-12-23"
+)
+
+// testRecords - n trades and quotes in time order with every field set
+func testRecords(n int) []tqfile.TradesQuotesCombined {
+
+	start := time.Date(2022, 12, 23, 14, 30, 0, 0, time.UTC).UnixNano()
+	records := make([]tqfile.TradesQuotesCombined, n)
+	for i := range records {
+		r := tqfile.TradesQuotesCombined{Sym: testSymbol, T: start + int64(i/2)*int64(time.Millisecond)}
+		if i%3 == 0 {
+			r.EV = tqfile.Trade
+			r.TY, r.TQ, r.TI = r.T-1000, i, strings.Repeat("7", 1+i%5)
+			r.TP, r.TS, r.TX, r.TZ = 100+float64(i%97)/100, int64(1+i%500), i%20, 1+i%3
+			if i%4 == 0 {
+				r.TC, r.TE = []int{12, 37}, 1
+			}
+			if i%5 == 0 {
+				r.TF, r.TR = r.T-500, 201
+			}
+		} else {
+			r.EV = tqfile.Quote
+			r.QY, r.QQ = r.T-2000, i
+			r.BX, r.BP, r.BS = i%19, 100+float64(i%89)/100, 1+i%7
+			r.AX, r.AP, r.AS = i%17, 100.5+float64(i%89)/100, 1+i%9
+			r.BSZ = 1 + i%3
+			if i%4 == 1 {
+				r.BSC, r.QI = []int{1}, []int{604, 607}
+			}
+		}
+		records[i] = r
+	}
+	return records
+}
+
+// writeV1 - a version 1 file: an lz4 frame of []TradesQuotesCombined batches
+func writeV1(t *testing.T, path string, records []tqfile.TradesQuotesCombined) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := lz4.NewWriter(&buf)
+	enc := gob.NewEncoder(zw)
+	for i := 0; i < len(records); i += 1000 {
+		if err := enc.Encode(records[i:min(i+1000, len(records))]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, path, buf.Bytes())
+}
+
+func writeTestFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readRecords - version and records of a file
+func readRecords(t *testing.T, path string) (int, []tqfile.TradesQuotesCombined) {
+	t.Helper()
+
+	r, err := tqfile.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	var records []tqfile.TradesQuotesCombined
+	it := r.Iter(tqfile.Filter{})
+	for it.Next() {
+		records = append(records, it.Record())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return r.Version(), records
+}
+
+func TestConvertVersion1(t *testing.T) {
+
+	records := testRecords(5000)
+	codec, err := tqfile.NewCodec(tqfile.CodecLZ4, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), tqfile.FileName(testSymbol, testDate))
+	writeV1(t, path, records)
+	if v, got := readRecords(t, path); v != 1 || !reflect.DeepEqual(got, records) {
+		t.Fatalf("test file reads back as version %v with %v records", v, len(got))
+	}
+
+	res := convertFile(convertJob{src: path, dst: path}, codec)
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if res.skipped || res.trades+res.quotes != len(records) {
+		t.Errorf("converted %+v", res)
+	}
+
+	v, got := readRecords(t, path)
+	if v != tqfile.Version {
+		t.Errorf("converted to version %v", v)
+	}
+	if len(got) != len(records) {
+		t.Fatalf("%v records after converting, want %v", len(got), len(records))
+	}
+	for i := range records {
+		if !reflect.DeepEqual(got[i], records[i]) {
+			t.Fatalf("record %v\n got %+v\nwant %+v", i, got[i], records[i])
+		}
+	}
+
+	// the header knows what it holds
+	r, err := tqfile.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := r.Header()
+	r.Close()
+	if h.Symbol != testSymbol || h.Date != testDate || h.Trades+h.Quotes != len(records) {
+		t.Errorf("header %+v", h)
+	}
+
+	// a second run leaves it alone
+	if res := convertFile(convertJob{src: path, dst: path}, codec); !res.skipped || res.err != nil {
+		t.Errorf("second run %+v", res)
+	}
+}
+
+func TestConvertRefusesLossyRecords(t *testing.T) {
+
+	// quotes have no TRF timestamp outside version 1 records
+	records := testRecords(100)
+	records[50].QF = records[50].T - 10
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, tqfile.FileName(testSymbol, testDate))
+	writeV1(t, path, records)
+	before, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codec, _ := tqfile.NewCodec(tqfile.CodecLZ4, 0, nil)
+	res := convertFile(convertJob{src: path, dst: path}, codec)
+	if res.err == nil || !strings.Contains(res.err.Error(), "record 50") {
+		t.Fatalf("converted a file it can't hold: %+v", res)
+	}
+
+	after, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Error("the original was replaced")
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("left %v files behind", len(files))
+	}
+}
+
+func TestConvertOut(t *testing.T) {
+
+	src, out := t.TempDir(), t.TempDir()
+	records := testRecords(1000)
+	old := filepath.Join(src, testDate, tqfile.FileName(testSymbol, testDate))
+	writeV1(t, old, records)
+
+	// a file already in the current format
+	current := filepath.Join(src, testDate, tqfile.FileName("BBB", testDate))
+	f, err := os.Create(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := tqfile.NewWriter(f, tqfile.Header{Symbol: "BBB", Date: testDate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range records {
+		if err := w.Write(r.Event()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if err := runConvert([]string{"-out", out, src}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the original is left alone, the converted file takes its place under -out
+	if v, _ := readRecords(t, old); v != 1 {
+		t.Errorf("original is now version %v", v)
+	}
+	if v, got := readRecords(t, filepath.Join(out, testDate, tqfile.FileName(testSymbol, testDate))); v != tqfile.Version || len(got) != len(records) {
+		t.Errorf("converted file is version %v with %v records", v, len(got))
+	}
+
+	// and the file that didn't need converting is copied over as it is
+	want, err := ioutil.ReadFile(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(filepath.Join(out, testDate, tqfile.FileName("BBB", testDate)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("current file wasn't copied as it is")
+	}
+}
+
+// min - the smaller of two ints
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,55 @@
+// polygon - tools for working with the downloader's trades + quotes files
+//
+//	polygon cat [flags] FILE...          dump records as JSON lines or CSV
+//	polygon convert [flags] FILE|DIR...  rewrite old files in the current format
+//	polygon bench [flags] FILE|DIR...    compare compression codecs on a sample of files
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// command - a polygon subcommand
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"cat", "dump records from .gob.lz4 files as JSON lines or CSV", runCat},
+	{"convert", "rewrite old .gob.lz4 files in the current format", runConvert},
+	{"bench", "compare compression codecs on a sample of .gob.lz4 files", runBench},
+}
+
+func main() {
+
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "-help" || os.Args[1] == "help" {
+		usage()
+		os.Exit(2)
+	}
+
+	for _, c := range commands {
+		if c.name != os.Args[1] {
+			continue
+		}
+		if err := c.run(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "polygon %v: %v\n", c.name, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "polygon: unknown command %q\n\n", os.Args[1])
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: polygon <command> [flags]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8v %v\n", c.name, c.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun polygon <command> -help for the command's flags.\n")
+}
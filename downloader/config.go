@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jweissig/polygon/calendar"
+	"github.com/jweissig/polygon/polygon"
+	"github.com/jweissig/polygon/tqfile"
+)
+
+// Config - everything a download run needs, built from flags + env
+type Config struct {
+	APIKey      string       // polygon api key
+	BaseURL     string       // polygon api host
+	OutputDir   string       // root dir, files go in OutputDir/<date>/
+	Days        []string     // trading days to fetch (YYYY-MM-DD)
+	Tickers     []string     // explicit tickers, empty means all active common stocks
+	Concurrency int          // number of tickers downloaded at the same time
+	Trades      bool         // fetch trades
+	Quotes      bool         // fetch quotes
+	Force       bool         // refetch symbols the manifest says are complete
+	RunSize     int          // records sorted in memory before spilling a run to disk
+	Format      string       // output format, gob or parquet
+	Layout      string       // parquet files per symbol or per day
+	RowGroupMB  int          // parquet row group size
+	Codec       tqfile.Codec // how gob files' blocks are compressed
+
+	Timeout   time.Duration       // per request timeout
+	Retry     polygon.RetryPolicy // retries for 429/5xx/timeouts
+	RateLimit float64             // requests per second across all goroutines, 0 for no limit
+	Burst     int                 // requests allowed at once before the rate kicks in
+}
+
+// parseFlags - parse the command line (and env fallbacks) into a Config
+func parseFlags(args []string) (*Config, error) {
+
+	fs := flag.NewFlagSet("downloader", flag.ContinueOnError)
+
+	apiKey := fs.String("apikey", os.Getenv("POLYGON_API_KEY"), "polygon.io API key (env POLYGON_API_KEY)")
+	baseURL := fs.String("base-url", envOr("POLYGON_BASE_URL", polygon.DefaultBaseURL), "polygon.io API base url (env POLYGON_BASE_URL)")
+	outputDir := fs.String("output", envOr("POLYGON_OUTPUT_DIR", "/scratch/historical/"), "output directory, files are written to <output>/<date>/ (env POLYGON_OUTPUT_DIR)")
+	date := fs.String("date", "", "single day to download (YYYY-MM-DD)")
+	from := fs.String("from", "", "first day of a date range to download (YYYY-MM-DD)")
+	to := fs.String("to", "", "last day of a date range to download, inclusive (YYYY-MM-DD)")
+	tickers := fs.String("tickers", "", "comma separated tickers to download (default all active common stocks)")
+	tickersFile := fs.String("tickers-file", "", "file with one ticker per line, # starts a comment")
+	concurrency := fs.Int("concurrency", 50, "number of tickers to download at the same time")
+	datasets := fs.String("datasets", "both", "which datasets to fetch: trades, quotes or both")
+	runSize := fs.Int("run-size", 200000, "records per symbol kept in memory before a sorted run is spilled to disk")
+	format := fs.String("format", formatGob, "output format: gob (one <SYM>-<date>.gob.lz4 per symbol) or parquet (separate trades and quotes files)")
+	layout := fs.String("parquet-layout", layoutSymbol, "parquet files per symbol (<SYM>-<date>.trades.parquet) or per day (trades-<date>.parquet with every symbol)")
+	rowGroupMB := fs.Int("row-group-mb", 8, "parquet row group size in MB (compressed), smaller groups let readers skip more")
+	codec := fs.String("codec", tqfile.CodecLZ4, "how gob files are compressed: lz4, zstd or none")
+	codecLevel := fs.Int("codec-level", 0, "compression level: lz4 1 (fast) to 9 (small, same as 8), zstd 1 to 22 as in the zstd command (0 = the codec's default)")
+	zstdDict := fs.String("zstd-dict", "", "zstd dictionary made with `zstd --train`, readers need it too")
+	force := fs.Bool("force", false, "refetch symbols that are already complete in the day's manifest")
+	rps := fs.Float64("rps", 0, "max requests per second across all tickers (0 = unlimited), match it to your plan")
+	burst := fs.Int("burst", 0, "requests allowed in a burst before -rps applies (default -rps rounded up)")
+	timeout := fs.Duration("timeout", 2*time.Minute, "per request timeout")
+	retries := fs.Int("retries", 5, "retries for a request that failed with 429, 5xx, a timeout or a truncated body")
+	retryWait := fs.Duration("retry-wait", time.Second, "first retry backoff, doubled (with jitter) on every retry")
+	retryMax := fs.Duration("retry-max-wait", time.Minute, "longest single backoff, also caps Retry-After")
+
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: downloader [flags]\n\n")
+		fmt.Fprintf(out, "Download all trades/quotes for one or more days and save them into sorted gob + lz4 (or parquet) files.\n\n")
+		fmt.Fprintf(out, "Examples:\n")
+		fmt.Fprintf(out, "  downloader -date 2022-12-23\n")
+		fmt.Fprintf(out, "  downloader -from 2022-12-19 -to 2022-12-23 -tickers AAPL,MSFT -datasets trades\n")
+		fmt.Fprintf(out, "  downloader -date 2022-12-23 -format parquet -parquet-layout day\n")
+		fmt.Fprintf(out, "  downloader -date 2022-12-23 -codec zstd -codec-level 9\n\n")
+		fmt.Fprintf(out, "Flags:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", strings.Join(fs.Args(), " "))
+	}
+
+	cfg := &Config{
+		APIKey:      strings.TrimSpace(*apiKey),
+		BaseURL:     *baseURL,
+		OutputDir:   *outputDir,
+		Concurrency: *concurrency,
+		Force:       *force,
+		RunSize:     *runSize,
+		Format:      strings.ToLower(*format),
+		Layout:      strings.ToLower(*layout),
+		RowGroupMB:  *rowGroupMB,
+		Timeout:     *timeout,
+		Retry: polygon.RetryPolicy{
+			MaxRetries: *retries,
+			BaseDelay:  *retryWait,
+			MaxDelay:   *retryMax,
+		},
+		RateLimit: *rps,
+		Burst:     *burst,
+	}
+
+	if cfg.APIKey == "" {
+		return nil, errors.New("missing API key: use -apikey or set POLYGON_API_KEY")
+	}
+	if cfg.OutputDir == "" {
+		return nil, errors.New("missing output directory: use -output or set POLYGON_OUTPUT_DIR")
+	}
+	if cfg.Concurrency < 1 {
+		return nil, fmt.Errorf("-concurrency must be at least 1, got %v", cfg.Concurrency)
+	}
+
+	if cfg.RunSize < minRunSize {
+		return nil, fmt.Errorf("-run-size must be at least %v, got %v", minRunSize, cfg.RunSize)
+	}
+	if cfg.Format != formatGob && cfg.Format != formatParquet {
+		return nil, fmt.Errorf("-format must be gob or parquet, got %q", *format)
+	}
+	if cfg.Layout != layoutSymbol && cfg.Layout != layoutDay {
+		return nil, fmt.Errorf("-parquet-layout must be symbol or day, got %q", *layout)
+	}
+	if cfg.RowGroupMB < 1 {
+		return nil, fmt.Errorf("-row-group-mb must be at least 1, got %v", cfg.RowGroupMB)
+	}
+	if cfg.Format == formatParquet && (*codec != tqfile.CodecLZ4 || *codecLevel != 0 || *zstdDict != "") {
+		return nil, errors.New("-codec, -codec-level and -zstd-dict only apply to -format gob, parquet files are snappy compressed")
+	}
+	c, err := tqfile.LoadCodec(strings.ToLower(*codec), *codecLevel, *zstdDict)
+	if err != nil {
+		return nil, fmt.Errorf("-codec: %w", err)
+	}
+	cfg.Codec = c
+	if cfg.Timeout <= 0 {
+		return nil, fmt.Errorf("-timeout must be positive, got %v", cfg.Timeout)
+	}
+	if cfg.Retry.MaxRetries < 0 {
+		return nil, fmt.Errorf("-retries can't be negative, got %v", cfg.Retry.MaxRetries)
+	}
+	if cfg.Retry.BaseDelay <= 0 || cfg.Retry.MaxDelay < cfg.Retry.BaseDelay {
+		return nil, fmt.Errorf("-retry-wait must be positive and no more than -retry-max-wait")
+	}
+
+	if cfg.RateLimit < 0 {
+		return nil, fmt.Errorf("-rps can't be negative, got %v", cfg.RateLimit)
+	}
+	if cfg.Burst < 0 {
+		return nil, fmt.Errorf("-burst can't be negative, got %v", cfg.Burst)
+	}
+	if cfg.Burst == 0 {
+		cfg.Burst = int(math.Ceil(cfg.RateLimit))
+	}
+
+	// datasets
+	switch strings.ToLower(*datasets) {
+	case "both":
+		cfg.Trades, cfg.Quotes = true, true
+	case "trades":
+		cfg.Trades = true
+	case "quotes":
+		cfg.Quotes = true
+	default:
+		return nil, fmt.Errorf("-datasets must be trades, quotes or both, got %q", *datasets)
+	}
+
+	// days
+	days, err := parseDays(*date, *from, *to)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Days = days
+
+	// tickers
+	if *tickers != "" && *tickersFile != "" {
+		return nil, errors.New("use either -tickers or -tickers-file, not both")
+	}
+	if *tickers != "" {
+		cfg.Tickers, err = parseTickers(strings.NewReader(strings.ReplaceAll(*tickers, ",", "\n")))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if *tickersFile != "" {
+		f, err := os.Open(*tickersFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		cfg.Tickers, err = parseTickers(f)
+		if err != nil {
+			return nil, fmt.Errorf("reading %v: %w", *tickersFile, err)
+		}
+		if len(cfg.Tickers) == 0 {
+			return nil, fmt.Errorf("no tickers found in %v", *tickersFile)
+		}
+	}
+
+	return cfg, nil
+}
+
+// sink - how symbols are written, recorded in the manifest so a rerun in
+// another format doesn't skip them
+func (c *Config) sink() string {
+	if c.Format == formatParquet {
+		return formatParquet + "-" + c.Layout
+	}
+	return formatGob
+}
+
+// parseDays - expand -date or -from/-to into a list of days
+func parseDays(date, from, to string) ([]string, error) {
+
+	if date != "" && (from != "" || to != "") {
+		return nil, errors.New("use either -date or -from/-to, not both")
+	}
+
+	if date != "" {
+		d, err := calendar.Parse(date)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -date %q, expected YYYY-MM-DD", date)
+		}
+		if !calendar.IsTradingDay(d) {
+			if name, ok := calendar.Holiday(d); ok {
+				return nil, fmt.Errorf("%v is a market holiday (%v)", date, name)
+			}
+			return nil, fmt.Errorf("%v is a %v, the market is closed", date, d.Weekday())
+		}
+		return []string{date}, nil
+	}
+
+	if from == "" || to == "" {
+		return nil, errors.New("missing day: use -date or both -from and -to")
+	}
+
+	start, err := calendar.Parse(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -from %q, expected YYYY-MM-DD", from)
+	}
+	end, err := calendar.Parse(to)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -to %q, expected YYYY-MM-DD", to)
+	}
+	if end.Before(start) {
+		return nil, fmt.Errorf("-to %v is before -from %v", to, from)
+	}
+
+	// trading sessions only, weekends and exchange holidays are skipped
+	var days []string
+	for _, d := range calendar.TradingDays(start, end) {
+		days = append(days, d.Format(calendar.DateLayout))
+	}
+
+	if len(days) == 0 {
+		return nil, fmt.Errorf("no trading days between %v and %v", from, to)
+	}
+
+	return days, nil
+}
+
+// parseTickers - read tickers one per line, skipping blanks and # comments
+func parseTickers(r io.Reader) ([]string, error) {
+
+	var tickers []string
+	seen := map[string]bool{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		ticker := strings.ToUpper(strings.TrimSpace(line))
+		if ticker == "" || seen[ticker] {
+			continue
+		}
+		seen[ticker] = true
+		tickers = append(tickers, ticker)
+	}
+
+	return tickers, scanner.Err()
+}
+
+// envOr - value of env var key, or def when unset
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
@@ -1,5 +0,0 @@
-module learning-golang/ibstockcli/experimental/corona-scanner/experimental/polygon/github/mass-data
-
-go 1.16
-
-require github.com/pierrec/lz4 v2.6.1+incompatible // indirect
@@ -1,541 +1,409 @@
-//
 // download grouped data
-//   range over each ticker
-//     download all trades
-//     download all quotes
-//     combine trades + quotes into single struct
-//     sort combined trades + quotes by time
-//     write into compressed gob + lz4
 //
+//	range over each ticker
+//	  download all trades
+//	  download all quotes
+//	  combine trades + quotes into single struct
+//	  sort combined trades + quotes by time
+//	  write into compressed gob + lz4 (or trades + quotes parquet files)
 package main
 
 import (
-	"bytes"
-	"encoding/gob"
-	"encoding/json"
+	"context"
+	"flag"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
-	"sort"
+	"os/signal"
+	"path/filepath"
+	"sync"
 	"time"
 
-	"github.com/pierrec/lz4"
+	"github.com/jweissig/polygon/polygon"
+	"github.com/jweissig/polygon/tqfile"
 )
 
-// Tickers - https://polygon.io/docs/stocks/get_v3_reference_tickers
-type Tickers struct {
-	Results []struct {
-		Ticker          string    `json:"ticker"`                     // "CPK"
-		Name            string    `json:"name"`                       // "Chesapeake Utilities"
-		Market          string    `json:"market"`                     // "stocks"
-		Locale          string    `json:"locale"`                     // "us"
-		PrimaryExchange string    `json:"primary_exchange"`           // "XNYS"
-		Type            string    `json:"type"`                       // "CS"
-		Active          bool      `json:"active"`                     //  true
-		CurrencyName    string    `json:"currency_name"`              // "usd"
-		Cik             string    `json:"cik,omitempty"`              // "0000019745"
-		CompositeFigi   string    `json:"composite_figi,omitempty"`   // "BBG000G4GKH3"
-		ShareClassFigi  string    `json:"share_class_figi,omitempty"` // "BBG001SCP5R2"
-		LastUpdatedUtc  time.Time `json:"last_updated_utc"`           // "2021-03-04T00:00:00Z
-	} `json:"results"`
-	Status    string `json:"status"`
-	RequestID string `json:"request_id"`
-	Count     int    `json:"count"`
-	NextURL   string `json:"next_url"`
+// failure - a symbol (or a whole day) we gave up on
+type failure struct {
+	Day    string
+	Symbol string // empty when the whole day failed
+	Err    error
 }
 
-// Trades - https://polygon.io/docs/stocks/get_v3_trades__stockticker
-type Trades struct {
-	Results []struct {
-		Conditions           []int   `json:"conditions"`              // A list of condition codes.
-		Correction           int     `json:"correction"`              // The trade correction indicator.
-		Exchange             int     `json:"exchange"`                // The exchange ID. See Exchanges for Polygon.io's mapping of exchange IDs.
-		ID                   string  `json:"id"`                      // The Trade ID which uniquely identifies a trade.
-		ParticipantTimestamp int64   `json:"participant_timestamp"`   // The nanosecond accuracy Participant/Exchange Unix Timestamp.
-		Price                float64 `json:"price"`                   // The price of the trade.
-		SequenceNumber       int     `json:"sequence_number"`         // The sequence number represents the sequence in which trade events happened.
-		SipTimestamp         int64   `json:"sip_timestamp"`           // The nanosecond accuracy SIP Unix Timestamp.
-		Size                 int64   `json:"size"`                    // The size of a trade (also known as volume).
-		Tape                 int     `json:"tape"`                    // There are 3 tapes which define which exchange the ticker is listed on.
-		TrfID                int     `json:"trf_id,omitempty"`        // The ID for the Trade Reporting Facility where the trade took place.
-		TrfTimestamp         int64   `json:"trf_timestamp,omitempty"` // The nanosecond accuracy TRF (Trade Reporting Facility) Unix Timestamp.
-	} `json:"results"`
-	Status    string `json:"status"`
-	RequestID string `json:"request_id"`
-	NextURL   string `json:"next_url"`
+// runSummary - what happened over the whole run, shared by all goroutines
+type runSummary struct {
+	mu       sync.Mutex
+	written  int
+	skipped  int
+	failures []failure
 }
 
-// Quotes - https://polygon.io/docs/stocks/get_v3_quotes__stockticker
-type Quotes struct {
-	Results []struct {
-		AskExchange          int     `json:"ask_exchange"`          // The ask exchange ID.
-		AskPrice             float64 `json:"ask_price"`             // The ask price.
-		AskSize              int     `json:"ask_size"`              // The ask size.
-		BidExchange          int     `json:"bid_exchange"`          // The bid exchange ID.
-		BidPrice             float64 `json:"bid_price"`             // The bid price.
-		BidSize              int     `json:"bid_size"`              // The bid size.
-		Conditions           []int   `json:"conditions"`            // A list of condition codes.
-		Indicators           []int   `json:"indicators"`            // The indicators.
-		ParticipantTimestamp int64   `json:"participant_timestamp"` // The nanosecond accuracy Participant/Exchange Unix Timestamp.
-		SequenceNumber       int     `json:"sequence_number"`       // The sequence number represents the sequence in which quote events happened.
-		SipTimestamp         int64   `json:"sip_timestamp"`         // The nanosecond accuracy SIP Unix Timestamp.
-		Tape                 int     `json:"tape"`                  // There are 3 tapes which define which exchange the ticker is listed on.
-	} `json:"results"`
-	Status    string `json:"status"`
-	RequestID string `json:"request_id"`
-	NextURL   string `json:"next_url"`
+// fail - record a permanent failure
+func (s *runSummary) fail(day, symbol string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, failure{Day: day, Symbol: symbol, Err: err})
 }
 
-// TradesQuotesCombined - trades + quotes in one combined stream
-type TradesQuotesCombined struct {
-	Sym string  // The ticker symbol for the given stock
-	EV  string  // The event type (T/Q)
-	T   int64   // The Timestamp in Unix MS
-	TF  int64   // The nanosecond accuracy TRF(Trade Reporting Facility) Unix Timestamp. This is the timestamp of when the trade reporting facility received this message.
-	TQ  int     // The sequence number representing the sequence in which trade events happened. These are increasing and unique per ticker symbol, but will not always be sequential (e.g., 1, 2, 6, 9, 10, 11).
-	TY  int64   // The nanosecond accuracy Participant/Exchange Unix Timestamp. This is the timestamp of when the quote was actually generated at the exchange.
-	TE  int     // The trade correction indicator.
-	TI  string  // The trade ID // int64?
-	TP  float64 // Trade price
-	TS  int64   // Trade size
-	TC  []int   // Trade condition
-	TX  int     // Trade exchange ID
-	TR  int     // The ID for the Trade Reporting Facility where the trade took place.
-	TZ  int     // Trade tape. (1 = NYSE, 2 = AMEX, 3 = Nasdaq)
-	QF  int64   // The nanosecond accuracy TRF(Trade Reporting Facility) Unix Timestamp. This is the timestamp of when the trade reporting facility received this message.
-	QQ  int     // The sequence number represents the sequence in which message events happened. These are increasing and unique per ticker symbol, but will not always be sequential (e.g., 1, 2, 6, 9, 10, 11).
-	QY  int64   // The nanosecond accuracy Participant/Exchange Unix Timestamp. This is the timestamp of when the quote was actually generated at the exchange.
-	QI  []int   // The indicators. For more information, see our glossary of Conditions and Indicators.
-	BX  int     // The bid exchange ID
-	BP  float64 // The bid price
-	BS  int     // The bid size. This represents the number of round lot orders at the given bid price. The normal round lot size is 100 shares. A bid size of 2 means there are 200 shares for purchase at the given bid price
-	AX  int
-	AP  float64
-	AS  int
-	BSC []int // The condition
-	BSZ int   // The tape. (1 = NYSE, 2 = AMEX, 3 = Nasdaq)
+// wrote - record a finished symbol
+func (s *runSummary) wrote() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.written++
 }
 
-var errorCount int
-var APIKEY = ""
-var OUTPUTDIR = "/scratch/historical/"
-
-func main() {
+// skip - record a symbol that was already complete
+func (s *runSummary) skip() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.skipped++
+}
 
-	days := []string{
-
-		/*
-
-			"2022-07-05", "2022-07-06", "2022-07-07", "2022-07-08", //"2022-07-04", Independence Day
-			"2022-07-11", "2022-07-12", "2022-07-13", "2022-07-14", "2022-07-15",
-			"2022-07-18", "2022-07-19", "2022-07-20", "2022-07-21", "2022-07-22",
-			"2022-07-25", "2022-07-26", "2022-07-27", "2022-07-28", "2022-07-29",
-			"2022-08-01", "2022-08-02", "2022-08-03", "2022-08-04", "2022-08-05",
-			"2022-08-08", "2022-08-09", "2022-08-10", "2022-08-11", "2022-08-12",
-			"2022-08-15", "2022-08-16", "2022-08-17", "2022-08-18", "2022-08-19",
-			"2022-08-22", "2022-08-23", "2022-08-24", "2022-08-25", "2022-08-26",
-			"2022-08-29", "2022-08-30", "2022-08-31", "2022-09-01", "2022-09-02",
-			"2022-09-06", "2022-09-07", "2022-09-08", "2022-09-09", // "2022-09-05", Labor Day
-			"2022-09-12", "2022-09-13", "2022-09-14", "2022-09-15", "2022-09-16",
-			"2022-09-19", "2022-09-20", "2022-09-21", "2022-09-22", "2022-09-23",
-			"2022-09-26", "2022-09-27", "2022-09-28", "2022-09-29", "2022-09-30",
-		*/
-		/*
-			"2022-10-03", "2022-10-04", "2022-10-05", "2022-10-06", "2022-10-07",
-			"2022-10-10", "2022-10-11", "2022-10-12", "2022-10-13", "2022-10-14",
-			"2022-10-17", "2022-10-18", "2022-10-19", "2022-10-20", "2022-10-21",
-			"2022-10-24", "2022-10-25", "2022-10-26", "2022-10-27", "2022-10-28",
-			"2022-10-31", "2022-11-01", "2022-11-02", "2022-11-03", "2022-11-04",
-			"2022-11-07", "2022-11-08", "2022-11-09", "2022-11-10", "2022-11-11",
-			"2022-11-14", "2022-11-15", "2022-11-16", "2022-11-17", "2022-11-18",
-			"2022-11-21", "2022-11-22", "2022-11-23", "2022-11-24", "2022-11-25",
-			"2022-11-28", "2022-11-29", "2022-11-30", "2022-12-01", "2022-12-02",
-			"2022-12-05", "2022-12-06", "2022-12-07", "2022-12-08", "2022-12-09",
-			"2022-12-12", "2022-12-13", "2022-12-14", "2022-12-15", "2022-12-16",
-			"2022-12-19", "2022-12-20", "2022-12-21", "2022-12-22", "2022-12-23",
-		*/
-
-		"2022-12-23",
+// print - dump the run summary
+func (s *runSummary) print() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Println("symbols written:", s.written)
+	fmt.Println("already complete:", s.skipped)
+	fmt.Println("error count:", len(s.failures))
+	for _, f := range s.failures {
+		if f.Symbol == "" {
+			fmt.Printf("  %v: %v\n", f.Day, f.Err)
+			continue
+		}
+		fmt.Printf("  %v %v: %v\n", f.Day, f.Symbol, f.Err)
 	}
+}
 
-	for _, day := range days {
-
-		t := day
-		tx := day
-
-		fmt.Println("processing:", t)
-
-		// chunk
-		var offset int     // results offset
-		var nextURL string // next results set url
-
-		// init url
-		tickersURL := fmt.Sprintf("https://api.polygon.io/v3/reference/tickers?market=stocks&type=CS&date=%v&active=true&sort=ticker&order=asc&limit=1000&apiKey=%v", tx, APIKEY)
-
-		// total results
-		var tresults Tickers
-
-		// loop to pull down all results
-		for {
-
-			// per request results
-			var trequest Tickers
-
-			// do we need to pull down additional tickers?
-			if nextURL != "" {
-				tickersURL = fmt.Sprintf("https://api.polygon.io%v&apiKey=%v", nextURL, APIKEY)
-			}
+func main() {
 
-			//fmt.Println(tickersURL)
+	cfg, err := parseFlags(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		fmt.Fprintln(os.Stderr, "run with -help for usage")
+		os.Exit(2)
+	}
 
-			tresp, terr := http.Get(tickersURL)
-			if terr != nil {
-				log.Fatalln(terr)
-			}
+	// ctrl-c stops new requests, in flight symbols fail and the manifest is saved
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
-			tbody, terr := ioutil.ReadAll(tresp.Body)
-			if terr != nil {
-				log.Fatalln(terr)
-			}
+	client := newClient(cfg)
 
-			// throw error on non-200 response
-			if tresp.StatusCode != 200 {
-				fmt.Println("HTTP Response Status:", tresp.StatusCode, tickersURL, string(tbody))
-				errorCount++
-				// dump headers
-				for name, values := range tresp.Header {
-					for _, value := range values {
-						fmt.Println(name, value)
-					}
-				}
-				panic("stopping now")
-			}
+	summary, err := run(ctx, cfg, client)
+	if err != nil {
+		log.Fatalln(err)
+	}
 
-			json.Unmarshal(tbody, &trequest)
+	summary.print()
+	fmt.Println(client.RateLimiter())
 
-			// append results
-			for _, record := range trequest.Results {
-				tresults.Results = append(tresults.Results, record)
-			}
+	if len(summary.failures) > 0 || ctx.Err() != nil {
+		os.Exit(1)
+	}
 
-			//fmt.Printf("found %v results at timestamp %v\n", len(trequest.Results), offset)
+}
 
-			// do we need to make another request?
-			if trequest.NextURL != "" {
+// newClient - polygon client set up from the config
+func newClient(cfg *Config) *polygon.Client {
+	return polygon.NewClient(cfg.APIKey,
+		polygon.WithBaseURL(cfg.BaseURL),
+		polygon.WithHTTPClient(&http.Client{Timeout: cfg.Timeout}),
+		polygon.WithRetry(cfg.Retry),
+		polygon.WithRateLimiter(polygon.NewRateLimiter(cfg.RateLimit, cfg.Burst)),
+		polygon.WithLogger(func(format string, args ...interface{}) {
+			fmt.Printf(format+"\n", args...)
+		}),
+	)
+}
 
-				// add up how man records we have seen
-				offset = offset + len(trequest.Results)
+// run - download every day in the config, per symbol failures end up in the
+// summary, only problems with the output dir itself are returned as errors
+func run(ctx context.Context, cfg *Config, client *polygon.Client) (*runSummary, error) {
 
-				// we need to parse the url path only since we're getting a weird 443 port duplicated error
-				u, err := url.Parse(trequest.NextURL)
-				if err != nil {
-					panic(err)
-				}
+	summary := &runSummary{}
 
-				nextURL = fmt.Sprintf("%v", u.RequestURI())
+	for _, day := range cfg.Days {
 
-			} else {
+		if ctx.Err() != nil {
+			break
+		}
 
-				// looks like we're at the end of the results
-				break
+		fmt.Println("processing:", day)
+
+		// use the given tickers or pull down every active stock for the day
+		symbols := cfg.Tickers
+		if len(symbols) == 0 {
+			var err error
+			symbols, err = fetchTickers(ctx, client, day)
+			if err != nil {
+				fmt.Println("skipping day:", err)
+				summary.fail(day, "", err)
+				continue
 			}
-
 		}
 
-		fmt.Printf("seeding trade/quotes with %v stocks\n", len(tresults.Results))
+		fmt.Printf("seeding trade/quotes with %v stocks\n", len(symbols))
 
 		// mkdir path
-		err := os.MkdirAll(OUTPUTDIR+t, 0755) // mkdir 2021-10-11
+		dir := filepath.Join(cfg.OutputDir, day)
+		err := os.MkdirAll(dir, 0755) // mkdir 2021-10-11
 		if err != nil {
-			log.Fatalln(err)
+			return summary, err
 		}
 
-		// http://jmoiron.net/blog/limiting-concurrency-in-go/
-		concurrency := 50 // 150
-		sem := make(chan bool, concurrency)
+		// leftovers from a crashed run
+		if err := removeStaleTemps(dir); err != nil {
+			fmt.Println("cleaning temp files:", err)
+		}
+
+		// checkpoint of what's already done for the day
+		m, err := loadManifest(dir, day)
+		if err != nil {
+			fmt.Println("skipping day:", err)
+			summary.fail(day, "", err)
+			continue
+		}
 
-		symbols := []string{}
-		for _, ticker := range tresults.Results {
-			symbols = append(symbols, ticker.Ticker)
+		// with -parquet-layout day every symbol goes into the day's shared files
+		var pd *parquetDay
+		if cfg.Format == formatParquet && cfg.Layout == layoutDay {
+			pd = newParquetDay(cfg, dir, day)
 		}
 
+		// http://jmoiron.net/blog/limiting-concurrency-in-go/
+		sem := make(chan bool, cfg.Concurrency)
+
 		// range over all tickers
 		for _, symbol := range symbols {
 
+			if ctx.Err() != nil {
+				break
+			}
+
+			// finished on a previous run?
+			if !cfg.Force && m.done(symbol, cfg.sink(), cfg.Trades, cfg.Quotes) {
+				summary.skip()
+				continue
+			}
+
 			sem <- true
 			go func(symbol string) {
 				defer func() { <-sem }()
+				res, err := downloadSymbol(ctx, cfg, client, day, symbol, pd)
+				if err != nil {
+					fmt.Printf("%v - giving up on %v: %v\n", day, symbol, err)
+					summary.fail(day, symbol, err)
+					m.failed(symbol, cfg.Trades, cfg.Quotes, err)
+					return
+				}
+				// complete once the day's files are
+				if pd != nil {
+					return
+				}
+				summary.wrote()
+				m.complete(symbol, cfg.Trades, cfg.Quotes, res)
+			}(symbol)
 
-				// store all trades and quotes
-				var tqcombined []TradesQuotesCombined
-
-				//
-				// trades
-				//
-				//var trades Trades
-
-				// fetch trades chunk
-				var tradeOffset int     // results offset
-				var tradeNextURL string // next results set url
-
-				// url
-				tradesURL := fmt.Sprintf("https://api.polygon.io/v3/trades/%v?timestamp=%v&limit=50000&apiKey=%v", symbol, t, APIKEY)
-
-				// loop to pull down all results
-				for {
-
-					// per request results
-					var request Trades
-
-					if tradeNextURL != "" {
-						tradesURL = fmt.Sprintf("https://api.polygon.io%v&apiKey=%v", tradeNextURL, APIKEY)
-					}
-
-					//fmt.Println(tradesURL)
-
-					resp, err := http.Get(tradesURL)
-					if err != nil {
-						log.Fatalln(err)
-					}
-
-					body, err := ioutil.ReadAll(resp.Body)
-					if err != nil {
-						log.Fatalln(err)
-					}
-
-					// throw error on non-200 response
-					if resp.StatusCode != 200 {
-						fmt.Println("HTTP Response Status:", resp.StatusCode, tradesURL, string(body))
-						errorCount++
-						// dump headers
-						for name, values := range resp.Header {
-							for _, value := range values {
-								fmt.Println(name, value)
-							}
-						}
-						panic("stopping now")
-					}
-
-					json.Unmarshal(body, &request)
-
-					// append to trades
-					//for _, v := range request.Results {
-					//	trades.Results = append(trades.Results, v)
-					//}
-
-					// 50k pagination logic issue
-					if len(request.Results) == 50000 && request.NextURL == "" {
-						fmt.Println("possible 50k bug issue on trades: ", tradesURL)
-
-						// dump headers
-						for name, values := range resp.Header {
-							for _, value := range values {
-								fmt.Println(name, value)
-							}
-						}
-
-					}
-
-					// append results
-					for i := range request.Results {
-
-						var v TradesQuotesCombined
-
-						v.Sym = symbol                                 // The ticker symbol for the given stock
-						v.EV = "T"                                     // The event type (T/Q)
-						v.T = request.Results[i].SipTimestamp          // The Timestamp in Unix MS
-						v.TF = request.Results[i].TrfTimestamp         // The nanosecond accuracy TRF(Trade Reporting Facility) Unix Timestamp. This is the timestamp of when the trade reporting facility received this message.
-						v.TQ = request.Results[i].SequenceNumber       // The sequence number representing the sequence in which trade events happened. These are increasing and unique per ticker symbol, but will not always be sequential (e.g., 1, 2, 6, 9, 10, 11).
-						v.TY = request.Results[i].ParticipantTimestamp // The nanosecond accuracy Participant/Exchange Unix Timestamp. This is the timestamp of when the quote was actually generated at the exchange.
-						v.TC = request.Results[i].Conditions           // Trade condition
-						v.TE = request.Results[i].Correction           // The trade correction indicator.
-						v.TI = request.Results[i].ID                   // The trade ID
-						v.TP = request.Results[i].Price                // Trade price
-						v.TR = request.Results[i].TrfID                // The ID for the Trade Reporting Facility where the trade took place.
-						v.TS = request.Results[i].Size                 // Trade size
-						v.TX = request.Results[i].Exchange             // Trade exchange ID
-						v.TZ = request.Results[i].Tape                 // Trade tape. (1 = NYSE, 2 = AMEX, 3 = Nasdaq)
-
-						tqcombined = append(tqcombined, v)
-
-					}
-
-					// do we need to make another request?
-					if request.NextURL != "" {
-
-						// add up how man records we have seen
-						tradeOffset = tradeOffset + len(request.Results)
-
-						// we need to parse the url path only since we're getting a weird 443 port duplicated error
-						u, err := url.Parse(request.NextURL)
-						if err != nil {
-							panic(err)
-						}
-
-						tradeNextURL = fmt.Sprintf("%v", u.RequestURI())
+		} // end range
 
-					} else {
-
-						// looks like we're at the end of the results
-						break
-					}
+		for i := 0; i < cap(sem); i++ {
+			sem <- true
+		}
 
-				}
+		if pd != nil {
+			commitDay(cfg, pd, m, summary)
+		}
 
-				//
-				// quotes
-				//
+		if err := m.save(); err != nil {
+			fmt.Println("saving manifest:", err)
+		}
 
-				var quoteOffset int     // results offset
-				var quoteNextURL string // next results set url
+	} // end range days
 
-				// url
-				quotesURL := fmt.Sprintf("https://api.polygon.io/v3/quotes/%v?timestamp=%v&limit=50000&apiKey=%v", symbol, t, APIKEY)
+	return summary, nil
+}
 
-				// loop to pull down all results
-				for {
+// fetchTickers - list all active common stocks for a given day
+func fetchTickers(ctx context.Context, client *polygon.Client, day string) ([]string, error) {
 
-					// per request results
-					var qrequest Quotes
+	it := client.ListTickers(ctx, polygon.TickersParams{
+		Market: "stocks",
+		Type:   "CS",
+		Date:   day,
+		Active: true,
+	})
 
-					if quoteNextURL != "" {
-						quotesURL = fmt.Sprintf("https://api.polygon.io%v&apiKey=%v", quoteNextURL, APIKEY)
-					}
+	symbols := []string{}
+	for it.Next() {
+		symbols = append(symbols, it.Ticker().Ticker)
+	}
+	if err := it.Err(); err != nil {
+		return nil, fmt.Errorf("listing tickers: %w", err)
+	}
 
-					//fmt.Println(quotesURL)
+	return symbols, nil
+}
 
-					resp, err := http.Get(quotesURL)
-					if err != nil {
-						log.Fatalln(err)
-					}
+// commitDay - finish the day's shared parquet files, and only then mark the
+// symbols in them complete
+func commitDay(cfg *Config, pd *parquetDay, m *manifest, summary *runSummary) {
 
-					body, err := ioutil.ReadAll(resp.Body)
-					if err != nil {
-						log.Fatalln(err)
-					}
+	files, err := pd.commit()
 
-					// throw error on non-200 response
-					if resp.StatusCode != 200 {
-						fmt.Println("HTTP Response Status:", resp.StatusCode, quotesURL, string(body))
-						errorCount++
-						// dump headers
-						for name, values := range resp.Header {
-							for _, value := range values {
-								fmt.Println(name, value)
-							}
-						}
-						panic("stopping now")
-					}
+	for _, s := range pd.added {
+		if err != nil {
+			fmt.Printf("%v - giving up on %v: %v\n", pd.date, s.symbol, err)
+			summary.fail(pd.date, s.symbol, err)
+			m.failed(s.symbol, cfg.Trades, cfg.Quotes, err)
+			continue
+		}
+		s.res.Files = files
+		summary.wrote()
+		m.complete(s.symbol, cfg.Trades, cfg.Quotes, s.res)
+	}
 
-					json.Unmarshal(body, &qrequest)
+	// parts left over from before -force refetched their symbols
+	if err == nil && len(files) > 0 {
+		if err := removeUnusedParts(pd.dir, pd.date, m); err != nil {
+			fmt.Println("removing old day files:", err)
+		}
+	}
+}
 
-					// 50k pagination logic issue
-					if len(qrequest.Results) == 50000 && qrequest.NextURL == "" {
-						fmt.Println("possible 50k bug issue on quotes: ", quotesURL)
+// symbolResult - what got written for one symbol/day
+type symbolResult struct {
+	Trades int           // trade records
+	Quotes int           // quote records
+	Format string        // Config.sink
+	File   string        // gob file name inside the day dir
+	Bytes  int64         // compressed size
+	SHA256 string        // checksum of the compressed file
+	Files  []writtenFile // parquet files
+}
 
-						// dump headers
-						for name, values := range resp.Header {
-							for _, value := range values {
-								fmt.Println(name, value)
-							}
-						}
+// downloadSymbol - fetch trades and/or quotes for one symbol/day, sort and write them out,
+// to the day's shared files when pd isn't nil
+func downloadSymbol(ctx context.Context, cfg *Config, client *polygon.Client, t string, symbol string, pd *parquetDay) (*symbolResult, error) {
 
-					}
+	res := &symbolResult{Format: cfg.sink()}
+	started := time.Now().UTC()
 
-					// append results
-					for i := range qrequest.Results {
+	// trades and quotes are sorted through on-disk runs so heavy names don't blow up memory
+	runs := newRunSpiller(filepath.Join(cfg.OutputDir, t), symbol, cfg.RunSize)
+	defer runs.cleanup()
 
-						var v TradesQuotesCombined
+	//
+	// trades
+	//
 
-						v.Sym = symbol                                  // The ticker symbol for the given stock
-						v.EV = "Q"                                      // The event type (T/Q)
-						v.T = qrequest.Results[i].SipTimestamp          // The Timestamp in Unix MS
-						v.QQ = qrequest.Results[i].SequenceNumber       // The sequence number represents the sequence in which message events happened. These are increasing and unique per ticker symbol, but will not always be sequential (e.g., 1, 2, 6, 9, 10, 11).
-						v.QY = qrequest.Results[i].ParticipantTimestamp // The nanosecond accuracy Participant/Exchange Unix Timestamp. This is the timestamp of when the quote was actually generated at the exchange.
-						v.QI = qrequest.Results[i].Indicators           // The indicators. For more information, see our glossary of Conditions and Indicators.
-						v.BX = qrequest.Results[i].BidExchange          // The bid exchange ID
-						v.BP = qrequest.Results[i].BidPrice             // The bid price
-						v.BS = qrequest.Results[i].BidSize              // The bid size. This represents the number of round lot orders at the given bid price. The normal round lot size is 100 shares. A bid size of 2 means there are 200 shares for purchase at the given bid price
-						v.AX = qrequest.Results[i].AskExchange          //
-						v.AP = qrequest.Results[i].AskPrice             //
-						v.AS = qrequest.Results[i].AskSize              //
-						v.BSC = qrequest.Results[i].Conditions          // The condition
-						v.BSZ = qrequest.Results[i].Tape                // The tape. (1 = NYSE, 2 = AMEX, 3 = Nasdaq)
+	if cfg.Trades {
 
-						tqcombined = append(tqcombined, v)
+		trades := client.ListTrades(ctx, symbol, polygon.TickParams{Date: t})
 
-					}
+		for trades.Next() {
 
-					// do we need to make another request?
-					if qrequest.NextURL != "" {
+			trade := trades.Trade()
 
-						// add up how man records we have seen
-						quoteOffset = quoteOffset + len(qrequest.Results)
+			v := tqfile.TradeRecord{
+				SipTimestamp:         trade.SipTimestamp,         // The nanosecond accuracy SIP Unix Timestamp.
+				ParticipantTimestamp: trade.ParticipantTimestamp, // The nanosecond accuracy Participant/Exchange Unix Timestamp. This is the timestamp of when the quote was actually generated at the exchange.
+				TrfTimestamp:         trade.TrfTimestamp,         // The nanosecond accuracy TRF(Trade Reporting Facility) Unix Timestamp. This is the timestamp of when the trade reporting facility received this message.
+				SequenceNumber:       trade.SequenceNumber,       // The sequence number representing the sequence in which trade events happened. These are increasing and unique per ticker symbol, but will not always be sequential (e.g., 1, 2, 6, 9, 10, 11).
+				ID:                   trade.ID,                   // The trade ID
+				Price:                trade.Price,                // Trade price
+				Size:                 trade.Size,                 // Trade size
+				Conditions:           trade.Conditions,           // Trade condition
+				Correction:           trade.Correction,           // The trade correction indicator.
+				Exchange:             trade.Exchange,             // Trade exchange ID
+				TrfID:                trade.TrfID,                // The ID for the Trade Reporting Facility where the trade took place.
+				Tape:                 trade.Tape,                 // Trade tape. (1 = NYSE, 2 = AMEX, 3 = Nasdaq)
+			}
 
-						// we need to parse the url path only since we're getting a weird 443 port duplicated error
-						u, err := url.Parse(qrequest.NextURL)
-						if err != nil {
-							panic(err)
-						}
+			if err := runs.add(tqfile.Event{Trade: &v}); err != nil {
+				return nil, err
+			}
+			res.Trades++
 
-						quoteNextURL = fmt.Sprintf("%v", u.RequestURI())
+		}
 
-					} else {
+		if err := trades.Err(); err != nil {
+			return nil, fmt.Errorf("trades: %w", err)
+		}
+	}
 
-						// looks like we're at the end of the results
-						break
-					}
+	//
+	// quotes
+	//
 
-				}
+	if cfg.Quotes {
 
-				// sort
-				sort.SliceStable(tqcombined, func(i, j int) bool {
-					return tqcombined[i].T < tqcombined[j].T
-				})
+		quotes := client.ListQuotes(ctx, symbol, polygon.TickParams{Date: t})
 
-				//fmt.Printf("%v - writing %v with %v records\n", t, symbol, len(tqcombined))
+		for quotes.Next() {
 
-				// gob encoding
-				buf := new(bytes.Buffer)
-				enc := gob.NewEncoder(buf)
-				enc.Encode(tqcombined)
+			quote := quotes.Quote()
 
-				zw := lz4.NewWriter(nil)
-				zfilename := fmt.Sprintf(OUTPUTDIR+t+"/%v-%v.gob.lz4", symbol, t)
-				zfile, _ := os.Create(zfilename)
-				//zfile, err := os.OpenFile(zfilename, os.O_RDWR, 644)
-				if err != nil {
-					fmt.Println(err)
-				}
-				zw.Reset(zfile)
+			v := tqfile.QuoteRecord{
+				SipTimestamp:         quote.SipTimestamp,         // The nanosecond accuracy SIP Unix Timestamp.
+				ParticipantTimestamp: quote.ParticipantTimestamp, // The nanosecond accuracy Participant/Exchange Unix Timestamp. This is the timestamp of when the quote was actually generated at the exchange.
+				SequenceNumber:       quote.SequenceNumber,       // The sequence number represents the sequence in which message events happened. These are increasing and unique per ticker symbol, but will not always be sequential (e.g., 1, 2, 6, 9, 10, 11).
+				BidExchange:          quote.BidExchange,          // The bid exchange ID
+				BidPrice:             quote.BidPrice,             // The bid price
+				BidSize:              quote.BidSize,              // The bid size. This represents the number of round lot orders at the given bid price. The normal round lot size is 100 shares. A bid size of 2 means there are 200 shares for purchase at the given bid price
+				AskExchange:          quote.AskExchange,          // The ask exchange ID
+				AskPrice:             quote.AskPrice,             // The ask price
+				AskSize:              quote.AskSize,              // The ask size in round lots
+				Conditions:           quote.Conditions,           // The condition
+				Indicators:           quote.Indicators,           // The indicators. For more information, see our glossary of Conditions and Indicators.
+				Tape:                 quote.Tape,                 // The tape. (1 = NYSE, 2 = AMEX, 3 = Nasdaq)
+			}
 
-				//_, err = io.Copy(zw, zrfile)
-				_, err = io.Copy(zw, buf)
-				if err != nil {
-					fmt.Println(err)
-				}
+			if err := runs.add(tqfile.Event{Quote: &v}); err != nil {
+				return nil, err
+			}
+			res.Quotes++
 
-				for _, c := range []io.Closer{zw, zfile} {
-					err := c.Close()
-					if err != nil {
-						fmt.Println(err)
-					}
-				}
+		}
 
-			}(symbol)
+		if err := quotes.Err(); err != nil {
+			return nil, fmt.Errorf("quotes: %w", err)
+		}
+	}
 
-		} // end range
+	//fmt.Printf("%v - writing %v with %v records\n", t, symbol, res.Trades+res.Quotes)
 
-		for i := 0; i < cap(sem); i++ {
-			sem <- true
+	// sorted records split into trades and quotes parquet files
+	if pd != nil {
+		if err := pd.add(symbol, res, runs); err != nil {
+			return nil, fmt.Errorf("writing: %w", err)
+		}
+		return res, nil
+	}
+	if cfg.Format == formatParquet {
+		files, err := writeSymbolParquet(cfg, filepath.Join(cfg.OutputDir, t), symbol, t, runs)
+		if err != nil {
+			return nil, fmt.Errorf("writing: %w", err)
 		}
+		res.Files = files
+		return res, nil
+	}
 
-	} // end range days
+	// merge the sorted runs into gob batches + lz4, written to a temp file and renamed into place
+	res.File = tqfile.FileName(symbol, t)
+	h := tqfile.Header{Symbol: symbol, Date: t, Source: cfg.BaseURL + "/" + polygon.APIVersion, Downloaded: started}
+	wf, err := writeTQ(filepath.Join(cfg.OutputDir, t, res.File), h, cfg.Codec, runs.each)
+	if err != nil {
+		return nil, fmt.Errorf("writing: %w", err)
+	}
 
-	fmt.Println("error count:", errorCount)
+	res.Bytes = wf.Bytes
+	res.SHA256 = wf.SHA256
 
+	return res, nil
 }
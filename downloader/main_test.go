@@ -0,0 +1,437 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jweissig/polygon/polygon/polygontest"
+	"github.com/jweissig/polygon/tqfile"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+const testDate = "2022-12-23"
+
+// testConfig - flags pointing the downloader at srv and a temp output dir, with fast retries
+func testConfig(t *testing.T, srv *polygontest.Server, args ...string) *Config {
+	t.Helper()
+
+	args = append([]string{
+		"-apikey", polygontest.APIKey,
+		"-base-url", srv.URL,
+		"-output", t.TempDir(),
+		"-date", testDate,
+		"-retry-wait", "1ms",
+		"-retry-max-wait", "10ms",
+	}, args...)
+
+	cfg, err := parseFlags(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// checkFile - the symbol's file is the current version and has every trade and quote, in time order
+func checkFile(t *testing.T, cfg *Config, symbol string, trades, quotes int) {
+	t.Helper()
+
+	path := filepath.Join(cfg.OutputDir, testDate, tqfile.FileName(symbol, testDate))
+
+	r, err := tqfile.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	h := r.Header()
+	if r.Version() != tqfile.Version || h.Symbol != symbol || h.Date != testDate {
+		t.Errorf("%v: version %v with header %+v", symbol, r.Version(), h)
+	}
+	if h.Trades != trades || h.Quotes != quotes || h.Source != cfg.BaseURL+"/v3" || h.Downloaded.IsZero() {
+		t.Errorf("%v: header %+v, want %v trades and %v quotes", symbol, h, trades, quotes)
+	}
+	if err := tqfile.Verify(path); err != nil {
+		t.Errorf("%v: %v", symbol, err)
+	}
+
+	records, err := tqfile.ReadAll(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var nt, nq int
+	for i, r := range records {
+		if r.Sym != symbol {
+			t.Fatalf("record %v is for %v, want %v", i, r.Sym, symbol)
+		}
+		if i > 0 && r.T < records[i-1].T {
+			t.Fatalf("record %v out of order: %v after %v", i, r.T, records[i-1].T)
+		}
+		switch r.EV {
+		case tqfile.Trade:
+			nt++
+		case tqfile.Quote:
+			nq++
+		}
+	}
+	if nt != trades || nq != quotes {
+		t.Errorf("%v: got %v trades and %v quotes, want %v and %v", symbol, nt, nq, trades, quotes)
+	}
+	if len(records) > 0 && (h.MinT != records[0].T || h.MaxT != records[len(records)-1].T) {
+		t.Errorf("%v: header time range %v to %v, records %v to %v", symbol, h.MinT, h.MaxT, records[0].T, records[len(records)-1].T)
+	}
+
+	// a time range seeks through the block index to the same records
+	if len(records) < 4 {
+		return
+	}
+	from, to := records[len(records)/4].T, records[len(records)/2].T
+	var want int
+	for _, r := range records {
+		if r.T >= from && r.T < to {
+			want++
+		}
+	}
+	r, err = tqfile.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var got int
+	it := r.Iter(tqfile.Filter{From: from, To: to})
+	for it.Next() {
+		got++
+	}
+	if it.Err() != nil || got != want {
+		t.Errorf("%v: %v records from %v to %v, want %v (%v)", symbol, got, from, to, want, it.Err())
+	}
+}
+
+// tempFiles - leftover hidden temp files or run dirs in the day dir
+func tempFiles(t *testing.T, cfg *Config) []string {
+	entries, err := ioutil.ReadDir(filepath.Join(cfg.OutputDir, testDate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tmp []string
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), tmpSuffix) {
+			tmp = append(tmp, e.Name())
+		}
+	}
+	return tmp
+}
+
+func TestDownloadEndToEnd(t *testing.T) {
+
+	srv := polygontest.NewServer()
+	defer srv.Close()
+
+	srv.AddTicker("AAA", "BBB")
+	srv.SetTrades("AAA", polygontest.GenerateTrades(testDate, 12000, 1))
+	srv.SetQuotes("AAA", polygontest.GenerateQuotes(testDate, 13000, 2))
+	srv.SetTrades("BBB", polygontest.GenerateTrades(testDate, 700, 3))
+	srv.SetQuotes("BBB", polygontest.GenerateQuotes(testDate, 1200, 4))
+	srv.SetPageLimit(5000)
+
+	// every kind of transient failure, all of which should be retried away
+	srv.Fail(polygontest.Fault{Path: "/v3/reference/tickers", Status: 502, Times: 1})
+	srv.Fail(polygontest.Fault{Path: "/v3/trades/AAA", Status: 429, RetryAfter: "1", Times: 2})
+	srv.Fail(polygontest.Fault{Path: "/v3/quotes/AAA", Truncate: true, Times: 1})
+	srv.Fail(polygontest.Fault{Path: "/v3/quotes/BBB", Status: 500, Times: 2})
+
+	// small runs so AAA goes through the on-disk merge
+	cfg := testConfig(t, srv, "-run-size", "10000")
+
+	summary, err := run(context.Background(), cfg, newClient(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.failures) > 0 || summary.written != 2 {
+		t.Fatalf("wrote %v files with failures %v", summary.written, summary.failures)
+	}
+
+	checkFile(t, cfg, "AAA", 12000, 13000)
+	checkFile(t, cfg, "BBB", 700, 1200)
+
+	if tmp := tempFiles(t, cfg); len(tmp) > 0 {
+		t.Errorf("temp files left behind: %v", tmp)
+	}
+
+	m, err := loadManifest(filepath.Join(cfg.OutputDir, testDate), testDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := m.Symbols["AAA"]; e == nil || e.Status != statusComplete || e.NTrades != 12000 || e.NQuotes != 13000 || e.SHA256 == "" {
+		t.Errorf("manifest entry for AAA: %+v", e)
+	}
+
+	// a rerun finds everything done and only asks for the ticker list
+	before := len(srv.Requests())
+	summary, err = run(context.Background(), cfg, newClient(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.skipped != 2 || summary.written != 0 {
+		t.Errorf("rerun wrote %v and skipped %v, want 0 and 2", summary.written, summary.skipped)
+	}
+	for _, r := range srv.Requests()[before:] {
+		if !strings.HasPrefix(r, "/v3/reference/tickers") {
+			t.Errorf("rerun fetched %v", r)
+		}
+	}
+}
+
+func TestDownloadFailures(t *testing.T) {
+
+	srv := polygontest.NewServer()
+	defer srv.Close()
+
+	srv.SetTrades("GOOD", polygontest.GenerateTrades(testDate, 500, 1))
+	srv.SetQuotes("GOOD", polygontest.GenerateQuotes(testDate, 500, 2))
+	srv.SetTrades("DEAD", polygontest.GenerateTrades(testDate, 500, 3))
+	srv.SetTrades("FLAKY", polygontest.GenerateTrades(testDate, 500, 4))
+
+	srv.Fail(polygontest.Fault{Path: "/v3/trades/DEAD", Status: 404})
+	srv.Fail(polygontest.Fault{Path: "/v3/quotes/FLAKY", Status: 503})
+
+	cfg := testConfig(t, srv, "-tickers", "GOOD,DEAD,FLAKY", "-retries", "2")
+
+	summary, err := run(context.Background(), cfg, newClient(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one bad symbol doesn't take the rest of the day with it
+	if summary.written != 1 || len(summary.failures) != 2 {
+		t.Fatalf("wrote %v files with failures %v", summary.written, summary.failures)
+	}
+	checkFile(t, cfg, "GOOD", 500, 500)
+
+	for _, symbol := range []string{"DEAD", "FLAKY"} {
+		if _, err := os.Stat(filepath.Join(cfg.OutputDir, testDate, tqfile.FileName(symbol, testDate))); !os.IsNotExist(err) {
+			t.Errorf("%v: file written for a failed symbol", symbol)
+		}
+	}
+	if tmp := tempFiles(t, cfg); len(tmp) > 0 {
+		t.Errorf("temp files left behind: %v", tmp)
+	}
+
+	m, err := loadManifest(filepath.Join(cfg.OutputDir, testDate), testDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := m.Symbols["DEAD"]; e == nil || e.Status != statusFailed || !strings.Contains(e.Error, "404") {
+		t.Errorf("manifest entry for DEAD: %+v", e)
+	}
+	if e := m.Symbols["FLAKY"]; e == nil || e.Status != statusFailed || !strings.Contains(e.Error, "503") {
+		t.Errorf("manifest entry for FLAKY: %+v", e)
+	}
+
+	// once polygon recovers a rerun picks up only the failed symbols
+	srv2 := polygontest.NewServer()
+	defer srv2.Close()
+	srv2.SetTrades("DEAD", polygontest.GenerateTrades(testDate, 500, 3))
+	srv2.SetTrades("FLAKY", polygontest.GenerateTrades(testDate, 500, 4))
+	cfg.BaseURL = srv2.URL
+
+	summary, err = run(context.Background(), cfg, newClient(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.written != 2 || summary.skipped != 1 || len(summary.failures) != 0 {
+		t.Errorf("rerun wrote %v, skipped %v, failures %v", summary.written, summary.skipped, summary.failures)
+	}
+	checkFile(t, cfg, "FLAKY", 500, 0)
+}
+
+func TestDownloadCodecs(t *testing.T) {
+
+	srv := polygontest.NewServer()
+	defer srv.Close()
+
+	srv.SetTrades("AAA", polygontest.GenerateTrades(testDate, 3000, 1))
+	srv.SetQuotes("AAA", polygontest.GenerateQuotes(testDate, 4000, 2))
+
+	for _, args := range [][]string{
+		{"-codec", "lz4", "-codec-level", "9"},
+		{"-codec", "zstd"},
+		{"-codec", "zstd", "-codec-level", "19"},
+		{"-codec", "none"},
+	} {
+		cfg := testConfig(t, srv, append([]string{"-tickers", "AAA"}, args...)...)
+
+		summary, err := run(context.Background(), cfg, newClient(cfg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary.written != 1 || len(summary.failures) > 0 {
+			t.Fatalf("%v: wrote %v files with failures %v", args, summary.written, summary.failures)
+		}
+		checkFile(t, cfg, "AAA", 3000, 4000)
+
+		r, err := tqfile.Open(filepath.Join(cfg.OutputDir, testDate, tqfile.FileName("AAA", testDate)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Close()
+		if c := r.Header().Codec; c != args[1] {
+			t.Errorf("%v: file compressed with %v", args, c)
+		}
+	}
+
+	// bad codecs, levels and codecs for parquet are caught up front
+	for _, args := range [][]string{
+		{"-codec", "gzip"},
+		{"-codec", "lz4", "-codec-level", "10"},
+		{"-codec", "none", "-codec-level", "1"},
+		{"-codec", "lz4", "-zstd-dict", "missing.dict"},
+		{"-format", "parquet", "-codec", "zstd"},
+	} {
+		if _, err := parseFlags(append([]string{"-apikey", "x", "-date", testDate}, args...)); err == nil {
+			t.Errorf("%v: no error", args)
+		}
+	}
+}
+
+// readParquet - every row of a parquet file into rows, a pointer to a slice
+func readParquet(t *testing.T, path string, obj interface{}, rows interface{}) {
+	t.Helper()
+
+	fr, err := local.NewLocalFileReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fr.Close()
+
+	pr, err := reader.NewParquetReader(fr, obj, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+
+	// Read fills as many rows as the slice has room for
+	n := int(pr.GetNumRows())
+	v := reflect.ValueOf(rows).Elem()
+	v.Set(reflect.MakeSlice(v.Type(), n, n))
+
+	if err := pr.Read(rows); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkParquet - the trades and quotes files have the symbols' rows, each symbol in time order
+func checkParquet(t *testing.T, cfg *Config, tradesFile, quotesFile string, trades, quotes map[string]int) {
+	t.Helper()
+
+	var tr []parquetTrade
+	readParquet(t, filepath.Join(cfg.OutputDir, testDate, tradesFile), new(parquetTrade), &tr)
+	var qr []parquetQuote
+	readParquet(t, filepath.Join(cfg.OutputDir, testDate, quotesFile), new(parquetQuote), &qr)
+
+	nt, last := map[string]int{}, map[string]int64{}
+	for i, r := range tr {
+		if r.SipTimestamp < last[r.Sym] {
+			t.Fatalf("%v: trade %v out of order", tradesFile, i)
+		}
+		if r.Price == 0 || r.Size == 0 || r.Conditions == nil {
+			t.Fatalf("%v: trade %v missing fields: %+v", tradesFile, i, r)
+		}
+		last[r.Sym] = r.SipTimestamp
+		nt[r.Sym]++
+	}
+
+	nq, last := map[string]int{}, map[string]int64{}
+	for i, r := range qr {
+		if r.SipTimestamp < last[r.Sym] {
+			t.Fatalf("%v: quote %v out of order", quotesFile, i)
+		}
+		if r.BidPrice == 0 || r.AskPrice == 0 {
+			t.Fatalf("%v: quote %v missing prices: %+v", quotesFile, i, r)
+		}
+		last[r.Sym] = r.SipTimestamp
+		nq[r.Sym]++
+	}
+
+	for symbol := range trades {
+		if nt[symbol] != trades[symbol] || nq[symbol] != quotes[symbol] {
+			t.Errorf("%v: got %v trades and %v quotes, want %v and %v", symbol, nt[symbol], nq[symbol], trades[symbol], quotes[symbol])
+		}
+	}
+	if len(nt) != len(trades) {
+		t.Errorf("%v: symbols %v, want %v", tradesFile, nt, trades)
+	}
+}
+
+func TestDownloadParquet(t *testing.T) {
+
+	srv := polygontest.NewServer()
+	defer srv.Close()
+
+	srv.AddTicker("AAA", "BBB")
+	srv.SetTrades("AAA", polygontest.GenerateTrades(testDate, 12000, 1))
+	srv.SetQuotes("AAA", polygontest.GenerateQuotes(testDate, 13000, 2))
+	srv.SetTrades("BBB", polygontest.GenerateTrades(testDate, 700, 3))
+	srv.SetQuotes("BBB", polygontest.GenerateQuotes(testDate, 1200, 4))
+
+	trades := map[string]int{"AAA": 12000, "BBB": 700}
+	quotes := map[string]int{"AAA": 13000, "BBB": 1200}
+
+	// a pair of files per symbol, AAA through the on-disk merge
+	cfg := testConfig(t, srv, "-format", "parquet", "-run-size", "10000")
+
+	summary, err := run(context.Background(), cfg, newClient(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.failures) > 0 || summary.written != 2 {
+		t.Fatalf("wrote %v symbols with failures %v", summary.written, summary.failures)
+	}
+	for _, symbol := range []string{"AAA", "BBB"} {
+		checkParquet(t, cfg, symbolParquetName(symbol, testDate, "trades"), symbolParquetName(symbol, testDate, "quotes"),
+			map[string]int{symbol: trades[symbol]}, map[string]int{symbol: quotes[symbol]})
+	}
+	if tmp := tempFiles(t, cfg); len(tmp) > 0 {
+		t.Errorf("temp files left behind: %v", tmp)
+	}
+
+	// one pair of files for the whole day
+	day := testConfig(t, srv, "-format", "parquet", "-parquet-layout", "day")
+
+	summary, err = run(context.Background(), day, newClient(day))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.failures) > 0 || summary.written != 2 {
+		t.Fatalf("wrote %v symbols with failures %v", summary.written, summary.failures)
+	}
+	checkParquet(t, day, dayParquetName(testDate, "trades", 0), dayParquetName(testDate, "quotes", 0), trades, quotes)
+
+	m, err := loadManifest(filepath.Join(day.OutputDir, testDate), testDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := m.Symbols["BBB"]; e == nil || e.Status != statusComplete || e.Format != "parquet-day" || len(e.Files) != 2 || e.Files[0].Name != dayParquetName(testDate, "trades", 0) {
+		t.Errorf("manifest entry for BBB: %+v", e)
+	}
+
+	// the same day as gob isn't done yet
+	if m.done("BBB", formatGob, true, true) || !m.done("BBB", day.sink(), true, true) {
+		t.Error("manifest doesn't tell formats apart")
+	}
+
+	// a forced rerun writes the next part and drops the old one
+	day.Force = true
+	if _, err := run(context.Background(), day, newClient(day)); err != nil {
+		t.Fatal(err)
+	}
+	checkParquet(t, day, dayParquetName(testDate, "trades", 1), dayParquetName(testDate, "quotes", 1), trades, quotes)
+	if _, err := os.Stat(filepath.Join(day.OutputDir, testDate, dayParquetName(testDate, "trades", 0))); !os.IsNotExist(err) {
+		t.Error("old day part left behind")
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// manifestName - checkpoint file kept in every <output>/<date>/ dir
+const manifestName = "manifest.json"
+
+// how often (at most) the manifest is flushed to disk while a day is running
+const manifestFlushEvery = 2 * time.Second
+
+// symbol states in the manifest
+const (
+	statusComplete = "complete"
+	statusFailed   = "failed"
+)
+
+// manifestEntry - the state of one symbol for the day
+type manifestEntry struct {
+	Status  string        `json:"status"`           // complete / failed
+	Trades  bool          `json:"trades"`           // trades were requested
+	Quotes  bool          `json:"quotes"`           // quotes were requested
+	Format  string        `json:"format,omitempty"` // how it was written, see Config.sink, empty for gob
+	NTrades int           `json:"n_trades"`         // trade records written
+	NQuotes int           `json:"n_quotes"`         // quote records written
+	File    string        `json:"file,omitempty"`   // gob file name inside the day dir
+	Bytes   int64         `json:"bytes,omitempty"`  // size of the file on disk
+	SHA256  string        `json:"sha256,omitempty"` // checksum of the file on disk
+	Files   []writtenFile `json:"files,omitempty"`  // parquet files holding the symbol
+	Error   string        `json:"error,omitempty"`  // last error for failed symbols
+	Updated time.Time     `json:"updated"`          // when this entry was written
+}
+
+// manifest - per day checkpoint so reruns can skip finished symbols
+type manifest struct {
+	Day     string                    `json:"day"`
+	Symbols map[string]*manifestEntry `json:"symbols"`
+
+	mu    sync.Mutex
+	dir   string
+	dirty bool
+	saved time.Time
+}
+
+// loadManifest - read the manifest for a day dir, or start an empty one
+func loadManifest(dir, day string) (*manifest, error) {
+
+	m := &manifest{Day: day, Symbols: map[string]*manifestEntry{}, dir: dir}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, manifestName))
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("%v: %w", filepath.Join(dir, manifestName), err)
+	}
+	if m.Symbols == nil {
+		m.Symbols = map[string]*manifestEntry{}
+	}
+
+	return m, nil
+}
+
+// done - is the symbol already complete for the requested datasets, in the
+// requested format, with its files still on disk?
+func (m *manifest) done(symbol, format string, trades, quotes bool) bool {
+
+	m.mu.Lock()
+	e, ok := m.Symbols[symbol]
+	m.mu.Unlock()
+
+	if !ok || e.Status != statusComplete {
+		return false
+	}
+	if (trades && !e.Trades) || (quotes && !e.Quotes) {
+		return false
+	}
+	if e.Format != format && !(e.Format == "" && format == formatGob) {
+		return false
+	}
+
+	// the files must still be there and look like the ones we wrote
+	files := e.Files
+	if len(files) == 0 {
+		files = []writtenFile{{Name: e.File, Bytes: e.Bytes}}
+	}
+	for _, f := range files {
+		fi, err := os.Stat(filepath.Join(m.dir, f.Name))
+		if err != nil || fi.Size() != f.Bytes {
+			return false
+		}
+	}
+
+	return true
+}
+
+// files - every file a symbol in the manifest points at
+func (m *manifest) files() map[string]bool {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	used := map[string]bool{}
+	for _, e := range m.Symbols {
+		if e.File != "" {
+			used[e.File] = true
+		}
+		for _, f := range e.Files {
+			used[f.Name] = true
+		}
+	}
+
+	return used
+}
+
+// complete - mark a symbol as finished
+func (m *manifest) complete(symbol string, trades, quotes bool, res *symbolResult) {
+	m.set(symbol, &manifestEntry{
+		Status:  statusComplete,
+		Trades:  trades,
+		Quotes:  quotes,
+		Format:  res.Format,
+		NTrades: res.Trades,
+		NQuotes: res.Quotes,
+		File:    res.File,
+		Bytes:   res.Bytes,
+		SHA256:  res.SHA256,
+		Files:   res.Files,
+		Updated: time.Now().UTC(),
+	})
+}
+
+// failed - mark a symbol as failed so the next run retries it
+func (m *manifest) failed(symbol string, trades, quotes bool, err error) {
+	m.set(symbol, &manifestEntry{
+		Status:  statusFailed,
+		Trades:  trades,
+		Quotes:  quotes,
+		Error:   err.Error(),
+		Updated: time.Now().UTC(),
+	})
+}
+
+// set - update an entry, flushing to disk if it's been a while
+func (m *manifest) set(symbol string, e *manifestEntry) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Symbols[symbol] = e
+	m.dirty = true
+
+	if time.Since(m.saved) >= manifestFlushEvery {
+		if err := m.saveLocked(); err != nil {
+			fmt.Println("saving manifest:", err)
+		}
+	}
+}
+
+// save - write the manifest to disk if anything changed
+func (m *manifest) save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.saveLocked()
+}
+
+// saveLocked - write tmp + rename so a crash never leaves half a manifest
+func (m *manifest) saveLocked() error {
+
+	if !m.dirty {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(m.dir, manifestName)
+	tmp := path + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	m.dirty = false
+	m.saved = time.Now()
+
+	return nil
+}
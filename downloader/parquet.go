@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jweissig/polygon/tqfile"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// output formats
+const (
+	formatGob     = "gob"     // <SYM>-<date>.gob.lz4, trades and quotes in one stream
+	formatParquet = "parquet" // separate trades and quotes parquet files
+)
+
+// parquet layouts
+const (
+	layoutSymbol = "symbol" // <SYM>-<date>.trades.parquet and <SYM>-<date>.quotes.parquet
+	layoutDay    = "day"    // trades-<date>.parquet and quotes-<date>.parquet with every symbol
+)
+
+// parquet magic number, first and last 4 bytes of every .parquet file
+const parquetMagic = "PAR1"
+
+// parquetTrade - a row of a trades parquet file, columns are named as in the polygon.io API
+//
+// Timestamps are nanosecond UTC timestamps, the ones only some trades have
+// are null when missing, as is trf_id.
+type parquetTrade struct {
+	Sym                  string  `parquet:"name=sym, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	SipTimestamp         int64   `parquet:"name=sip_timestamp, type=INT64, logicaltype=TIMESTAMP, logicaltype.isadjustedtoutc=true, logicaltype.unit=NANOS"`
+	ParticipantTimestamp *int64  `parquet:"name=participant_timestamp, type=INT64, logicaltype=TIMESTAMP, logicaltype.isadjustedtoutc=true, logicaltype.unit=NANOS, repetitiontype=OPTIONAL"`
+	TrfTimestamp         *int64  `parquet:"name=trf_timestamp, type=INT64, logicaltype=TIMESTAMP, logicaltype.isadjustedtoutc=true, logicaltype.unit=NANOS, repetitiontype=OPTIONAL"`
+	SequenceNumber       int64   `parquet:"name=sequence_number, type=INT64"`
+	ID                   string  `parquet:"name=id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Price                float64 `parquet:"name=price, type=DOUBLE"`
+	Size                 int64   `parquet:"name=size, type=INT64"`
+	Conditions           []int32 `parquet:"name=conditions, type=LIST, valuetype=INT32"`
+	Correction           int32   `parquet:"name=correction, type=INT32"`
+	Exchange             int32   `parquet:"name=exchange, type=INT32"`
+	TrfID                *int32  `parquet:"name=trf_id, type=INT32, repetitiontype=OPTIONAL"`
+	Tape                 int32   `parquet:"name=tape, type=INT32"`
+}
+
+// parquetQuote - a row of a quotes parquet file, columns are named as in the polygon.io API
+type parquetQuote struct {
+	Sym                  string  `parquet:"name=sym, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	SipTimestamp         int64   `parquet:"name=sip_timestamp, type=INT64, logicaltype=TIMESTAMP, logicaltype.isadjustedtoutc=true, logicaltype.unit=NANOS"`
+	ParticipantTimestamp *int64  `parquet:"name=participant_timestamp, type=INT64, logicaltype=TIMESTAMP, logicaltype.isadjustedtoutc=true, logicaltype.unit=NANOS, repetitiontype=OPTIONAL"`
+	SequenceNumber       int64   `parquet:"name=sequence_number, type=INT64"`
+	BidExchange          int32   `parquet:"name=bid_exchange, type=INT32"`
+	BidPrice             float64 `parquet:"name=bid_price, type=DOUBLE"`
+	BidSize              int64   `parquet:"name=bid_size, type=INT64"`
+	AskExchange          int32   `parquet:"name=ask_exchange, type=INT32"`
+	AskPrice             float64 `parquet:"name=ask_price, type=DOUBLE"`
+	AskSize              int64   `parquet:"name=ask_size, type=INT64"`
+	Conditions           []int32 `parquet:"name=conditions, type=LIST, valuetype=INT32"`
+	Indicators           []int32 `parquet:"name=indicators, type=LIST, valuetype=INT32"`
+	Tape                 int32   `parquet:"name=tape, type=INT32"`
+}
+
+// tradeRow - a trade as a parquet row
+func tradeRow(symbol string, r *tqfile.TradeRecord) *parquetTrade {
+	row := &parquetTrade{
+		Sym:                  symbol,
+		SipTimestamp:         r.SipTimestamp,
+		ParticipantTimestamp: optional64(r.ParticipantTimestamp),
+		TrfTimestamp:         optional64(r.TrfTimestamp),
+		SequenceNumber:       int64(r.SequenceNumber),
+		ID:                   r.ID,
+		Price:                r.Price,
+		Size:                 r.Size,
+		Conditions:           ints32(r.Conditions),
+		Correction:           int32(r.Correction),
+		Exchange:             int32(r.Exchange),
+		Tape:                 int32(r.Tape),
+	}
+	if r.TrfID != 0 {
+		id := int32(r.TrfID)
+		row.TrfID = &id
+	}
+	return row
+}
+
+// quoteRow - a quote as a parquet row
+func quoteRow(symbol string, r *tqfile.QuoteRecord) *parquetQuote {
+	return &parquetQuote{
+		Sym:                  symbol,
+		SipTimestamp:         r.SipTimestamp,
+		ParticipantTimestamp: optional64(r.ParticipantTimestamp),
+		SequenceNumber:       int64(r.SequenceNumber),
+		BidExchange:          int32(r.BidExchange),
+		BidPrice:             r.BidPrice,
+		BidSize:              int64(r.BidSize),
+		AskExchange:          int32(r.AskExchange),
+		AskPrice:             r.AskPrice,
+		AskSize:              int64(r.AskSize),
+		Conditions:           ints32(r.Conditions),
+		Indicators:           ints32(r.Indicators),
+		Tape:                 int32(r.Tape),
+	}
+}
+
+// optional64 - nil for a timestamp polygon left out
+func optional64(v int64) *int64 {
+	if v == 0 {
+		return nil
+	}
+	return &v
+}
+
+// ints32 - condition codes as parquet INT32s, never nil
+func ints32(v []int) []int32 {
+	x := make([]int32, len(v))
+	for i, n := range v {
+		x[i] = int32(n)
+	}
+	return x
+}
+
+// parquetFile - a trades or quotes parquet file being written, atomically
+type parquetFile struct {
+	f  *atomicFile
+	pw *writer.ParquetWriter
+}
+
+// createParquet - start a parquet file at path with rows like obj
+//
+// Row groups are cut every rowGroup bytes (compressed), small enough that
+// readers filtering on sym or sip_timestamp can skip most of a busy file
+// using each group's min/max statistics.
+func createParquet(path string, obj interface{}, rowGroup int64) (*parquetFile, error) {
+
+	f, err := createAtomic(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pw, err := writer.NewParquetWriterFromWriter(f, obj, 1)
+	if err != nil {
+		f.abort()
+		return nil, err
+	}
+	// the writer only checks the row group size every page size x columns
+	// worth of rows, keep pages small enough for that to happen often
+	pw.RowGroupSize = rowGroup
+	pw.PageSize = 64 << 10
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+
+	return &parquetFile{f: f, pw: pw}, nil
+}
+
+// commit - write the footer and move the file into place
+func (p *parquetFile) commit() (*writtenFile, error) {
+	if err := p.pw.WriteStop(); err != nil {
+		return nil, fmt.Errorf("writing %v: %w", filepath.Base(p.f.path), err)
+	}
+	return p.f.commit(verifyParquet)
+}
+
+// verifyParquet - re-read the file and check it has the parquet magic at both ends
+func verifyParquet(path string) error {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+
+	// magic + footer length + magic
+	if size < 4+4+4 {
+		return fmt.Errorf("file too small (%v bytes)", size)
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return err
+	}
+	footer := make([]byte, 4)
+	if _, err := f.ReadAt(footer, size-4); err != nil {
+		return err
+	}
+	if !bytes.Equal(header, []byte(parquetMagic)) || !bytes.Equal(footer, []byte(parquetMagic)) {
+		return fmt.Errorf("bad parquet magic %x...%x", header, footer)
+	}
+
+	return nil
+}
+
+// parquetSink - a trades file and a quotes file, nil for datasets not requested
+type parquetSink struct {
+	trades *parquetFile
+	quotes *parquetFile
+}
+
+// createSink - start the files for the requested datasets, named by name("trades"/"quotes")
+func createSink(cfg *Config, dir string, name func(dataset string) string) (*parquetSink, error) {
+
+	s := &parquetSink{}
+	rowGroup := int64(cfg.RowGroupMB) << 20
+
+	if cfg.Trades {
+		f, err := createParquet(filepath.Join(dir, name("trades")), new(parquetTrade), rowGroup)
+		if err != nil {
+			return nil, err
+		}
+		s.trades = f
+	}
+
+	if cfg.Quotes {
+		f, err := createParquet(filepath.Join(dir, name("quotes")), new(parquetQuote), rowGroup)
+		if err != nil {
+			s.abort()
+			return nil, err
+		}
+		s.quotes = f
+	}
+
+	return s, nil
+}
+
+// write - add a symbol's event to the trades or the quotes file
+func (s *parquetSink) write(symbol string, e tqfile.Event) error {
+	switch {
+	case e.Trade != nil && s.trades != nil:
+		return s.trades.pw.Write(tradeRow(symbol, e.Trade))
+	case e.Quote != nil && s.quotes != nil:
+		return s.quotes.pw.Write(quoteRow(symbol, e.Quote))
+	}
+	return fmt.Errorf("unexpected %q record", e.Kind())
+}
+
+// writer - write for one symbol
+func (s *parquetSink) writer(symbol string) func(tqfile.Event) error {
+	return func(e tqfile.Event) error {
+		return s.write(symbol, e)
+	}
+}
+
+// commit - finish both files, on error neither is left behind
+func (s *parquetSink) commit() ([]writtenFile, error) {
+
+	var files []writtenFile
+	for _, p := range []*parquetFile{s.trades, s.quotes} {
+		if p == nil {
+			continue
+		}
+		wf, err := p.commit()
+		if err != nil {
+			s.abort()
+			for _, f := range files {
+				os.Remove(filepath.Join(filepath.Dir(p.f.path), f.Name))
+			}
+			return nil, err
+		}
+		files = append(files, *wf)
+	}
+
+	return files, nil
+}
+
+// abort - give up on both files
+func (s *parquetSink) abort() {
+	for _, p := range []*parquetFile{s.trades, s.quotes} {
+		if p != nil {
+			p.f.abort()
+		}
+	}
+}
+
+// symbolParquetName - <SYM>-<date>.trades.parquet / <SYM>-<date>.quotes.parquet
+func symbolParquetName(symbol, date, dataset string) string {
+	return symbol + "-" + date + "." + dataset + ".parquet"
+}
+
+// dayParquetName - trades-<date>.parquet for the first part of the day,
+// trades-<date>.<part>.parquet for the ones written by later runs
+func dayParquetName(date, dataset string, part int) string {
+	if part == 0 {
+		return dataset + "-" + date + ".parquet"
+	}
+	return dataset + "-" + date + "." + strconv.Itoa(part) + ".parquet"
+}
+
+// writeSymbolParquet - write a symbol's sorted records to its own trades and quotes files
+func writeSymbolParquet(cfg *Config, dir, symbol, date string, runs *runSpiller) ([]writtenFile, error) {
+
+	s, err := createSink(cfg, dir, func(dataset string) string {
+		return symbolParquetName(symbol, date, dataset)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := runs.each(s.writer(symbol)); err != nil {
+		s.abort()
+		return nil, err
+	}
+
+	return s.commit()
+}
+
+// parquetDay - the day's trades and quotes files, shared by every symbol
+// with -parquet-layout day
+//
+// Symbols are written one at a time as they finish downloading. The files
+// only land once the whole day is done, so a rerun never sees half of one;
+// a rerun that has symbols left to fetch writes them to the next part.
+type parquetDay struct {
+	cfg  *Config
+	dir  string
+	date string
+
+	mu    sync.Mutex
+	sink  *parquetSink // created with the first symbol
+	err   error        // the first write error, the files are no good after one
+	added []daySymbol  // symbols in the files, in the order they were added
+}
+
+// daySymbol - a symbol written to the day's files
+type daySymbol struct {
+	symbol string
+	res    *symbolResult
+}
+
+// newParquetDay - day files in dir, nothing is created until a symbol is added
+func newParquetDay(cfg *Config, dir, date string) *parquetDay {
+	return &parquetDay{cfg: cfg, dir: dir, date: date}
+}
+
+// add - append a symbol's sorted records to the day's files
+func (d *parquetDay) add(symbol string, res *symbolResult, runs *runSpiller) error {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.err != nil {
+		return d.err
+	}
+
+	if d.sink == nil {
+		part, err := d.nextPart()
+		if err != nil {
+			return err
+		}
+		d.sink, err = createSink(d.cfg, d.dir, func(dataset string) string {
+			return dayParquetName(d.date, dataset, part)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := runs.each(d.sink.writer(symbol)); err != nil {
+		d.err = fmt.Errorf("day files: %w", err)
+		return d.err
+	}
+	d.added = append(d.added, daySymbol{symbol: symbol, res: res})
+
+	return nil
+}
+
+// nextPart - the first part number with no trades or quotes file on disk
+func (d *parquetDay) nextPart() (int, error) {
+	for part := 0; ; part++ {
+		free := true
+		for _, dataset := range []string{"trades", "quotes"} {
+			_, err := os.Stat(filepath.Join(d.dir, dayParquetName(d.date, dataset, part)))
+			if err == nil {
+				free = false
+			} else if !os.IsNotExist(err) {
+				return 0, err
+			}
+		}
+		if free {
+			return part, nil
+		}
+	}
+}
+
+// commit - finish the day's files, nil if no symbol was added
+func (d *parquetDay) commit() ([]writtenFile, error) {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.sink == nil {
+		return nil, nil
+	}
+	if d.err != nil {
+		d.sink.abort()
+		return nil, d.err
+	}
+
+	return d.sink.commit()
+}
+
+// removeUnusedParts - delete day parts no symbol in the manifest points at
+// any more, i.e. ones whose symbols were all refetched into a newer part
+func removeUnusedParts(dir, date string, m *manifest) error {
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	used := m.files()
+	for _, e := range entries {
+		name := e.Name()
+		if used[name] || !strings.HasSuffix(name, ".parquet") {
+			continue
+		}
+		if !strings.HasPrefix(name, "trades-"+date) && !strings.HasPrefix(name, "quotes-"+date) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/jweissig/polygon/tqfile"
+)
+
+// minRunSize - smallest -run-size, smaller runs only make more files to merge
+const minRunSize = 10000
+
+// maxFanIn - most runs merged at once, more than this are first merged a
+// group at a time into longer runs
+const maxFanIn = 64
+
+// runSpiller - external sort for one symbol/day
+//
+// Records are buffered until there are runSize of them, then sorted and
+// spilled to a run file. Once everything has arrived the runs are k-way
+// merged into the output, at most fanIn at a time, so memory per worker stays
+// around runSize records plus a few KB per open run no matter how busy the
+// symbol is. Symbols that fit in a single run never touch the disk.
+type runSpiller struct {
+	dir     string // parent dir for the temp run dir
+	prefix  string // temp run dir name prefix
+	runSize int    // records per run
+	fanIn   int    // runs merged at once
+	tmpDir  string // created on the first spill
+	buf     []tqfile.Event
+	runs    []string
+}
+
+// newRunSpiller - spiller writing its runs under dir
+func newRunSpiller(dir, prefix string, runSize int) *runSpiller {
+	return &runSpiller{dir: dir, prefix: prefix, runSize: runSize, fanIn: maxFanIn}
+}
+
+// add - buffer a record, spilling a run when the buffer is full
+func (s *runSpiller) add(v tqfile.Event) error {
+	s.buf = append(s.buf, v)
+	if len(s.buf) >= s.runSize {
+		return s.spill()
+	}
+	return nil
+}
+
+// spill - sort the buffer and write it out as a run
+func (s *runSpiller) spill() error {
+
+	if len(s.buf) == 0 {
+		return nil
+	}
+
+	if s.tmpDir == "" {
+		dir, err := ioutil.TempDir(s.dir, "."+s.prefix+"-*"+tmpSuffix)
+		if err != nil {
+			return err
+		}
+		s.tmpDir = dir
+	}
+
+	sortRecords(s.buf)
+
+	path := filepath.Join(s.tmpDir, fmt.Sprintf("run-%05d.gob", len(s.runs)))
+	if err := writeRun(path, s.buf); err != nil {
+		return fmt.Errorf("writing run: %w", err)
+	}
+
+	s.runs = append(s.runs, path)
+	s.buf = s.buf[:0]
+
+	return nil
+}
+
+// each - hand fn every record in time order
+func (s *runSpiller) each(fn func(tqfile.Event) error) error {
+
+	// everything fit in memory
+	if len(s.runs) == 0 {
+		sortRecords(s.buf)
+		for _, r := range s.buf {
+			if err := fn(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// the tail end becomes the last run
+	if err := s.spill(); err != nil {
+		return err
+	}
+
+	// too many to open at once: merge them a group at a time into longer
+	// runs, keeping their order so ties still come out in arrival order
+	for pass := 1; len(s.runs) > s.fanIn; pass++ {
+		var merged []string
+		for i := 0; i < len(s.runs); i += s.fanIn {
+			group := s.runs[i:]
+			if len(group) > s.fanIn {
+				group = group[:s.fanIn]
+			}
+			if len(group) == 1 {
+				merged = append(merged, group[0])
+				continue
+			}
+			path := filepath.Join(s.tmpDir, fmt.Sprintf("pass-%v-run-%05d.gob", pass, len(merged)))
+			if err := mergeInto(path, group); err != nil {
+				return err
+			}
+			merged = append(merged, path)
+		}
+		s.runs = merged
+	}
+
+	return mergeRuns(s.runs, fn)
+}
+
+// mergeInto - merge runs into a new run at path, removing them
+func mergeInto(path string, runs []string) error {
+
+	w, err := createRun(path)
+	if err != nil {
+		return fmt.Errorf("writing run: %w", err)
+	}
+	defer w.f.Close()
+
+	if err := mergeRuns(runs, w.write); err != nil {
+		return err
+	}
+	if err := w.close(); err != nil {
+		return fmt.Errorf("writing run: %w", err)
+	}
+
+	for _, run := range runs {
+		os.Remove(run)
+	}
+	return nil
+}
+
+// mergeRuns - k-way merge of run files, handing fn every record in time order
+func mergeRuns(runs []string, fn func(tqfile.Event) error) error {
+
+	m := &runMerger{}
+	defer m.close()
+
+	for i, path := range runs {
+		r, err := openRun(path, i)
+		if err != nil {
+			return err
+		}
+		m.readers = append(m.readers, r)
+		ok, err := r.advance()
+		if err != nil {
+			return err
+		}
+		if ok {
+			m.heap = append(m.heap, r)
+		}
+	}
+	heap.Init(m)
+
+	for m.Len() > 0 {
+
+		r := m.heap[0]
+		if err := fn(r.cur); err != nil {
+			return err
+		}
+
+		ok, err := r.advance()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(m, 0)
+		} else {
+			heap.Pop(m)
+		}
+	}
+
+	return nil
+}
+
+// cleanup - remove the run files
+func (s *runSpiller) cleanup() {
+	if s.tmpDir != "" {
+		os.RemoveAll(s.tmpDir)
+	}
+}
+
+// runWriter - writes a run as an uncompressed gob stream, one value per record
+//
+// Runs are only read back once, a record at a time, so they're written
+// without a codec or blocks: an open run costs a read buffer and the gob
+// decoder rather than a decoded block of a tqfile.
+type runWriter struct {
+	f   *os.File
+	bw  *bufio.Writer
+	enc *gob.Encoder
+}
+
+// createRun - start a new run file
+func createRun(path string) (*runWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	bw := bufio.NewWriter(f)
+	return &runWriter{f: f, bw: bw, enc: gob.NewEncoder(bw)}, nil
+}
+
+// write - add the next record
+func (w *runWriter) write(e tqfile.Event) error {
+	return w.enc.Encode(&e)
+}
+
+// close - flush and close the file
+func (w *runWriter) close() error {
+	if err := w.bw.Flush(); err != nil {
+		return err
+	}
+	return w.f.Close()
+}
+
+// writeRun - records written out as a run
+func writeRun(path string, records []tqfile.Event) error {
+
+	w, err := createRun(path)
+	if err != nil {
+		return err
+	}
+	defer w.f.Close()
+
+	for _, e := range records {
+		if err := w.write(e); err != nil {
+			return err
+		}
+	}
+
+	return w.close()
+}
+
+// runReader - reads one run back a record at a time
+type runReader struct {
+	idx int // run number, breaks ties so earlier runs (trades) come first
+	f   *os.File
+	dec *gob.Decoder
+	cur tqfile.Event
+}
+
+// openRun - open a run file for merging
+func openRun(path string, idx int) (*runReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &runReader{idx: idx, f: f, dec: gob.NewDecoder(bufio.NewReaderSize(f, 4096))}, nil
+}
+
+// advance - move to the next record, false at the end of the run
+func (r *runReader) advance() (bool, error) {
+	r.cur = tqfile.Event{}
+	if err := r.dec.Decode(&r.cur); err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, fmt.Errorf("reading run: %w", err)
+	}
+	return true, nil
+}
+
+// runMerger - min heap of runs ordered by their current record
+type runMerger struct {
+	readers []*runReader // all of them, for closing
+	heap    []*runReader // the ones with records left
+}
+
+func (m *runMerger) Len() int { return len(m.heap) }
+func (m *runMerger) Less(i, j int) bool {
+	a, b := m.heap[i], m.heap[j]
+	if ta, tb := a.cur.T(), b.cur.T(); ta != tb {
+		return ta < tb
+	}
+	return a.idx < b.idx
+}
+func (m *runMerger) Swap(i, j int)      { m.heap[i], m.heap[j] = m.heap[j], m.heap[i] }
+func (m *runMerger) Push(x interface{}) { m.heap = append(m.heap, x.(*runReader)) }
+func (m *runMerger) Pop() interface{} {
+	old := m.heap
+	x := old[len(old)-1]
+	m.heap = old[:len(old)-1]
+	return x
+}
+
+// close - close every run file
+func (m *runMerger) close() {
+	for _, r := range m.readers {
+		r.f.Close()
+	}
+}
+
+// sortRecords - sort by SIP timestamp, keeping arrival order for ties
+func sortRecords(records []tqfile.Event) {
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].T() < records[j].T()
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/jweissig/polygon/tqfile"
+)
+
+func TestRunSpillerMerge(t *testing.T) {
+
+	for _, c := range []struct {
+		name    string
+		records int
+		runSize int
+		fanIn   int
+		runs    int // run files left for the final merge
+	}{
+		{"in memory", 50, 100, 4, 0},
+		{"one pass", 50, 20, 4, 3},
+		{"fan-in exactly", 80, 20, 4, 4},
+		{"two passes", 200, 10, 4, 2},
+		{"three passes", 200, 3, 4, 2},
+	} {
+		s := newRunSpiller(t.TempDir(), "AAA", c.runSize)
+		s.fanIn = c.fanIn
+
+		// timestamps jump around and repeat, the sequence number is the arrival order
+		for i := 0; i < c.records; i++ {
+			ts := int64((i * 37) % 23)
+			if err := s.add(tqfile.Event{Trade: &tqfile.TradeRecord{SipTimestamp: ts, SequenceNumber: i}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var got []tqfile.Event
+		err := s.each(func(e tqfile.Event) error {
+			got = append(got, e)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%v: %v", c.name, err)
+		}
+
+		if len(s.runs) != c.runs {
+			t.Errorf("%v: merged %v runs at the end, want %v", c.name, len(s.runs), c.runs)
+		}
+		if s.tmpDir != "" {
+			if files, _ := ioutil.ReadDir(s.tmpDir); len(files) != len(s.runs) {
+				t.Errorf("%v: %v files left for %v runs", c.name, len(files), len(s.runs))
+			}
+		}
+		s.cleanup()
+
+		if len(got) != c.records {
+			t.Fatalf("%v: got %v records, want %v", c.name, len(got), c.records)
+		}
+		for i := 1; i < len(got); i++ {
+			a, b := got[i-1].Trade, got[i].Trade
+			if a.SipTimestamp > b.SipTimestamp || a.SipTimestamp == b.SipTimestamp && a.SequenceNumber > b.SequenceNumber {
+				t.Fatalf("%v: record %v (%v, #%v) after (%v, #%v)", c.name, i, b.SipTimestamp, b.SequenceNumber, a.SipTimestamp, a.SequenceNumber)
+			}
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jweissig/polygon/tqfile"
+)
+
+// tmpSuffix - in-progress files end with this until they're renamed into place
+const tmpSuffix = ".tmp"
+
+// writtenFile - size and checksum of a file after it's safely on disk
+type writtenFile struct {
+	Name   string `json:"name"`   // file name inside the day dir
+	Bytes  int64  `json:"bytes"`  // size on disk
+	SHA256 string `json:"sha256"` // checksum of the file on disk
+}
+
+// atomicFile - a file written to a hidden temp file next to path and only
+// renamed to path once it's complete, checked and on disk
+type atomicFile struct {
+	path string
+	tmp  *os.File
+}
+
+// createAtomic - start writing path
+func createAtomic(path string) (*atomicFile, error) {
+
+	dir, name := filepath.Split(path)
+
+	tmp, err := ioutil.TempFile(dir, "."+name+".*"+tmpSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	return &atomicFile{path: path, tmp: tmp}, nil
+}
+
+func (f *atomicFile) Write(p []byte) (int, error) {
+	return f.tmp.Write(p)
+}
+
+// Seek - for writers that go back and fill in a header
+func (f *atomicFile) Seek(offset int64, whence int) (int64, error) {
+	return f.tmp.Seek(offset, whence)
+}
+
+// commit - fsync the temp file, check it with verify and rename it over path
+func (f *atomicFile) commit(verify func(path string) error) (*writtenFile, error) {
+
+	dir, name := filepath.Split(f.path)
+
+	// TempFile creates 0600, give it the same mode os.Create would have
+	if err := f.tmp.Chmod(0644); err != nil {
+		return nil, err
+	}
+	if err := f.tmp.Sync(); err != nil {
+		return nil, fmt.Errorf("syncing %v: %w", name, err)
+	}
+	if err := f.tmp.Close(); err != nil {
+		return nil, fmt.Errorf("closing %v: %w", name, err)
+	}
+
+	if err := verify(f.tmp.Name()); err != nil {
+		return nil, fmt.Errorf("verifying %v: %w", name, err)
+	}
+
+	// checksum + size of what's on disk, for the manifest
+	n, sum, err := tqfile.HashFile(f.tmp.Name())
+	if err != nil {
+		return nil, err
+	}
+	wf := &writtenFile{Name: name, Bytes: n, SHA256: sum}
+
+	if err := os.Rename(f.tmp.Name(), f.path); err != nil {
+		return nil, err
+	}
+
+	// make the rename itself durable
+	if err := syncDir(dir); err != nil {
+		return nil, err
+	}
+
+	return wf, nil
+}
+
+// abort - give up on the file, removing the temp file
+func (f *atomicFile) abort() {
+	f.tmp.Close()
+	os.Remove(f.tmp.Name())
+}
+
+// writeTQ - write a trades + quotes file at path, atomically
+//
+// each hands over the events in time order. The data goes to a temp file in
+// the same dir which is fsynced, checked for a valid header and lz4 footer and
+// only then renamed over path, so a crash or a full disk never leaves a
+// truncated file that looks complete.
+func writeTQ(path string, h tqfile.Header, codec tqfile.Codec, each func(fn func(tqfile.Event) error) error) (*writtenFile, error) {
+
+	name := filepath.Base(path)
+
+	f, err := createAtomic(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// anything that goes wrong from here on removes the temp file
+	ok := false
+	defer func() {
+		if !ok {
+			f.abort()
+		}
+	}()
+
+	tw, err := tqfile.NewWriter(f, h, tqfile.WithCodec(codec))
+	if err != nil {
+		return nil, fmt.Errorf("encoding %v: %w", name, err)
+	}
+	if err := each(tw.Write); err != nil {
+		return nil, fmt.Errorf("encoding %v: %w", name, err)
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("compressing %v: %w", name, err)
+	}
+
+	wf, err := f.commit(verifyTQ)
+	if err != nil {
+		return nil, err
+	}
+	ok = true
+
+	return wf, nil
+}
+
+// verifyTQ - re-read the file and check it against its header: payload
+// size, checksum and an lz4 end mark (+ content checksum) at the end
+func verifyTQ(path string) error {
+	return tqfile.Verify(path)
+}
+
+// syncDir - fsync a directory so renames in it survive a crash
+func syncDir(dir string) error {
+	if dir == "" {
+		dir = "."
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// removeStaleTemps - clean out temp files and run dirs left behind by a crashed run
+func removeStaleTemps(dir string) error {
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), ".") || !strings.HasSuffix(e.Name(), tmpSuffix) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,11 @@
+module github.com/jweissig/polygon
+
+go 1.16
+
+require (
+	github.com/frankban/quicktest v1.14.6 // indirect
+	github.com/klauspost/compress v1.15.9
+	github.com/pierrec/lz4 v2.6.1+incompatible
+	github.com/xitongsys/parquet-go v1.6.2
+	github.com/xitongsys/parquet-go-source v0.0.0-20200817004010-026bad9b25d0
+)
@@ -0,0 +1,226 @@
+// Package polygon is a small client for the polygon.io REST API.
+//
+// It covers the endpoints our tools need (tickers, trades and quotes) with
+// typed results, iterators that follow next_url pagination, retries with
+// jittered exponential backoff and an optional client side rate limit shared
+// by every goroutine using the client.
+//
+//	c := polygon.NewClient(apiKey, polygon.WithRateLimiter(polygon.NewRateLimiter(100, 100)))
+//	it := c.ListTrades(ctx, "AAPL", polygon.TickParams{Date: "2022-12-23"})
+//	for it.Next() {
+//		trade := it.Trade()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+package polygon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL - the production API
+const DefaultBaseURL = "https://api.polygon.io"
+
+// APIVersion - version of the REST endpoints the client talks to
+const APIVersion = "v3"
+
+// Client - polygon.io REST client, safe for concurrent use
+type Client struct {
+	apiKey     string
+	baseURL    string
+	authHeader bool // send the key as "Authorization: Bearer" instead of ?apiKey=
+	http       *http.Client
+	retry      RetryPolicy
+	limiter    *RateLimiter // nil means no client side limit
+	logf       func(format string, args ...interface{})
+}
+
+// Option - configures a Client
+type Option func(*Client)
+
+// WithBaseURL - talk to another host, e.g. a proxy or a test server
+func WithBaseURL(u string) Option {
+	return func(c *Client) { c.baseURL = strings.TrimRight(u, "/") }
+}
+
+// WithHTTPClient - use a custom http.Client (timeouts, transport, ...)
+func WithHTTPClient(h *http.Client) Option {
+	return func(c *Client) { c.http = h }
+}
+
+// WithAuthHeader - send the API key in an Authorization header rather than the query string
+func WithAuthHeader() Option {
+	return func(c *Client) { c.authHeader = true }
+}
+
+// WithRetry - how many times and how long to back off on retryable errors
+func WithRetry(p RetryPolicy) Option {
+	return func(c *Client) { c.retry = p }
+}
+
+// WithRateLimiter - throttle every request (retries included) through l
+func WithRateLimiter(l *RateLimiter) Option {
+	return func(c *Client) { c.limiter = l }
+}
+
+// WithLogger - where retry and pagination warnings go, silent by default
+func WithLogger(logf func(format string, args ...interface{})) Option {
+	return func(c *Client) { c.logf = logf }
+}
+
+// NewClient - client for apiKey with sane defaults
+func NewClient(apiKey string, opts ...Option) *Client {
+
+	c := &Client{
+		apiKey:  apiKey,
+		baseURL: DefaultBaseURL,
+		http:    &http.Client{Timeout: 2 * time.Minute},
+		retry:   DefaultRetryPolicy,
+		logf:    func(string, ...interface{}) {},
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// RateLimiter - the limiter this client throttles through, nil if none
+func (c *Client) RateLimiter() *RateLimiter {
+	return c.limiter
+}
+
+// url - full url for an API path + query, with auth if it goes in the query string
+func (c *Client) url(path string, q url.Values) string {
+	if q == nil {
+		q = url.Values{}
+	}
+	if !c.authHeader {
+		q.Set("apiKey", c.apiKey)
+	}
+	return c.baseURL + path + "?" + q.Encode()
+}
+
+// nextURL - polygon's next_url with our base url and auth
+//
+// We only keep the path + query since next_url comes back with a weird
+// duplicated 443 port, and it never includes the api key.
+func (c *Client) nextURL(next string) (string, error) {
+	u, err := url.Parse(next)
+	if err != nil {
+		return "", fmt.Errorf("bad next_url %q: %w", next, err)
+	}
+	return c.url(u.Path, u.Query()), nil
+}
+
+// getJSON - GET rawURL and decode the JSON body into v
+//
+// Transport errors, timeouts, truncated/garbled bodies, 429 and 5xx responses
+// are retried with jittered exponential backoff (honoring Retry-After); any
+// other non-200 response fails straight away with an *HTTPError.
+func (c *Client) getJSON(ctx context.Context, rawURL string, v interface{}) error {
+
+	var err error
+
+	for attempt := 0; ; attempt++ {
+
+		var retry bool
+		var wait time.Duration
+
+		retry, wait, err = c.try(ctx, rawURL, v)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= c.retry.MaxRetries || ctx.Err() != nil {
+			break
+		}
+
+		backoff := c.retry.backoff(attempt)
+		if wait > backoff {
+			backoff = wait
+		}
+		if backoff > c.retry.MaxDelay {
+			backoff = c.retry.MaxDelay
+		}
+
+		c.logf("retrying in %v (attempt %v/%v): %v", backoff.Round(time.Millisecond), attempt+1, c.retry.MaxRetries, err)
+
+		if err := sleep(ctx, backoff); err != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+// try - one attempt, returns whether a failure is retryable and any server requested wait
+func (c *Client) try(ctx context.Context, rawURL string, v interface{}) (bool, time.Duration, error) {
+
+	// every attempt, retries included, counts against the rate limit
+	if err := c.limiter.Wait(ctx); err != nil {
+		return false, 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return false, 0, err
+	}
+	if c.authHeader {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return false, 0, ctx.Err()
+		}
+		// transport errors (resets, timeouts, dns) are all worth another go
+		return true, 0, fmt.Errorf("GET %v: %w", redact(rawURL), stripURLError(err))
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return true, 0, fmt.Errorf("reading %v: %w", redact(rawURL), err)
+	}
+
+	// throw error on non-200 response
+	if resp.StatusCode != http.StatusOK {
+		herr := &HTTPError{
+			StatusCode: resp.StatusCode,
+			URL:        redact(rawURL),
+			Body:       string(body),
+			RetryAfter: retryAfter(resp.Header.Get("Retry-After")),
+		}
+		return herr.Retryable(), herr.RetryAfter, herr
+	}
+
+	// a 200 with a cut off body shows up here
+	if err := json.Unmarshal(body, v); err != nil {
+		return true, 0, fmt.Errorf("decoding %v: %w", redact(rawURL), err)
+	}
+
+	return false, 0, nil
+}
+
+// sleep - time.Sleep that gives up when ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,306 @@
+package polygon_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jweissig/polygon/polygon"
+	"github.com/jweissig/polygon/polygon/polygontest"
+)
+
+const testDate = "2022-12-23"
+
+// fast retries so fault tests don't sleep for real
+var testRetry = polygon.RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+// logBuffer - collects client log lines
+type logBuffer struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *logBuffer) logf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func (l *logBuffer) contains(s string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range l.lines {
+		if strings.Contains(line, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func newTestClient(srv *polygontest.Server, opts ...polygon.Option) *polygon.Client {
+	opts = append([]polygon.Option{polygon.WithBaseURL(srv.URL), polygon.WithRetry(testRetry)}, opts...)
+	return polygon.NewClient(polygontest.APIKey, opts...)
+}
+
+func listTrades(c *polygon.Client, ticker string, limit int) ([]polygon.Trade, int, error) {
+	it := c.ListTrades(context.Background(), ticker, polygon.TickParams{Date: testDate, Limit: limit})
+	var trades []polygon.Trade
+	for it.Next() {
+		trades = append(trades, it.Trade())
+	}
+	return trades, it.Pages(), it.Err()
+}
+
+func TestListTradesPaginates(t *testing.T) {
+
+	srv := polygontest.NewServer()
+	defer srv.Close()
+
+	// a second day for the same ticker must not leak in
+	want := polygontest.GenerateTrades(testDate, 2500, 1)
+	srv.SetTrades("AAPL", append(want, polygontest.GenerateTrades("2022-12-27", 10, 2)...))
+
+	trades, pages, err := listTrades(newTestClient(srv), "AAPL", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages != 3 {
+		t.Errorf("fetched %v pages, want 3", pages)
+	}
+	if len(trades) != len(want) {
+		t.Fatalf("got %v trades, want %v", len(trades), len(want))
+	}
+	for i := range want {
+		if trades[i].ID != want[i].ID || trades[i].SipTimestamp != want[i].SipTimestamp {
+			t.Fatalf("trade %v = %+v, want %+v", i, trades[i], want[i])
+		}
+	}
+
+	// next_url pages went back to the test server with the key and the original filters
+	reqs := srv.Requests()
+	if len(reqs) != 3 {
+		t.Fatalf("made %v requests, want 3: %v", len(reqs), reqs)
+	}
+	for _, r := range reqs[1:] {
+		if !strings.Contains(r, "cursor=") || !strings.Contains(r, "apiKey="+polygontest.APIKey) || !strings.Contains(r, "timestamp="+testDate) {
+			t.Errorf("bad follow up request %v", r)
+		}
+	}
+}
+
+func TestListTickersAndQuotes(t *testing.T) {
+
+	srv := polygontest.NewServer()
+	defer srv.Close()
+
+	srv.AddTicker("A", "AA", "AAPL")
+	srv.SetQuotes("AAPL", polygontest.GenerateQuotes(testDate, 120, 1))
+
+	c := newTestClient(srv)
+
+	tickers := c.ListTickers(context.Background(), polygon.TickersParams{Market: "stocks", Active: true, Limit: 2})
+	var symbols []string
+	for tickers.Next() {
+		symbols = append(symbols, tickers.Ticker().Ticker)
+	}
+	if err := tickers.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(symbols, ",") != "A,AA,AAPL" || tickers.Pages() != 2 {
+		t.Errorf("got tickers %v over %v pages", symbols, tickers.Pages())
+	}
+
+	quotes := c.ListQuotes(context.Background(), "AAPL", polygon.TickParams{Date: testDate, Limit: 50})
+	n := 0
+	for quotes.Next() {
+		q := quotes.Quote()
+		if q.BidPrice >= q.AskPrice {
+			t.Errorf("crossed quote %+v", q)
+		}
+		n++
+	}
+	if err := quotes.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n != 120 || quotes.Pages() != 3 {
+		t.Errorf("got %v quotes over %v pages, want 120 over 3", n, quotes.Pages())
+	}
+}
+
+func TestRetriesTransientErrors(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		fault polygontest.Fault
+	}{
+		{"429", polygontest.Fault{Status: 429, RetryAfter: "1"}},
+		{"500", polygontest.Fault{Status: 500}},
+		{"502", polygontest.Fault{Status: 502}},
+		{"truncated", polygontest.Fault{Truncate: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			srv := polygontest.NewServer()
+			defer srv.Close()
+
+			srv.SetTrades("AAPL", polygontest.GenerateTrades(testDate, 300, 1))
+			tt.fault.Path = "/v3/trades/AAPL"
+			tt.fault.Times = 2
+			srv.Fail(tt.fault)
+
+			log := &logBuffer{}
+			trades, _, err := listTrades(newTestClient(srv, polygon.WithLogger(log.logf)), "AAPL", 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(trades) != 300 {
+				t.Errorf("got %v trades, want 300", len(trades))
+			}
+			if n := len(srv.Requests()); n != 3+2 {
+				t.Errorf("made %v requests, want 5", n)
+			}
+			if !log.contains("retrying") {
+				t.Errorf("no retries logged: %v", log.lines)
+			}
+		})
+	}
+}
+
+func TestGivesUp(t *testing.T) {
+
+	srv := polygontest.NewServer()
+	defer srv.Close()
+
+	srv.SetTrades("AAPL", polygontest.GenerateTrades(testDate, 10, 1))
+	srv.Fail(polygontest.Fault{Path: "/v3/trades/AAPL", Status: 503})
+
+	_, _, err := listTrades(newTestClient(srv), "AAPL", 0)
+
+	var herr *polygon.HTTPError
+	if !errors.As(err, &herr) || herr.StatusCode != 503 {
+		t.Fatalf("got error %v, want a 503 HTTPError", err)
+	}
+	if n := len(srv.Requests()); n != testRetry.MaxRetries+1 {
+		t.Errorf("made %v requests, want %v", n, testRetry.MaxRetries+1)
+	}
+	if strings.Contains(err.Error(), polygontest.APIKey) {
+		t.Errorf("api key leaked into error: %v", err)
+	}
+}
+
+func TestDoesNotRetryClientErrors(t *testing.T) {
+
+	srv := polygontest.NewServer()
+	defer srv.Close()
+
+	srv.Fail(polygontest.Fault{Path: "/v3/trades/GONE", Status: 404})
+
+	_, _, err := listTrades(newTestClient(srv), "GONE", 0)
+	var herr *polygon.HTTPError
+	if !errors.As(err, &herr) || herr.StatusCode != 404 {
+		t.Fatalf("got error %v, want a 404 HTTPError", err)
+	}
+
+	// and a bad key is a 401, also not worth retrying
+	bad := polygon.NewClient("wrong", polygon.WithBaseURL(srv.URL), polygon.WithRetry(testRetry))
+	it := bad.ListTickers(context.Background(), polygon.TickersParams{})
+	if it.Next() || !errors.As(it.Err(), &herr) || herr.StatusCode != 401 {
+		t.Fatalf("got error %v, want a 401 HTTPError", it.Err())
+	}
+
+	if n := len(srv.Requests()); n != 2 {
+		t.Errorf("made %v requests, want 2", n)
+	}
+}
+
+func TestAuthHeader(t *testing.T) {
+
+	srv := polygontest.NewServer()
+	defer srv.Close()
+
+	srv.SetTrades("AAPL", polygontest.GenerateTrades(testDate, 250, 1))
+
+	trades, _, err := listTrades(newTestClient(srv, polygon.WithAuthHeader()), "AAPL", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trades) != 250 {
+		t.Errorf("got %v trades, want 250", len(trades))
+	}
+	for _, r := range srv.Requests() {
+		if strings.Contains(r, "apiKey") {
+			t.Errorf("api key in query string with header auth: %v", r)
+		}
+	}
+}
+
+func TestFullPageWithoutNextURL(t *testing.T) {
+
+	srv := polygontest.NewServer()
+	defer srv.Close()
+
+	srv.SetTrades("AAPL", polygontest.GenerateTrades(testDate, 3000, 1))
+	srv.DropNextURL("/v3/trades/AAPL")
+
+	log := &logBuffer{}
+	trades, _, err := listTrades(newTestClient(srv, polygon.WithLogger(log.logf)), "AAPL", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// there's nothing to follow, but it must not go unnoticed
+	if len(trades) != 1000 {
+		t.Errorf("got %v trades, want the first page of 1000", len(trades))
+	}
+	if !log.contains("possible 50k bug") {
+		t.Errorf("50k bug not logged: %v", log.lines)
+	}
+}
+
+func TestServerCapsPageSize(t *testing.T) {
+
+	srv := polygontest.NewServer()
+	defer srv.Close()
+
+	// asking for 50000 but only getting 400 a page is normal, not the 50k bug
+	srv.SetPageLimit(400)
+	srv.SetTrades("AAPL", polygontest.GenerateTrades(testDate, 1000, 1))
+
+	log := &logBuffer{}
+	trades, pages, err := listTrades(newTestClient(srv, polygon.WithLogger(log.logf)), "AAPL", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trades) != 1000 || pages != 3 {
+		t.Errorf("got %v trades over %v pages, want 1000 over 3", len(trades), pages)
+	}
+	if log.contains("50k") {
+		t.Errorf("unexpected 50k bug warning: %v", log.lines)
+	}
+}
+
+func TestCanceledContext(t *testing.T) {
+
+	srv := polygontest.NewServer()
+	defer srv.Close()
+
+	srv.Fail(polygontest.Fault{Status: 429, RetryAfter: "60"})
+
+	c := newTestClient(srv, polygon.WithRetry(polygon.RetryPolicy{MaxRetries: 5, BaseDelay: time.Minute, MaxDelay: time.Minute}))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	it := c.ListTrades(ctx, "AAPL", polygon.TickParams{Date: testDate})
+	if it.Next() {
+		t.Fatal("got a trade from a server that only returns 429s")
+	}
+	if !errors.Is(it.Err(), context.DeadlineExceeded) {
+		t.Errorf("got error %v, want context.DeadlineExceeded", it.Err())
+	}
+}
@@ -0,0 +1,93 @@
+package polygon
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy - how hard we try before giving up on a request
+type RetryPolicy struct {
+	MaxRetries int           // retries after the first attempt
+	BaseDelay  time.Duration // first backoff, doubled each retry
+	MaxDelay   time.Duration // cap on a single backoff (and on Retry-After)
+}
+
+// DefaultRetryPolicy - 5 retries starting at 1s, never waiting more than a minute
+var DefaultRetryPolicy = RetryPolicy{MaxRetries: 5, BaseDelay: time.Second, MaxDelay: time.Minute}
+
+// backoff - exponential backoff for the given attempt, half fixed and half random jitter
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay << uint(attempt)
+	if d <= 0 || d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// HTTPError - a non-200 response from polygon
+type HTTPError struct {
+	StatusCode int
+	URL        string // with the api key redacted
+	Body       string
+	RetryAfter time.Duration // parsed Retry-After header, 0 if missing
+}
+
+func (e *HTTPError) Error() string {
+	body := e.Body
+	if len(body) > 200 {
+		body = body[:200] + "..."
+	}
+	return fmt.Sprintf("HTTP %v from %v: %v", e.StatusCode, e.URL, strings.TrimSpace(body))
+}
+
+// Retryable - is it worth trying this request again?
+//
+// 429 and 5xx are; 401/403/404 and friends won't get better by asking again.
+func (e *HTTPError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// retryAfter - parse a Retry-After header, either seconds or an HTTP date
+func retryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// redact - hide the api key in urls we print
+func redact(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	q := u.Query()
+	if q.Get("apiKey") != "" {
+		q.Set("apiKey", "REDACTED")
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+// stripURLError - drop the *url.Error wrapper since it repeats the (unredacted) url
+func stripURLError(err error) error {
+	var uerr *url.Error
+	if errors.As(err, &uerr) {
+		return uerr.Err
+	}
+	return err
+}
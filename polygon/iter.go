@@ -0,0 +1,219 @@
+package polygon
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// TickersParams - filters for ListTickers
+type TickersParams struct {
+	Market string // stocks, crypto, fx, otc, indices
+	Type   string // CS, ETF, ... see /v3/reference/tickers/types
+	Date   string // tickers as of this day (YYYY-MM-DD)
+	Active bool   // only tickers actively traded on Date
+	Limit  int    // page size, default 1000 (the max)
+}
+
+// TickParams - filters for ListTrades and ListQuotes
+type TickParams struct {
+	Date  string // a whole day (YYYY-MM-DD), sent as timestamp=
+	From  string // timestamp.gte, a date or nanosecond timestamp
+	To    string // timestamp.lt, a date or nanosecond timestamp
+	Order string // asc or desc, polygon's default when empty
+	Limit int    // page size, default 50000 (the max)
+}
+
+// pageReader - the bits of a decoded page the pager cares about
+type pageReader interface {
+	next() string
+	len() int
+}
+
+// pager - follows next_url until the results run out
+type pager struct {
+	ctx   context.Context
+	c     *Client
+	url   string // next url to fetch, empty when there are no more pages
+	limit int    // page size we asked for
+	pages int    // pages fetched so far
+	err   error
+}
+
+// fetch - pull down the next page into p, false when done or on error
+func (pg *pager) fetch(p pageReader) bool {
+
+	if pg.err != nil || pg.url == "" {
+		return false
+	}
+
+	current := pg.url
+	if err := pg.c.getJSON(pg.ctx, current, p); err != nil {
+		pg.err = err
+		return false
+	}
+	pg.pages++
+
+	// a full page without a next_url usually means polygon dropped the cursor
+	if p.len() == pg.limit && p.next() == "" {
+		pg.c.logf("possible 50k bug issue: full page of %v results without a next_url from %v", p.len(), redact(current))
+	}
+
+	pg.url = ""
+	if p.next() != "" {
+		next, err := pg.c.nextURL(p.next())
+		if err != nil {
+			pg.err = err
+			return false
+		}
+		pg.url = next
+	}
+
+	return true
+}
+
+// Err - the error that stopped iteration, nil if it ran to the end
+func (pg *pager) Err() error {
+	return pg.err
+}
+
+// Pages - number of pages fetched so far
+func (pg *pager) Pages() int {
+	return pg.pages
+}
+
+// TickersIter - iterates over every ticker matching ListTickers
+type TickersIter struct {
+	pager
+	buf []Ticker
+	cur Ticker
+}
+
+// Next - advance to the next ticker, fetching pages as needed
+func (it *TickersIter) Next() bool {
+	for len(it.buf) == 0 {
+		var p tickersPage
+		if !it.fetch(&p) {
+			return false
+		}
+		it.buf = p.Results
+	}
+	it.cur, it.buf = it.buf[0], it.buf[1:]
+	return true
+}
+
+// Ticker - the current ticker
+func (it *TickersIter) Ticker() Ticker {
+	return it.cur
+}
+
+// TradesIter - iterates over every trade matching ListTrades
+type TradesIter struct {
+	pager
+	buf []Trade
+	cur Trade
+}
+
+// Next - advance to the next trade, fetching pages as needed
+func (it *TradesIter) Next() bool {
+	for len(it.buf) == 0 {
+		var p tradesPage
+		if !it.fetch(&p) {
+			return false
+		}
+		it.buf = p.Results
+	}
+	it.cur, it.buf = it.buf[0], it.buf[1:]
+	return true
+}
+
+// Trade - the current trade
+func (it *TradesIter) Trade() Trade {
+	return it.cur
+}
+
+// QuotesIter - iterates over every quote matching ListQuotes
+type QuotesIter struct {
+	pager
+	buf []Quote
+	cur Quote
+}
+
+// Next - advance to the next quote, fetching pages as needed
+func (it *QuotesIter) Next() bool {
+	for len(it.buf) == 0 {
+		var p quotesPage
+		if !it.fetch(&p) {
+			return false
+		}
+		it.buf = p.Results
+	}
+	it.cur, it.buf = it.buf[0], it.buf[1:]
+	return true
+}
+
+// Quote - the current quote
+func (it *QuotesIter) Quote() Quote {
+	return it.cur
+}
+
+// ListTickers - https://polygon.io/docs/stocks/get_v3_reference_tickers
+func (c *Client) ListTickers(ctx context.Context, p TickersParams) *TickersIter {
+
+	if p.Limit == 0 {
+		p.Limit = 1000
+	}
+
+	q := url.Values{}
+	setIf(q, "market", p.Market)
+	setIf(q, "type", p.Type)
+	setIf(q, "date", p.Date)
+	if p.Active {
+		q.Set("active", "true")
+	}
+	q.Set("sort", "ticker")
+	q.Set("order", "asc")
+	q.Set("limit", strconv.Itoa(p.Limit))
+
+	return &TickersIter{pager: pager{ctx: ctx, c: c, url: c.url("/v3/reference/tickers", q), limit: p.Limit}}
+}
+
+// ListTrades - https://polygon.io/docs/stocks/get_v3_trades__stockticker
+func (c *Client) ListTrades(ctx context.Context, ticker string, p TickParams) *TradesIter {
+	return &TradesIter{pager: c.tickPager(ctx, "/v3/trades/", ticker, p)}
+}
+
+// ListQuotes - https://polygon.io/docs/stocks/get_v3_quotes__stockticker
+func (c *Client) ListQuotes(ctx context.Context, ticker string, p TickParams) *QuotesIter {
+	return &QuotesIter{pager: c.tickPager(ctx, "/v3/quotes/", ticker, p)}
+}
+
+// tickPager - shared setup for trades and quotes
+func (c *Client) tickPager(ctx context.Context, path, ticker string, p TickParams) pager {
+
+	if p.Limit == 0 {
+		p.Limit = 50000
+	}
+
+	q := url.Values{}
+	setIf(q, "timestamp", p.Date)
+	setIf(q, "timestamp.gte", p.From)
+	setIf(q, "timestamp.lt", p.To)
+	setIf(q, "order", p.Order)
+	q.Set("limit", strconv.Itoa(p.Limit))
+
+	pg := pager{ctx: ctx, c: c, url: c.url(path+url.PathEscape(ticker), q), limit: p.Limit}
+	if ticker == "" {
+		pg.url, pg.err = "", fmt.Errorf("polygon: %v{ticker} needs a ticker", path)
+	}
+
+	return pg
+}
+
+// setIf - set a query param only when it has a value
+func setIf(q url.Values, key, value string) {
+	if value != "" {
+		q.Set(key, value)
+	}
+}
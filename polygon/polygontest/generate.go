@@ -0,0 +1,122 @@
+package polygontest
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+
+	"github.com/jweissig/polygon/calendar"
+	"github.com/jweissig/polygon/polygon"
+)
+
+// a few real condition codes so consumers see the usual mix
+var tradeConditions = [][]int{nil, nil, nil, nil, {37}, {12, 37}, {14, 41}, {15}, {16}, {12}}
+
+// GenerateTrades - n trades spread over date's extended session (4am-8pm New
+// York), in SIP time order with some duplicate timestamps, a random walk
+// price and odd lots. The same seed gives the same trades.
+func GenerateTrades(date string, n int, seed int64) []polygon.Trade {
+
+	r := rand.New(rand.NewSource(seed))
+	ts := timestamps(r, date, n)
+
+	trades := make([]polygon.Trade, n)
+	price := 100.0
+	for i := range trades {
+		price = walk(r, price)
+		trades[i] = polygon.Trade{
+			Conditions:           tradeConditions[r.Intn(len(tradeConditions))],
+			Exchange:             1 + r.Intn(20),
+			ID:                   strconv.Itoa(i + 1),
+			ParticipantTimestamp: ts[i] - int64(r.Intn(1000000)),
+			Price:                price,
+			SequenceNumber:       i + 1,
+			SipTimestamp:         ts[i],
+			Size:                 int64(1 + r.Intn(300)),
+			Tape:                 3,
+		}
+		if trades[i].Exchange == 4 {
+			trades[i].TrfID = 201
+			trades[i].TrfTimestamp = ts[i] - int64(r.Intn(1000))
+		}
+	}
+
+	return trades
+}
+
+// GenerateQuotes - n NBBO quotes spread over date's extended session, in SIP
+// time order, with a random walk midpoint and a spread of a few cents
+func GenerateQuotes(date string, n int, seed int64) []polygon.Quote {
+
+	r := rand.New(rand.NewSource(seed))
+	ts := timestamps(r, date, n)
+
+	quotes := make([]polygon.Quote, n)
+	mid := 100.0
+	for i := range quotes {
+		mid = walk(r, mid)
+		half := float64(1+r.Intn(3)) / 100
+		quotes[i] = polygon.Quote{
+			AskExchange:          1 + r.Intn(20),
+			AskPrice:             round(mid + half),
+			AskSize:              1 + r.Intn(10),
+			BidExchange:          1 + r.Intn(20),
+			BidPrice:             round(mid - half),
+			BidSize:              1 + r.Intn(10),
+			Conditions:           []int{1},
+			ParticipantTimestamp: ts[i] - int64(r.Intn(1000000)),
+			SequenceNumber:       i + 1,
+			SipTimestamp:         ts[i],
+			Tape:                 3,
+		}
+	}
+
+	return quotes
+}
+
+// timestamps - n sorted ns timestamps between 4am and 8pm New York on date,
+// roughly one in twenty repeating the previous one
+func timestamps(r *rand.Rand, date string, n int) []int64 {
+
+	day, err := calendar.Parse(date)
+	if err != nil {
+		panic("polygontest: bad date " + date)
+	}
+	start := day.Add(calendar.PreMarketOpen).UnixNano()
+	end := day.Add(calendar.AfterHoursClose).UnixNano()
+
+	ts := make([]int64, n)
+	for i := range ts {
+		if i > 0 && r.Intn(20) == 0 {
+			ts[i] = -1 // copy of whatever ends up before it
+			continue
+		}
+		ts[i] = start + r.Int63n(end-start)
+	}
+	sort.Slice(ts, func(i, j int) bool { return ts[i] < ts[j] })
+
+	// the -1s sorted to the front, spread them back out as duplicates
+	dups := sort.Search(n, func(i int) bool { return ts[i] >= 0 })
+	ts = append(ts[dups:], ts[:dups]...)
+	for i := n - dups; i < n; i++ {
+		ts[i] = ts[r.Intn(n-dups)]
+	}
+	sort.Slice(ts, func(i, j int) bool { return ts[i] < ts[j] })
+
+	return ts
+}
+
+// walk - next price in a random walk, a cent or two at a time
+func walk(r *rand.Rand, p float64) float64 {
+	p += float64(r.Intn(5)-2) / 100
+	if p < 1 {
+		p = 1
+	}
+	return round(p)
+}
+
+// round - to the cent
+func round(p float64) float64 {
+	return math.Round(p*100) / 100
+}
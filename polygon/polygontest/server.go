@@ -0,0 +1,325 @@
+// Package polygontest is a fake polygon.io API for tests.
+//
+// Server implements /v3/reference/tickers, /v3/trades/{ticker} and
+// /v3/quotes/{ticker} on top of httptest with the same pagination polygon
+// does (next_url with the duplicated :443 port quirk and no api key), plus
+// knobs to inject 429s, 5xx errors, truncated bodies and the "full page but
+// no next_url" bug.
+//
+//	srv := polygontest.NewServer()
+//	defer srv.Close()
+//	srv.AddTicker("AAPL")
+//	srv.SetTrades("AAPL", polygontest.GenerateTrades("2022-12-23", 1000, 1))
+//	c := polygon.NewClient(polygontest.APIKey, polygon.WithBaseURL(srv.URL))
+package polygontest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jweissig/polygon/calendar"
+	"github.com/jweissig/polygon/polygon"
+)
+
+// APIKey - the only key the server accepts
+const APIKey = "test-api-key"
+
+// Fault - an error to inject into matching requests
+type Fault struct {
+	Path       string // request path prefix to match, "" matches everything
+	Status     int    // status code to return, e.g. 429 or 502
+	RetryAfter string // Retry-After header value, if any
+	Truncate   bool   // send a 200 whose body is cut off half way instead
+	Times      int    // how many requests to fail, 0 means forever
+}
+
+// Server - fake polygon.io API
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	tickers   []polygon.Ticker
+	trades    map[string][]polygon.Trade
+	quotes    map[string][]polygon.Quote
+	faults    []*Fault
+	noNext    map[string]bool // paths that hit the 50k bug
+	requests  []string
+	pageLimit int // cap on page size, like polygon's 50000
+}
+
+// NewServer - start a fake API, Close it when done
+func NewServer() *Server {
+	s := &Server{
+		trades:    map[string][]polygon.Trade{},
+		quotes:    map[string][]polygon.Quote{},
+		noNext:    map[string]bool{},
+		pageLimit: 50000,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// AddTicker - add active common stocks to /v3/reference/tickers
+func (s *Server) AddTicker(symbols ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sym := range symbols {
+		s.tickers = append(s.tickers, polygon.Ticker{
+			Ticker: sym, Name: sym + " Inc.", Market: "stocks", Locale: "us",
+			PrimaryExchange: "XNAS", Type: "CS", Active: true, CurrencyName: "usd",
+		})
+	}
+}
+
+// SetTrades - trades served for symbol, must be in time order
+func (s *Server) SetTrades(symbol string, trades []polygon.Trade) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trades[symbol] = trades
+}
+
+// SetQuotes - quotes served for symbol, must be in time order
+func (s *Server) SetQuotes(symbol string, quotes []polygon.Quote) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.quotes[symbol] = quotes
+}
+
+// SetPageLimit - largest page the server will return whatever limit is asked for
+func (s *Server) SetPageLimit(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pageLimit = n
+}
+
+// Fail - inject a fault, faults are checked in the order they were added
+func (s *Server) Fail(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &f)
+}
+
+// DropNextURL - reproduce the 50k bug on path: the first full page comes back
+// without a next_url, silently dropping the rest of the results
+func (s *Server) DropNextURL(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.noNext[path] = true
+}
+
+// Requests - paths (with query) of every request so far
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// handle - route a request
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+
+	s.mu.Lock()
+	s.requests = append(s.requests, r.URL.RequestURI())
+	fault := s.fault(r.URL.Path)
+	s.mu.Unlock()
+
+	if r.URL.Query().Get("apiKey") != APIKey && r.Header.Get("Authorization") != "Bearer "+APIKey {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"status": "ERROR", "error": "Unknown API Key"})
+		return
+	}
+
+	if fault != nil && !fault.Truncate {
+		if fault.RetryAfter != "" {
+			w.Header().Set("Retry-After", fault.RetryAfter)
+		}
+		writeJSON(w, fault.Status, map[string]string{"status": "ERROR", "error": http.StatusText(fault.Status)})
+		return
+	}
+
+	var body interface{}
+	switch {
+	case r.URL.Path == "/v3/reference/tickers":
+		body = s.listTickers(r)
+	case strings.HasPrefix(r.URL.Path, "/v3/trades/"):
+		body = s.listTrades(r, strings.TrimPrefix(r.URL.Path, "/v3/trades/"))
+	case strings.HasPrefix(r.URL.Path, "/v3/quotes/"):
+		body = s.listQuotes(r, strings.TrimPrefix(r.URL.Path, "/v3/quotes/"))
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"status": "NOT_FOUND", "message": "Route not found"})
+		return
+	}
+
+	if fault != nil && fault.Truncate {
+		data, _ := json.Marshal(body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(data[:len(data)/2])
+		return
+	}
+
+	writeJSON(w, http.StatusOK, body)
+}
+
+// fault - the first live fault matching path, s.mu must be held
+func (s *Server) fault(path string) *Fault {
+	for _, f := range s.faults {
+		if f.Times < 0 || !strings.HasPrefix(path, f.Path) {
+			continue
+		}
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				f.Times = -1 // used up
+			}
+		}
+		return f
+	}
+	return nil
+}
+
+// listTickers - /v3/reference/tickers, paginated by offset cursor
+func (s *Server) listTickers(r *http.Request) interface{} {
+
+	s.mu.Lock()
+	all := append([]polygon.Ticker(nil), s.tickers...)
+	s.mu.Unlock()
+
+	results, next := s.page(r, len(all))
+	out := map[string]interface{}{
+		"status":     "OK",
+		"request_id": requestID(),
+		"count":      next - results.start,
+		"results":    all[results.start:results.end],
+	}
+	if next < len(all) && !s.dropNext(r.URL.Path) {
+		out["next_url"] = s.nextURL(r, next)
+	}
+	return out
+}
+
+// listTrades - /v3/trades/{ticker} for the day in ?timestamp=
+func (s *Server) listTrades(r *http.Request, symbol string) interface{} {
+
+	s.mu.Lock()
+	var day []polygon.Trade
+	for _, t := range s.trades[symbol] {
+		if onDay(t.SipTimestamp, r.URL.Query().Get("timestamp")) {
+			day = append(day, t)
+		}
+	}
+	s.mu.Unlock()
+
+	results, next := s.page(r, len(day))
+	out := map[string]interface{}{
+		"status":     "OK",
+		"request_id": requestID(),
+		"results":    day[results.start:results.end],
+	}
+	if next < len(day) && !s.dropNext(r.URL.Path) {
+		out["next_url"] = s.nextURL(r, next)
+	}
+	return out
+}
+
+// listQuotes - /v3/quotes/{ticker} for the day in ?timestamp=
+func (s *Server) listQuotes(r *http.Request, symbol string) interface{} {
+
+	s.mu.Lock()
+	var day []polygon.Quote
+	for _, q := range s.quotes[symbol] {
+		if onDay(q.SipTimestamp, r.URL.Query().Get("timestamp")) {
+			day = append(day, q)
+		}
+	}
+	s.mu.Unlock()
+
+	results, next := s.page(r, len(day))
+	out := map[string]interface{}{
+		"status":     "OK",
+		"request_id": requestID(),
+		"results":    day[results.start:results.end],
+	}
+	if next < len(day) && !s.dropNext(r.URL.Path) {
+		out["next_url"] = s.nextURL(r, next)
+	}
+	return out
+}
+
+// span - a slice of results
+type span struct {
+	start, end int
+}
+
+// page - work out which slice of n results this request gets
+func (s *Server) page(r *http.Request, n int) (span, int) {
+
+	q := r.URL.Query()
+	start, _ := strconv.Atoi(q.Get("cursor"))
+	limit, _ := strconv.Atoi(q.Get("limit"))
+
+	s.mu.Lock()
+	if limit <= 0 || limit > s.pageLimit {
+		limit = s.pageLimit
+	}
+	s.mu.Unlock()
+
+	if start > n {
+		start = n
+	}
+	end := start + limit
+	if end > n {
+		end = n
+	}
+
+	return span{start, end}, end
+}
+
+// dropNext - should this path lose its next_url?
+func (s *Server) dropNext(path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.noNext[path]
+}
+
+// nextURL - polygon style next_url: absolute, :443 tacked on, no api key,
+// the original filters carried in the query along with the cursor
+func (s *Server) nextURL(r *http.Request, cursor int) string {
+	q := r.URL.Query()
+	q.Del("apiKey")
+	q.Set("cursor", strconv.Itoa(cursor))
+	return "https://api.polygon.io:443" + r.URL.Path + "?" + q.Encode()
+}
+
+// onDay - is the timestamp on the New York trading day date (or is there no date filter)?
+func onDay(ts int64, date string) bool {
+	if date == "" {
+		return true
+	}
+	return time.Unix(0, ts).In(calendar.NewYork).Format(calendar.DateLayout) == date
+}
+
+// writeJSON - send v with a status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+var (
+	idMu sync.Mutex
+	ids  int
+)
+
+// requestID - unique-ish request id like polygon's
+func requestID() string {
+	idMu.Lock()
+	defer idMu.Unlock()
+	ids++
+	return fmt.Sprintf("req-%08d", ids)
+}
@@ -0,0 +1,133 @@
+package polygon
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RateLimiter - token bucket shared by every goroutine making polygon requests
+//
+// Tokens refill at rate per second up to burst. Callers reserve a token under
+// the lock (the bucket may go negative) and sleep outside it, so waiters are
+// served in the order they arrived.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64   // tokens per second
+	burst  float64   // bucket size
+	tokens float64   // available tokens, negative when reserved ahead
+	last   time.Time // last refill
+
+	requests int64         // total Wait calls
+	waited   int64         // Wait calls that had to sleep
+	total    time.Duration // time spent sleeping
+	max      time.Duration // longest single sleep
+}
+
+// NewRateLimiter - limiter for rps requests per second, nil (no limit) when rps <= 0
+func NewRateLimiter(rps float64, burst int) *RateLimiter {
+
+	if rps <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &RateLimiter{
+		rate:   rps,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Wait - block until a request is allowed or ctx is done
+func (l *RateLimiter) Wait(ctx context.Context) error {
+
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	// take our token, if that puts us in debt we wait for it to refill
+	l.tokens--
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+
+	l.requests++
+	if wait > 0 {
+		l.waited++
+		l.total += wait
+		if wait > l.max {
+			l.max = wait
+		}
+	}
+
+	l.mu.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+
+	if err := sleep(ctx, wait); err != nil {
+		// give back the token we won't be using
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+// LimiterStats - how much the limiter has slowed us down
+type LimiterStats struct {
+	Requests int64         // total Wait calls
+	Waited   int64         // Wait calls that had to sleep
+	Total    time.Duration // time spent sleeping across all goroutines
+	Max      time.Duration // longest single sleep
+}
+
+// Stats - snapshot of the limiter stats
+func (l *RateLimiter) Stats() LimiterStats {
+
+	if l == nil {
+		return LimiterStats{}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return LimiterStats{Requests: l.requests, Waited: l.waited, Total: l.total, Max: l.max}
+}
+
+// String - stats on how much time was spent waiting on the limiter
+func (l *RateLimiter) String() string {
+
+	if l == nil {
+		return "rate limit: off"
+	}
+
+	st := l.Stats()
+
+	var avg time.Duration
+	if st.Waited > 0 {
+		avg = st.Total / time.Duration(st.Waited)
+	}
+
+	return fmt.Sprintf("rate limit: %v req/s burst %v, %v requests, %v waited (total %v, avg %v, max %v)",
+		l.rate, l.burst, st.Requests, st.Waited,
+		st.Total.Round(time.Millisecond), avg.Round(time.Millisecond), st.Max.Round(time.Millisecond))
+}
@@ -0,0 +1,136 @@
+package polygon_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jweissig/polygon/polygon"
+)
+
+func TestRateLimiterOff(t *testing.T) {
+
+	for _, rps := range []float64{0, -1} {
+		l := polygon.NewRateLimiter(rps, 10)
+		if l != nil {
+			t.Errorf("rps %v: got a limiter", rps)
+		}
+
+		// a nil limiter never waits and counts nothing
+		start := time.Now()
+		for i := 0; i < 1000; i++ {
+			if err := l.Wait(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if d := time.Since(start); d > 50*time.Millisecond {
+			t.Errorf("rps %v: 1000 calls took %v", rps, d)
+		}
+		if st := l.Stats(); st != (polygon.LimiterStats{}) {
+			t.Errorf("rps %v: stats %+v", rps, st)
+		}
+		if s := l.String(); s != "rate limit: off" {
+			t.Errorf("rps %v: %q", rps, s)
+		}
+	}
+}
+
+func TestRateLimiterPacing(t *testing.T) {
+
+	for _, c := range []struct {
+		rps    float64
+		burst  int
+		calls  int
+		bursts int // calls let through without waiting
+	}{
+		{20, 5, 9, 5},
+		{20, 1, 4, 1},
+		{20, 0, 4, 1}, // a burst under 1 still lets one through
+		{50, 3, 3, 3}, // never runs out
+	} {
+		l := polygon.NewRateLimiter(c.rps, c.burst)
+		interval := time.Duration(float64(time.Second) / c.rps)
+
+		start := time.Now()
+		for i := 0; i < c.calls; i++ {
+			if err := l.Wait(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			// the burst goes straight through
+			if i == c.bursts-1 {
+				if d := time.Since(start); d > interval/2 {
+					t.Errorf("%+v: burst of %v took %v", c, c.bursts, d)
+				}
+			}
+		}
+
+		// then one call per interval
+		paced := c.calls - c.bursts
+		want := time.Duration(paced) * interval
+		if d := time.Since(start); d < want-interval/10 || d > want+interval {
+			t.Errorf("%+v: %v calls took %v, want about %v", c, c.calls, d, want)
+		}
+
+		st := l.Stats()
+		if st.Requests != int64(c.calls) || st.Waited != int64(paced) {
+			t.Errorf("%+v: %v requests, %v waited", c, st.Requests, st.Waited)
+		}
+		if st.Total > want || paced > 0 && st.Total < want-interval/10 {
+			t.Errorf("%+v: waited %v in total, want about %v", c, st.Total, want)
+		}
+		if st.Max > interval || paced > 0 && st.Max < interval*9/10 {
+			t.Errorf("%+v: longest wait %v, want about %v", c, st.Max, interval)
+		}
+	}
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+
+	for _, c := range []struct {
+		name    string
+		ctx     func() (context.Context, context.CancelFunc)
+		want    error
+		timeout time.Duration // how long the cancelled Wait may take
+	}{
+		{"cancelled before", func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			return ctx, cancel
+		}, context.Canceled, 20 * time.Millisecond},
+		{"deadline while waiting", func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 20*time.Millisecond)
+		}, context.DeadlineExceeded, 60 * time.Millisecond},
+	} {
+		// one request every 100ms, the first goes straight through
+		l := polygon.NewRateLimiter(10, 1)
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := c.ctx()
+		start := time.Now()
+		err := l.Wait(ctx)
+		cancel()
+		if !errors.Is(err, c.want) {
+			t.Errorf("%v: got %v, want %v", c.name, err, c.want)
+		}
+		if d := time.Since(start); d > c.timeout {
+			t.Errorf("%v: took %v to give up", c.name, d)
+		}
+
+		// the token is given back, so the next call waits for the one slot
+		// after the first request rather than two
+		start = time.Now()
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if d := time.Since(start); d > 150*time.Millisecond {
+			t.Errorf("%v: next call waited %v, the token wasn't given back", c.name, d)
+		}
+
+		if st := l.Stats(); st.Requests != 3 || st.Waited != 2 {
+			t.Errorf("%v: %v requests, %v waited", c.name, st.Requests, st.Waited)
+		}
+	}
+}
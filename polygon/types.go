@@ -0,0 +1,83 @@
+package polygon
+
+import "time"
+
+// Ticker - https://polygon.io/docs/stocks/get_v3_reference_tickers
+type Ticker struct {
+	Ticker          string    `json:"ticker"`                     // "CPK"
+	Name            string    `json:"name"`                       // "Chesapeake Utilities"
+	Market          string    `json:"market"`                     // "stocks"
+	Locale          string    `json:"locale"`                     // "us"
+	PrimaryExchange string    `json:"primary_exchange"`           // "XNYS"
+	Type            string    `json:"type"`                       // "CS"
+	Active          bool      `json:"active"`                     //  true
+	CurrencyName    string    `json:"currency_name"`              // "usd"
+	Cik             string    `json:"cik,omitempty"`              // "0000019745"
+	CompositeFigi   string    `json:"composite_figi,omitempty"`   // "BBG000G4GKH3"
+	ShareClassFigi  string    `json:"share_class_figi,omitempty"` // "BBG001SCP5R2"
+	LastUpdatedUtc  time.Time `json:"last_updated_utc"`           // "2021-03-04T00:00:00Z
+}
+
+// Trade - https://polygon.io/docs/stocks/get_v3_trades__stockticker
+type Trade struct {
+	Conditions           []int   `json:"conditions"`              // A list of condition codes.
+	Correction           int     `json:"correction"`              // The trade correction indicator.
+	Exchange             int     `json:"exchange"`                // The exchange ID. See Exchanges for Polygon.io's mapping of exchange IDs.
+	ID                   string  `json:"id"`                      // The Trade ID which uniquely identifies a trade.
+	ParticipantTimestamp int64   `json:"participant_timestamp"`   // The nanosecond accuracy Participant/Exchange Unix Timestamp.
+	Price                float64 `json:"price"`                   // The price of the trade.
+	SequenceNumber       int     `json:"sequence_number"`         // The sequence number represents the sequence in which trade events happened.
+	SipTimestamp         int64   `json:"sip_timestamp"`           // The nanosecond accuracy SIP Unix Timestamp.
+	Size                 int64   `json:"size"`                    // The size of a trade (also known as volume).
+	Tape                 int     `json:"tape"`                    // There are 3 tapes which define which exchange the ticker is listed on.
+	TrfID                int     `json:"trf_id,omitempty"`        // The ID for the Trade Reporting Facility where the trade took place.
+	TrfTimestamp         int64   `json:"trf_timestamp,omitempty"` // The nanosecond accuracy TRF (Trade Reporting Facility) Unix Timestamp.
+}
+
+// Quote - https://polygon.io/docs/stocks/get_v3_quotes__stockticker
+type Quote struct {
+	AskExchange          int     `json:"ask_exchange"`          // The ask exchange ID.
+	AskPrice             float64 `json:"ask_price"`             // The ask price.
+	AskSize              int     `json:"ask_size"`              // The ask size.
+	BidExchange          int     `json:"bid_exchange"`          // The bid exchange ID.
+	BidPrice             float64 `json:"bid_price"`             // The bid price.
+	BidSize              int     `json:"bid_size"`              // The bid size.
+	Conditions           []int   `json:"conditions"`            // A list of condition codes.
+	Indicators           []int   `json:"indicators"`            // The indicators.
+	ParticipantTimestamp int64   `json:"participant_timestamp"` // The nanosecond accuracy Participant/Exchange Unix Timestamp.
+	SequenceNumber       int     `json:"sequence_number"`       // The sequence number represents the sequence in which quote events happened.
+	SipTimestamp         int64   `json:"sip_timestamp"`         // The nanosecond accuracy SIP Unix Timestamp.
+	Tape                 int     `json:"tape"`                  // There are 3 tapes which define which exchange the ticker is listed on.
+}
+
+// page - the envelope every v3 list endpoint returns
+type page struct {
+	Status    string `json:"status"`
+	RequestID string `json:"request_id"`
+	Count     int    `json:"count"`
+	NextURL   string `json:"next_url"`
+}
+
+// tickersPage - one page of /v3/reference/tickers
+type tickersPage struct {
+	Results []Ticker `json:"results"`
+	page
+}
+
+// tradesPage - one page of /v3/trades/{ticker}
+type tradesPage struct {
+	Results []Trade `json:"results"`
+	page
+}
+
+// quotesPage - one page of /v3/quotes/{ticker}
+type quotesPage struct {
+	Results []Quote `json:"results"`
+	page
+}
+
+func (p *page) next() string { return p.NextURL }
+
+func (p *tickersPage) len() int { return len(p.Results) }
+func (p *tradesPage) len() int  { return len(p.Results) }
+func (p *quotesPage) len() int  { return len(p.Results) }
@@ -0,0 +1,180 @@
+package tqfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// BlockSize - events per block written by Writer
+const BlockSize = 1 << 16
+
+// blockInfoSize - bytes per index entry
+const blockInfoSize = 8 + 4 + 4 + 4 + 4 + 8 + 8
+
+// blockInfo - an index entry, where a block is and what it holds
+type blockInfo struct {
+	Offset int64  // from the start of the header block
+	Length int64  // bytes, length prefix included
+	Trades int    // trades in the block
+	Quotes int    // quotes in the block
+	CRC    uint32 // CRC-32C of the block, length prefix included
+	FirstT int64  // SIP timestamp (ns) of the first event
+	LastT  int64  // SIP timestamp (ns) of the last event
+}
+
+// encodeIndex - the index entries as written after the last block
+func encodeIndex(index []blockInfo) []byte {
+
+	data := make([]byte, len(index)*blockInfoSize)
+	for i, b := range index {
+		p := data[i*blockInfoSize:]
+		binary.LittleEndian.PutUint64(p[0:], uint64(b.Offset))
+		binary.LittleEndian.PutUint32(p[8:], uint32(b.Length))
+		binary.LittleEndian.PutUint32(p[12:], uint32(b.Trades))
+		binary.LittleEndian.PutUint32(p[16:], uint32(b.Quotes))
+		binary.LittleEndian.PutUint32(p[20:], b.CRC)
+		binary.LittleEndian.PutUint64(p[24:], uint64(b.FirstT))
+		binary.LittleEndian.PutUint64(p[32:], uint64(b.LastT))
+	}
+
+	return data
+}
+
+// decodeIndex - parse the index and check it against the header: blocks
+// follow each other from the end of the header block to the index, in time
+// order, and add up to the header's counts
+func decodeIndex(data []byte, h *Header) ([]blockInfo, error) {
+
+	if len(data) != h.Blocks*blockInfoSize {
+		return nil, fmt.Errorf("index is %v bytes, want %v for %v blocks", len(data), h.Blocks*blockInfoSize, h.Blocks)
+	}
+	if crc := crc32.Checksum(data, castagnoli); crc != h.IndexCRC {
+		return nil, fmt.Errorf("index checksum %08x, header says %08x", crc, h.IndexCRC)
+	}
+
+	index := make([]blockInfo, h.Blocks)
+	offset := int64(HeaderSize)
+	var trades, quotes int
+	for i := range index {
+		p := data[i*blockInfoSize:]
+		b := blockInfo{
+			Offset: int64(binary.LittleEndian.Uint64(p[0:])),
+			Length: int64(binary.LittleEndian.Uint32(p[8:])),
+			Trades: int(binary.LittleEndian.Uint32(p[12:])),
+			Quotes: int(binary.LittleEndian.Uint32(p[16:])),
+			CRC:    binary.LittleEndian.Uint32(p[20:]),
+			FirstT: int64(binary.LittleEndian.Uint64(p[24:])),
+			LastT:  int64(binary.LittleEndian.Uint64(p[32:])),
+		}
+		switch {
+		case b.Offset != offset || b.Length <= 4:
+			return nil, fmt.Errorf("bad index: block %v at %v (%v bytes), want it at %v", i, b.Offset, b.Length, offset)
+		case b.FirstT > b.LastT || (i > 0 && b.FirstT < index[i-1].LastT):
+			return nil, fmt.Errorf("bad index: block %v covers %v to %v", i, b.FirstT, b.LastT)
+		case b.Trades+b.Quotes == 0:
+			return nil, fmt.Errorf("bad index: block %v is empty", i)
+		}
+		index[i] = b
+		offset += b.Length
+		trades += b.Trades
+		quotes += b.Quotes
+	}
+
+	switch {
+	case offset != h.IndexOffset:
+		return nil, fmt.Errorf("bad index: blocks end at %v, index starts at %v", offset, h.IndexOffset)
+	case trades != h.Trades || quotes != h.Quotes:
+		return nil, fmt.Errorf("bad index: %v trades and %v quotes, header says %v and %v", trades, quotes, h.Trades, h.Quotes)
+	}
+
+	return index, nil
+}
+
+// EncodeEvents - events gob encoded as a block holds them before it's
+// compressed, every block has its own gob stream so it decodes on its own
+func EncodeEvents(events []Event) ([]byte, error) {
+
+	var b batch
+	for _, e := range events {
+		if e.Trade != nil {
+			b.Kinds = append(b.Kinds, Trade[0])
+			b.Trades = append(b.Trades, *e.Trade)
+		} else {
+			b.Kinds = append(b.Kinds, Quote[0])
+			b.Quotes = append(b.Quotes, *e.Quote)
+		}
+	}
+
+	var raw bytes.Buffer
+	err := gob.NewEncoder(&raw).Encode(&b)
+	return raw.Bytes(), err
+}
+
+// DecodeEvents - the events of a block decompressed, as made by EncodeEvents
+func DecodeEvents(raw []byte) ([]Event, error) {
+
+	var b batch
+	if err := gob.NewDecoder(bytes.NewReader(raw)).Decode(&b); err != nil {
+		return nil, err
+	}
+
+	return b.events()
+}
+
+// encodeBlock - a length prefix followed by the batch gob encoded and
+// compressed with codec, reusing dst's memory
+func encodeBlock(dst []byte, raw *bytes.Buffer, codec Codec, b *batch) ([]byte, error) {
+
+	raw.Reset()
+	if err := gob.NewEncoder(raw).Encode(b); err != nil {
+		return nil, err
+	}
+
+	dst, err := codec.Encode(append(dst[:0], 0, 0, 0, 0), raw.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	binary.LittleEndian.PutUint32(dst, uint32(len(dst)-4))
+
+	return dst, nil
+}
+
+// readBlock - the next block of a stream, length prefix included
+func readBlock(r io.Reader) ([]byte, error) {
+
+	prefix := make([]byte, 4)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, err
+	}
+	n := binary.LittleEndian.Uint32(prefix)
+	if n > 1<<30 {
+		return nil, fmt.Errorf("bad block length %v", n)
+	}
+
+	data := make([]byte, 4+n)
+	copy(data, prefix)
+	if _, err := io.ReadFull(r, data[4:]); err != nil {
+		return nil, fmt.Errorf("reading block: %w", io.ErrUnexpectedEOF)
+	}
+
+	return data, nil
+}
+
+// decodeBlock - the events of a block read with readBlock
+func decodeBlock(data []byte, codec Codec) ([]Event, error) {
+
+	if n := binary.LittleEndian.Uint32(data); int(n) != len(data)-4 {
+		return nil, fmt.Errorf("block length %v, got %v bytes", n, len(data)-4)
+	}
+
+	raw, err := codec.Decode(nil, data[4:])
+	if err != nil {
+		return nil, err
+	}
+
+	return DecodeEvents(raw)
+}
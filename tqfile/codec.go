@@ -0,0 +1,315 @@
+package tqfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+)
+
+// block compression codecs, as named in a file's header
+const (
+	CodecLZ4  = "lz4"
+	CodecZstd = "zstd"
+	CodecNone = "none"
+)
+
+// Codecs - every codec NewCodec knows
+var Codecs = []string{CodecLZ4, CodecZstd, CodecNone}
+
+// zstd dictionary magic number, first 4 bytes of a dictionary made with `zstd --train`
+const zstdDictMagic = 0xEC30A437
+
+// zstd frame magic number, first 4 bytes of a zstd compressed block
+const zstdFrameMagic = 0xFD2FB528
+
+// Codec - how a file's blocks are compressed
+//
+// Codecs are safe for concurrent use, so one can be shared by every writer.
+type Codec interface {
+	Name() string                           // as recorded in the header
+	Encode(dst, src []byte) ([]byte, error) // append src compressed to dst
+	Decode(dst, src []byte) ([]byte, error) // append src decompressed to dst
+}
+
+// NewCodec - codec by name; level 0 is the codec's default, dict is a zstd
+// dictionary (nil for none)
+//
+// lz4 levels go from 1 (fast) to 9 (slow, smaller), 0 is lz4's fast
+// non-HC mode; 8 already searches the whole 64 KiB window, so 9 is the same
+// as 8. zstd levels are the zstd command's, 1 to 22, mapped onto the
+// four speeds the Go encoder has.
+func NewCodec(name string, level int, dict []byte) (Codec, error) {
+
+	if dict != nil && name != CodecZstd {
+		return nil, fmt.Errorf("dictionaries only work with %v, not %v", CodecZstd, name)
+	}
+
+	switch name {
+	case CodecLZ4:
+		if level < 0 || level > 9 {
+			return nil, fmt.Errorf("lz4 level must be 0 to 9 (0 = default), got %v", level)
+		}
+		return newLZ4Codec(level), nil
+	case CodecZstd:
+		if level < 0 || level > 22 {
+			return nil, fmt.Errorf("zstd level must be 0 to 22 (0 = default), got %v", level)
+		}
+		return newZstdCodec(level, dict)
+	case CodecNone:
+		if level != 0 {
+			return nil, fmt.Errorf("%v has no levels", CodecNone)
+		}
+		return noneCodec{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown codec %q, want one of %v", name, Codecs)
+}
+
+// defaultCodec - what Writer uses unless told otherwise, fast lz4
+var defaultCodec = newLZ4Codec(0)
+
+// LoadCodec - NewCodec with the zstd dictionary (if any) read from dictPath
+func LoadCodec(name string, level int, dictPath string) (Codec, error) {
+
+	if dictPath == "" {
+		return NewCodec(name, level, nil)
+	}
+	dict, err := ioutil.ReadFile(dictPath)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewCodec(name, level, dict)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", dictPath, err)
+	}
+
+	return c, nil
+}
+
+// LoadDict - read a zstd dictionary file and AddDict it
+func LoadDict(path string) error {
+
+	dict, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if _, err := AddDict(dict); err != nil {
+		return fmt.Errorf("%v: %w", path, err)
+	}
+
+	return nil
+}
+
+// codecFor - the codec to decode a file's blocks with
+func codecFor(h *Header) (Codec, error) {
+
+	switch h.Codec {
+	case CodecLZ4:
+		return defaultCodec, nil
+	case CodecNone:
+		return noneCodec{}, nil
+	case CodecZstd:
+		dec, err := zstdDecoder(h.ZstdDict)
+		if err != nil {
+			return nil, err
+		}
+		return &zstdCodec{dec: dec, dict: h.ZstdDict}, nil
+	}
+
+	return nil, fmt.Errorf("unknown codec %q", h.Codec)
+}
+
+// lz4Window - how far back lz4 matches can reach, so the deepest search there is
+const lz4Window = 64 << 10
+
+// lz4Codec - every block an lz4 frame, with a content checksum
+type lz4Codec struct {
+	writers *sync.Pool
+}
+
+func newLZ4Codec(level int) *lz4Codec {
+
+	// levels as in lz4 v3+: each one searches twice as deep, up to the
+	// window matches can reach back into
+	depth := 0
+	if level > 0 {
+		depth = 1 << (8 + level)
+	}
+	if depth > lz4Window {
+		depth = lz4Window
+	}
+
+	return &lz4Codec{writers: &sync.Pool{New: func() interface{} {
+		zw := lz4.NewWriter(nil)
+		zw.Header.CompressionLevel = depth
+		return zw
+	}}}
+}
+
+func (c *lz4Codec) Name() string {
+	return CodecLZ4
+}
+
+func (c *lz4Codec) Encode(dst, src []byte) ([]byte, error) {
+
+	buf := bytes.NewBuffer(dst)
+	zw := c.writers.Get().(*lz4.Writer)
+	defer c.writers.Put(zw)
+
+	zw.Reset(buf)
+	if _, err := zw.Write(src); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (c *lz4Codec) Decode(dst, src []byte) ([]byte, error) {
+
+	buf := bytes.NewBuffer(dst)
+	if _, err := io.Copy(buf, lz4.NewReader(bytes.NewReader(src))); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// zstdCodec - every block a zstd frame, with a content checksum
+type zstdCodec struct {
+	enc  *zstd.Encoder // nil when only decoding
+	dec  *zstd.Decoder
+	dict uint32 // dictionary id, 0 for none
+}
+
+func newZstdCodec(level int, dict []byte) (*zstdCodec, error) {
+
+	if level == 0 {
+		level = 3
+	}
+	opts := []zstd.EOption{zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)), zstd.WithEncoderCRC(true)}
+
+	var id uint32
+	if dict != nil {
+		var err error
+		if id, err = AddDict(dict); err != nil {
+			return nil, err
+		}
+		opts = append(opts, zstd.WithEncoderDict(dict))
+	}
+
+	enc, err := zstd.NewWriter(nil, opts...)
+	if err != nil {
+		return nil, err
+	}
+	dec, err := zstdDecoder(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &zstdCodec{enc: enc, dec: dec, dict: id}, nil
+}
+
+func (c *zstdCodec) Name() string {
+	return CodecZstd
+}
+
+func (c *zstdCodec) Encode(dst, src []byte) ([]byte, error) {
+	if c.enc == nil {
+		return nil, errors.New("zstd codec can only decode")
+	}
+	return c.enc.EncodeAll(src, dst), nil
+}
+
+func (c *zstdCodec) Decode(dst, src []byte) ([]byte, error) {
+	return c.dec.DecodeAll(src, dst)
+}
+
+// noneCodec - blocks stored as they are
+type noneCodec struct{}
+
+func (noneCodec) Name() string {
+	return CodecNone
+}
+
+func (noneCodec) Encode(dst, src []byte) ([]byte, error) {
+	return append(dst, src...), nil
+}
+
+func (noneCodec) Decode(dst, src []byte) ([]byte, error) {
+	return append(dst, src...), nil
+}
+
+// DictID - the zstd dictionary id a codec's blocks need, 0 for none
+func DictID(c Codec) uint32 {
+	if z, ok := c.(*zstdCodec); ok {
+		return z.dict
+	}
+	return 0
+}
+
+// zstd dictionaries and a decoder per dictionary (0 for none), shared by every reader
+var zstdDicts = struct {
+	sync.Mutex
+	dicts    map[uint32][]byte
+	decoders map[uint32]*zstd.Decoder
+}{dicts: map[uint32][]byte{}, decoders: map[uint32]*zstd.Decoder{}}
+
+// AddDict - make a zstd dictionary (made with `zstd --train`) available for
+// reading files compressed with it, returns its id
+func AddDict(dict []byte) (uint32, error) {
+
+	if len(dict) < 8 || binary.LittleEndian.Uint32(dict) != zstdDictMagic {
+		return 0, errors.New("not a zstd dictionary")
+	}
+	id := binary.LittleEndian.Uint32(dict[4:])
+	if id == 0 {
+		return 0, errors.New("zstd dictionary has no id")
+	}
+
+	zstdDicts.Lock()
+	defer zstdDicts.Unlock()
+	if old, ok := zstdDicts.dicts[id]; ok && !bytes.Equal(old, dict) {
+		return 0, fmt.Errorf("a different zstd dictionary with id %v was already added", id)
+	}
+	zstdDicts.dicts[id] = dict
+
+	return id, nil
+}
+
+// zstdDecoder - the shared decoder for dictionary id
+func zstdDecoder(id uint32) (*zstd.Decoder, error) {
+
+	zstdDicts.Lock()
+	defer zstdDicts.Unlock()
+
+	if dec, ok := zstdDicts.decoders[id]; ok {
+		return dec, nil
+	}
+
+	var opts []zstd.DOption
+	if id != 0 {
+		dict, ok := zstdDicts.dicts[id]
+		if !ok {
+			return nil, fmt.Errorf("compressed with zstd dictionary %v, which hasn't been loaded", id)
+		}
+		opts = append(opts, zstd.WithDecoderDicts(dict))
+	}
+
+	dec, err := zstd.NewReader(nil, opts...)
+	if err != nil {
+		return nil, err
+	}
+	zstdDicts.decoders[id] = dec
+
+	return dec, nil
+}
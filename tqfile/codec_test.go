@@ -0,0 +1,241 @@
+package tqfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pierrec/lz4"
+)
+
+// testDict - a zstd dictionary trained on test event blocks, id 1001
+const testDict = "testdata/test.dict"
+
+// readTestDict - the bytes of testDict
+func readTestDict(t *testing.T) []byte {
+	t.Helper()
+
+	dict, err := ioutil.ReadFile(testDict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dict
+}
+
+// forgetDict - drop a dictionary added with AddDict, as if it never had been
+func forgetDict(id uint32) {
+	zstdDicts.Lock()
+	defer zstdDicts.Unlock()
+	delete(zstdDicts.dicts, id)
+	delete(zstdDicts.decoders, id)
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+
+	dict := readTestDict(t)
+	events := testEvents(3000)
+	raw, err := EncodeEvents(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name  string
+		level int
+		dict  []byte
+		id    uint32
+	}{
+		{CodecLZ4, 0, nil, 0},
+		{CodecLZ4, 1, nil, 0},
+		{CodecLZ4, 9, nil, 0},
+		{CodecZstd, 0, nil, 0},
+		{CodecZstd, 19, nil, 0},
+		{CodecZstd, 0, dict, 1001},
+		{CodecZstd, 22, dict, 1001},
+		{CodecNone, 0, nil, 0},
+	} {
+		codec, err := NewCodec(c.name, c.level, c.dict)
+		if err != nil {
+			t.Fatalf("%v %v: %v", c.name, c.level, err)
+		}
+		if codec.Name() != c.name || DictID(codec) != c.id {
+			t.Errorf("%v %v: codec %v with dictionary %v", c.name, c.level, codec.Name(), DictID(codec))
+		}
+
+		// both append to what's already in dst
+		enc, err := codec.Encode([]byte("pre"), raw)
+		if err != nil {
+			t.Fatalf("%v %v: %v", c.name, c.level, err)
+		}
+		if string(enc[:3]) != "pre" {
+			t.Fatalf("%v %v: encoding didn't append", c.name, c.level)
+		}
+		dec, err := codec.Decode([]byte("pre"), enc[3:])
+		if err != nil {
+			t.Fatalf("%v %v: %v", c.name, c.level, err)
+		}
+		if !bytes.Equal(dec[3:], raw) || string(dec[:3]) != "pre" {
+			t.Errorf("%v %v: block doesn't decode to what was encoded", c.name, c.level)
+		}
+
+		// and through a file, which records the codec for reading it back
+		path := writeTestFile(t, events, WithCodec(codec))
+		if err := Verify(path); err != nil {
+			t.Errorf("%v %v: %v", c.name, c.level, err)
+		}
+		r := openTestFile(t, path)
+		if h := r.Header(); h.Codec != c.name || h.ZstdDict != c.id {
+			t.Errorf("%v %v: header says %v with dictionary %v", c.name, c.level, h.Codec, h.ZstdDict)
+		}
+		if got := readEvents(t, r.Iter(Filter{})); !reflect.DeepEqual(got, events) {
+			t.Errorf("%v %v: events don't read back as written", c.name, c.level)
+		}
+	}
+}
+
+func TestLZ4Levels(t *testing.T) {
+
+	// each level searches twice as deep as the one before, up to the window
+	for level, want := range map[int]int{0: 0, 1: 512, 7: 32 << 10, 8: lz4Window, 9: lz4Window} {
+		zw := newLZ4Codec(level).writers.Get().(*lz4.Writer)
+		if zw.Header.CompressionLevel != want {
+			t.Errorf("level %v searches %v deep, want %v", level, zw.Header.CompressionLevel, want)
+		}
+	}
+
+	raw, err := EncodeEvents(testEvents(5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	eight, err := newLZ4Codec(8).Encode(nil, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nine, err := newLZ4Codec(9).Encode(nil, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(eight, nine) {
+		t.Error("levels 8 and 9 compress differently")
+	}
+}
+
+func TestNewCodecErrors(t *testing.T) {
+
+	dict := readTestDict(t)
+	noID := append([]byte(nil), dict...)
+	binary.LittleEndian.PutUint32(noID[4:], 0)
+
+	for _, c := range []struct {
+		name  string
+		level int
+		dict  []byte
+		want  string
+	}{
+		{CodecLZ4, 10, nil, "lz4 level must be 0 to 9 (0 = default), got 10"},
+		{CodecLZ4, -1, nil, "lz4 level must be 0 to 9 (0 = default), got -1"},
+		{CodecZstd, 23, nil, "zstd level must be 0 to 22 (0 = default), got 23"},
+		{CodecZstd, -1, nil, "zstd level must be 0 to 22 (0 = default), got -1"},
+		{CodecNone, 1, nil, "none has no levels"},
+		{CodecLZ4, 0, dict, "dictionaries only work with zstd, not lz4"},
+		{CodecZstd, 0, []byte("not a dictionary"), "not a zstd dictionary"},
+		{CodecZstd, 0, noID, "zstd dictionary has no id"},
+		{"gzip", 0, nil, `unknown codec "gzip"`},
+	} {
+		if _, err := NewCodec(c.name, c.level, c.dict); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%v %v: got %v, want %q", c.name, c.level, err, c.want)
+		}
+	}
+}
+
+func TestAddDict(t *testing.T) {
+
+	dict := readTestDict(t)
+	for i := 0; i < 2; i++ {
+		if id, err := AddDict(dict); id != 1001 || err != nil {
+			t.Errorf("adding it %v times: id %v, %v", i+1, id, err)
+		}
+	}
+
+	// an id only ever means one dictionary
+	other := append([]byte(nil), dict...)
+	other[len(other)-1] ^= 1
+	if _, err := AddDict(other); err == nil || !strings.Contains(err.Error(), "already added") {
+		t.Errorf("a different dictionary with the same id: %v", err)
+	}
+	if _, err := AddDict(dict[:6]); err == nil {
+		t.Error("added 6 bytes of dictionary")
+	}
+
+	plainLZ4, _ := NewCodec(CodecLZ4, 0, nil)
+	plainZstd, _ := NewCodec(CodecZstd, 0, nil)
+	withDict, err := NewCodec(CodecZstd, 0, dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if DictID(plainLZ4) != 0 || DictID(plainZstd) != 0 || DictID(withDict) != 1001 {
+		t.Errorf("dictionary ids %v, %v and %v, want 0, 0 and 1001", DictID(plainLZ4), DictID(plainZstd), DictID(withDict))
+	}
+}
+
+func TestDictNotLoaded(t *testing.T) {
+
+	codec, err := NewCodec(CodecZstd, 0, readTestDict(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := testEvents(1000)
+	path := writeTestFile(t, events, WithCodec(codec))
+
+	// a fresh process that hasn't loaded the dictionary can't read the file
+	forgetDict(1001)
+	if _, err := Open(path); err == nil || !strings.Contains(err.Error(), "zstd dictionary 1001, which hasn't been loaded") {
+		t.Errorf("opened without the dictionary: %v", err)
+	}
+	if err := Verify(path); err == nil {
+		t.Error("verified without the dictionary")
+	}
+
+	if err := LoadDict(testDict); err != nil {
+		t.Fatal(err)
+	}
+	if got := readEvents(t, openTestFile(t, path).Iter(Filter{})); !reflect.DeepEqual(got, events) {
+		t.Error("events don't read back once the dictionary is loaded")
+	}
+}
+
+func TestVerifyFrames(t *testing.T) {
+
+	for _, c := range []struct {
+		codec string
+		want  string
+	}{
+		{CodecLZ4, "block 1 isn't a whole lz4 frame"},
+		{CodecZstd, "block 1 isn't a zstd frame"},
+	} {
+		codec, err := NewCodec(c.codec, 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := writeTestFile(t, testEvents(BlockSize+10), WithCodec(codec))
+		if err := Verify(path); err != nil {
+			t.Fatalf("%v: %v", c.codec, err)
+		}
+		index := openTestFile(t, path).index
+
+		// damage the second block's frame magic, with a payload checksum to match
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data[index[1].Offset+4] ^= 1
+		data = rewriteHeader(t, data, func(h *Header) { h.PayloadCRC = crc32.Checksum(data[HeaderSize:], castagnoli) })
+		if err := Verify(writeBytes(t, data)); err == nil || err.Error() != c.want {
+			t.Errorf("%v: got %v, want %q", c.codec, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package tqfile
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// HeaderSize - bytes in the header block at the start of a version 2 file,
+// the payload starts right after it
+const HeaderSize = 1024
+
+// header block: magic, uint16 version, uint32 json length, uint32 crc32c of
+// the json, the json, zero padding
+const headerPrefix = 4 + 2 + 4 + 4
+
+// castagnoli - CRC-32C, hardware accelerated on amd64 and arm64
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Header - what a file holds
+//
+// Symbol and Date are set by whoever writes the file, Source and Downloaded
+// say where it came from. Writer fills in the counts, the time range, the
+// payload size and checksum, where the block index is and the codec when
+// it's closed. Version 1 files have no header at all.
+type Header struct {
+	Symbol       string    `json:"symbol"`         // ticker symbol
+	Date         string    `json:"date"`           // trading day (YYYY-MM-DD)
+	Trades       int       `json:"trades"`         // trades in the file
+	Quotes       int       `json:"quotes"`         // quotes in the file
+	MinT         int64     `json:"min_t"`          // first SIP timestamp (ns), 0 for an empty file
+	MaxT         int64     `json:"max_t"`          // last SIP timestamp (ns), 0 for an empty file
+	PayloadBytes int64     `json:"payload_bytes"`  // bytes after the header block
+	PayloadCRC   uint32    `json:"payload_crc32c"` // CRC-32C (Castagnoli) of the payload
+	Blocks       int       `json:"blocks"`         // compressed blocks of up to BlockSize events
+	IndexOffset  int64     `json:"index_offset"`   // where the block index starts, from the start of the header block
+	IndexCRC     uint32    `json:"index_crc32c"`   // CRC-32C of the block index
+	Codec        string    `json:"codec"`          // how blocks are compressed, see Codecs
+	ZstdDict     uint32    `json:"zstd_dict"`      // id of the zstd dictionary blocks need, 0 for none
+	Source       string    `json:"source"`         // API the data came from, e.g. https://api.polygon.io/v3
+	Downloaded   time.Time `json:"downloaded"`     // when it was downloaded
+}
+
+// encodeHeader - the header block for h
+func encodeHeader(h Header) ([]byte, error) {
+
+	data, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > HeaderSize-headerPrefix {
+		return nil, fmt.Errorf("header is %v bytes, the block only has room for %v", len(data), HeaderSize-headerPrefix)
+	}
+
+	block := make([]byte, HeaderSize)
+	copy(block, Magic)
+	binary.LittleEndian.PutUint16(block[4:], Version)
+	binary.LittleEndian.PutUint32(block[6:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(block[10:], crc32.Checksum(data, castagnoli))
+	copy(block[headerPrefix:], data)
+
+	return block, nil
+}
+
+// decodeHeader - parse and check a header block
+func decodeHeader(block []byte) (Header, error) {
+
+	var h Header
+	n := int(binary.LittleEndian.Uint32(block[6:]))
+	if n > HeaderSize-headerPrefix {
+		return h, fmt.Errorf("bad header length %v", n)
+	}
+	data := block[headerPrefix : headerPrefix+n]
+	if crc := crc32.Checksum(data, castagnoli); crc != binary.LittleEndian.Uint32(block[10:]) {
+		return h, errors.New("header checksum mismatch")
+	}
+	if err := json.Unmarshal(data, &h); err != nil {
+		return h, fmt.Errorf("decoding header: %w", err)
+	}
+
+	return h, h.check()
+}
+
+// check - the header makes sense on its own
+func (h *Header) check() error {
+
+	switch {
+	case h.Trades < 0 || h.Quotes < 0:
+		return fmt.Errorf("bad header: %v trades, %v quotes", h.Trades, h.Quotes)
+	case h.MinT > h.MaxT:
+		return fmt.Errorf("bad header: time range %v to %v", h.MinT, h.MaxT)
+	case h.PayloadBytes < 0:
+		return fmt.Errorf("bad header: payload of %v bytes", h.PayloadBytes)
+	case h.Blocks < 0 || h.Blocks > 0 && (h.IndexOffset < HeaderSize || h.IndexOffset+int64(h.Blocks*blockInfoSize) != HeaderSize+h.PayloadBytes):
+		return fmt.Errorf("bad header: index of %v blocks at %v with a payload of %v bytes", h.Blocks, h.IndexOffset, h.PayloadBytes)
+	}
+
+	return nil
+}
+
+// readHeader - the header block of a version 2 file, after the magic and
+// version already read into prefix
+func readHeader(r io.Reader, prefix []byte) (Header, error) {
+
+	block := make([]byte, HeaderSize)
+	copy(block, prefix)
+	if _, err := io.ReadFull(r, block[len(prefix):]); err != nil {
+		return Header{}, fmt.Errorf("reading header: %w", err)
+	}
+
+	return decodeHeader(block)
+}
+
+// payloadReader - counts and checksums the payload as it's read
+type payloadReader struct {
+	r   io.Reader
+	n   int64
+	crc uint32
+}
+
+func (p *payloadReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.n += int64(n)
+	p.crc = crc32.Update(p.crc, castagnoli, b[:n])
+	return n, err
+}
+
+// payloadWriter - counts and checksums the payload as it's written
+type payloadWriter struct {
+	w   io.Writer
+	n   int64
+	crc uint32
+}
+
+func (p *payloadWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.n += int64(n)
+	p.crc = crc32.Update(p.crc, castagnoli, b[:n])
+	return n, err
+}
@@ -0,0 +1,139 @@
+package tqfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testStart - 9:30 New York on 2022-12-23, where test events start
+var testStart = time.Date(2022, 12, 23, 14, 30, 0, 0, time.UTC).UnixNano()
+
+// testEvents - n events a millisecond apart from testStart, every third one a trade
+func testEvents(n int) []Event {
+
+	events := make([]Event, n)
+	for i := range events {
+		ts := testStart + int64(i)*int64(time.Millisecond)
+		if i%3 == 0 {
+			events[i].Trade = &TradeRecord{SipTimestamp: ts, ParticipantTimestamp: ts - 100, SequenceNumber: i, ID: "t", Price: 100 + float64(i%50)/100, Size: int64(1 + i%300), Exchange: i % 20, Tape: 3}
+		} else {
+			events[i].Quote = &QuoteRecord{SipTimestamp: ts, SequenceNumber: i, BidPrice: 100, BidSize: 1 + i%5, AskPrice: 100.05, AskSize: 1 + i%7, Tape: 3}
+		}
+	}
+	return events
+}
+
+// writeTestFile - events written to a file in a temp dir, its path
+func writeTestFile(t *testing.T, events []Event, opts ...WriterOption) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), FileName("AAA", "2022-12-23"))
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w, err := NewWriter(f, Header{Symbol: "AAA", Date: "2022-12-23"}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteAll(events); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestHeaderRoundTrip(t *testing.T) {
+
+	h := Header{
+		Symbol: "AAA", Date: "2022-12-23", Trades: 10, Quotes: 20, MinT: 1, MaxT: 2,
+		PayloadBytes: 400, PayloadCRC: 0xdeadbeef, Blocks: 1, IndexOffset: HeaderSize + 360, IndexCRC: 7,
+		Codec: CodecZstd, ZstdDict: 42, Source: "https://api.polygon.io/v3", Downloaded: time.Date(2022, 12, 24, 1, 2, 3, 0, time.UTC),
+	}
+	block, err := encodeHeader(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(block) != HeaderSize || string(block[:4]) != Magic || binary.LittleEndian.Uint16(block[4:]) != Version {
+		t.Fatalf("header block starts %q", block[:6])
+	}
+
+	got, err := decodeHeader(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != h {
+		t.Errorf("got %+v\nwant %+v", got, h)
+	}
+
+	// the JSON has to fit in the block
+	h.Source = strings.Repeat("x", HeaderSize)
+	if _, err := encodeHeader(h); err == nil || !strings.Contains(err.Error(), "room") {
+		t.Errorf("oversize header: %v", err)
+	}
+}
+
+func TestBadHeaders(t *testing.T) {
+
+	data, err := ioutil.ReadFile(writeTestFile(t, testEvents(100)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// resign - fix up the JSON checksum after changing the JSON
+	resign := func(b []byte) {
+		n := binary.LittleEndian.Uint32(b[6:])
+		binary.LittleEndian.PutUint32(b[10:], crc32.Checksum(b[headerPrefix:headerPrefix+n], castagnoli))
+	}
+	// replace - swap text in the JSON, keeping its length
+	replace := func(old, new string) func([]byte) {
+		return func(b []byte) {
+			i := bytes.Index(b[:HeaderSize], []byte(old))
+			if i < 0 || len(old) != len(new) {
+				t.Fatalf("can't replace %q with %q", old, new)
+			}
+			copy(b[i:], new)
+			resign(b)
+		}
+	}
+
+	for _, c := range []struct {
+		name   string
+		mangle func([]byte)
+		want   string
+	}{
+		{"flipped checksum byte", func(b []byte) { b[11] ^= 1 }, "header checksum mismatch"},
+		{"flipped JSON byte", func(b []byte) { b[headerPrefix+3] ^= 1 }, "header checksum mismatch"},
+		{"bad magic", func(b []byte) { copy(b, "PGTX") }, "not a trades + quotes file"},
+		{"future version", func(b []byte) { binary.LittleEndian.PutUint16(b[4:], Version+1) }, "unsupported file version"},
+		{"version 0", func(b []byte) { binary.LittleEndian.PutUint16(b[4:], 0) }, "unsupported file version"},
+		{"version 1 with a header", func(b []byte) { binary.LittleEndian.PutUint16(b[4:], 1) }, "unsupported file version"},
+		{"JSON longer than the block", func(b []byte) { binary.LittleEndian.PutUint32(b[6:], HeaderSize-headerPrefix+1) }, "bad header length"},
+		{"JSON that isn't", func(b []byte) { b[headerPrefix] = '['; resign(b) }, "decoding header"},
+		{"negative count", replace(`"trades":34`, `"trades":-4`), "bad header"},
+		{"time range backwards", replace(`"min_t":1`, `"min_t":2`), "bad header: time range"},
+	} {
+		b := append([]byte(nil), data...)
+		c.mangle(b)
+		_, err := NewReader(bytes.NewReader(b))
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%v: got %v, want %q", c.name, err, c.want)
+		}
+	}
+
+	// too short to have a header block at all
+	if _, err := NewReader(bytes.NewReader(data[:HeaderSize/2])); err == nil {
+		t.Error("half a header: no error")
+	}
+}
@@ -0,0 +1,366 @@
+package tqfile
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/pierrec/lz4"
+)
+
+// lz4 frame magic number, first 4 bytes of a version 1 file
+const lz4FrameMagic = 0x184D2204
+
+// Filter - which records an Iter returns, the zero value matches everything
+type Filter struct {
+	Events string // Trade, Quote or "" for both
+	From   int64  // first SIP timestamp (ns) to include, 0 for the start of the file
+	To     int64  // SIP timestamp (ns) to stop before, 0 for the end of the file
+}
+
+// match - does the event pass the filter (ignoring the time range end)
+func (f Filter) match(e *Event) bool {
+	if f.Events != "" && e.Kind() != f.Events {
+		return false
+	}
+	return e.T() >= f.From
+}
+
+// Reader - reads records back from a trades + quotes file
+type Reader struct {
+	f       *os.File // nil when reading from a plain io.Reader
+	version int
+	header  Header
+	index   []blockInfo    // files opened with Open
+	codec   Codec          // how blocks are compressed
+	payload *payloadReader // streams
+	dec     *gob.Decoder   // version 1 files
+	blocks  int            // read from the stream so far
+	trades  int            // decoded from the stream so far, checked against the header at the end
+	quotes  int
+}
+
+// Open - open a trades + quotes file, checking its header (and block index)
+// against the file's size before anything is decoded
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r, err := newReader(f, fi.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r.f = f
+
+	if r.version == Version {
+		data := make([]byte, r.header.Blocks*blockInfoSize)
+		if _, err := f.ReadAt(data, r.header.IndexOffset); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("reading index: %w", err)
+		}
+		if r.index, err = decodeIndex(data, &r.header); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// NewReader - read a trades + quotes stream of either version, the header
+// (of a version 2 stream) is read and checked straight away
+func NewReader(rd io.Reader) (*Reader, error) {
+	return newReader(rd, -1)
+}
+
+// newReader - NewReader for a stream of size bytes, -1 if unknown
+func newReader(rd io.Reader, size int64) (*Reader, error) {
+
+	br := bufio.NewReader(rd)
+	head, err := br.Peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("reading file magic: %w", err)
+	}
+
+	r := &Reader{}
+	switch {
+	case binary.LittleEndian.Uint32(head) == lz4FrameMagic:
+		r.version = 1
+		r.dec = gob.NewDecoder(bufio.NewReader(lz4.NewReader(br)))
+		return r, nil
+	case string(head) != Magic:
+		return nil, fmt.Errorf("not a trades + quotes file (magic %x)", head)
+	}
+
+	prefix := make([]byte, 6)
+	if _, err := io.ReadFull(br, prefix); err != nil {
+		return nil, fmt.Errorf("reading file version: %w", err)
+	}
+	r.version = int(binary.LittleEndian.Uint16(prefix[4:]))
+	if r.version != Version {
+		return nil, fmt.Errorf("unsupported file version %v, this build reads 1 and %v", r.version, Version)
+	}
+	if r.header, err = readHeader(br, prefix); err != nil {
+		return nil, err
+	}
+	if size >= 0 && size != HeaderSize+r.header.PayloadBytes {
+		return nil, fmt.Errorf("file is %v bytes, header says %v", size, HeaderSize+r.header.PayloadBytes)
+	}
+	if r.codec, err = codecFor(&r.header); err != nil {
+		return nil, err
+	}
+	r.payload = &payloadReader{r: io.LimitReader(br, r.header.PayloadBytes)}
+
+	return r, nil
+}
+
+// Version - the file's format version
+func (r *Reader) Version() int {
+	return r.version
+}
+
+// Header - what the file holds; version 1 files have no header, the symbol
+// is filled in from the first record once it's read
+func (r *Reader) Header() Header {
+	return r.header
+}
+
+// Close - close the underlying file
+func (r *Reader) Close() error {
+	if r.f == nil {
+		return nil
+	}
+	return r.f.Close()
+}
+
+// Iter - iterate over records matching filter
+//
+// Records are in time order, so iteration stops as soon as it passes
+// filter.To without decoding the rest of the file. Files opened with Open
+// only decode the blocks holding events in the filter's time range (and of
+// its event type), checking each block's checksum; every Iter has its own
+// place in the file. Version 1 files and streams are decoded from the start,
+// and reading to the end of a version 2 stream checks the payload checksum
+// and the trade and quote counts against the header.
+func (r *Reader) Iter(filter Filter) *Iter {
+
+	it := &Iter{r: r, filter: filter}
+	if r.index != nil {
+		// first block that can hold an event at or after From
+		it.block = sort.Search(len(r.index), func(i int) bool { return r.index[i].LastT >= filter.From })
+	}
+
+	return it
+}
+
+// ReadAll - every record in the file at path, as version 1 records
+func ReadAll(path string) ([]TradesQuotesCombined, error) {
+
+	r, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var records []TradesQuotesCombined
+	it := r.Iter(Filter{})
+	for it.Next() {
+		records = append(records, it.Record())
+	}
+
+	return records, it.Err()
+}
+
+// Iter - iterator over the records of a Reader
+type Iter struct {
+	r      *Reader
+	filter Filter
+	batch  []Event
+	raw    []TradesQuotesCombined // the batch as read from a version 1 file
+	pos    int
+	cur    Event
+	block  int // next block of an indexed file
+	done   bool
+	err    error
+}
+
+// Next - advance to the next matching record
+func (it *Iter) Next() bool {
+
+	for !it.done {
+
+		for it.pos < len(it.batch) {
+			e := &it.batch[it.pos]
+			it.pos++
+			if it.filter.To != 0 && e.T() >= it.filter.To {
+				it.done = true
+				return false
+			}
+			if it.filter.match(e) {
+				it.cur = *e
+				return true
+			}
+		}
+
+		it.batch, it.raw, it.pos = nil, nil, 0
+		err := it.decode()
+		if err == io.EOF {
+			it.done = true
+		} else if err != nil {
+			it.err = fmt.Errorf("decoding records: %w", err)
+			it.done = true
+		}
+	}
+
+	return false
+}
+
+// decode - read the next batch
+func (it *Iter) decode() error {
+
+	if it.r.index != nil {
+		return it.decodeBlock()
+	}
+	if it.r.version == Version {
+		return it.r.nextBlock(&it.batch)
+	}
+
+	// version 1: fresh slices every time, gob doesn't send zero fields so
+	// reused records would keep stale values
+	var records []TradesQuotesCombined
+	if err := it.r.dec.Decode(&records); err != nil {
+		return err
+	}
+	it.batch = make([]Event, len(records))
+	for i := range records {
+		it.batch[i] = records[i].Event()
+	}
+	it.raw = records
+	if it.r.header.Symbol == "" && len(records) > 0 {
+		it.r.header.Symbol = records[0].Sym
+	}
+
+	return nil
+}
+
+// decodeBlock - read the next block of an indexed file that can hold events
+// matching the filter
+func (it *Iter) decodeBlock() error {
+
+	for ; it.block < len(it.r.index); it.block++ {
+
+		b := it.r.index[it.block]
+		if it.filter.To != 0 && b.FirstT >= it.filter.To {
+			return io.EOF
+		}
+		if it.filter.Events == Trade && b.Trades == 0 || it.filter.Events == Quote && b.Quotes == 0 {
+			continue
+		}
+		it.block++
+
+		data := make([]byte, b.Length)
+		if _, err := it.r.f.ReadAt(data, b.Offset); err != nil {
+			return fmt.Errorf("reading block at %v: %w", b.Offset, err)
+		}
+		if crc := crc32.Checksum(data, castagnoli); crc != b.CRC {
+			return fmt.Errorf("block at %v: checksum %08x, index says %08x", b.Offset, crc, b.CRC)
+		}
+		events, err := decodeBlock(data, it.r.codec)
+		if err != nil {
+			return fmt.Errorf("block at %v: %w", b.Offset, err)
+		}
+		var trades int
+		for i := range events {
+			if events[i].Trade != nil {
+				trades++
+			}
+		}
+		if trades != b.Trades || len(events)-trades != b.Quotes {
+			return fmt.Errorf("block at %v: %v trades and %v quotes, index says %v and %v", b.Offset, trades, len(events)-trades, b.Trades, b.Quotes)
+		}
+
+		it.batch = events
+		return nil
+	}
+
+	return io.EOF
+}
+
+// nextBlock - read the next block of a version 2 stream
+func (r *Reader) nextBlock(events *[]Event) error {
+
+	if r.blocks == r.header.Blocks {
+		return r.finish()
+	}
+
+	data, err := readBlock(r.payload)
+	if err != nil {
+		return err
+	}
+	r.blocks++
+	if *events, err = decodeBlock(data, r.codec); err != nil {
+		return err
+	}
+	for i := range *events {
+		if (*events)[i].Trade != nil {
+			r.trades++
+		} else {
+			r.quotes++
+		}
+	}
+
+	return nil
+}
+
+// finish - at the end of a version 2 stream, check it against the header;
+// io.EOF if it matches
+func (r *Reader) finish() error {
+
+	if _, err := io.Copy(ioutil.Discard, r.payload); err != nil {
+		return err
+	}
+
+	h := &r.header
+	switch {
+	case r.payload.n != h.PayloadBytes:
+		return fmt.Errorf("payload is %v bytes, header says %v", r.payload.n, h.PayloadBytes)
+	case r.payload.crc != h.PayloadCRC:
+		return fmt.Errorf("payload checksum %08x, header says %08x", r.payload.crc, h.PayloadCRC)
+	case r.trades != h.Trades || r.quotes != h.Quotes:
+		return fmt.Errorf("read %v trades and %v quotes, header says %v and %v", r.trades, r.quotes, h.Trades, h.Quotes)
+	}
+
+	return io.EOF
+}
+
+// Event - the current record
+func (it *Iter) Event() Event {
+	return it.cur
+}
+
+// Record - the current record as a version 1 record, exactly as it was
+// read from version 1 files
+func (it *Iter) Record() TradesQuotesCombined {
+	if it.raw != nil {
+		return it.raw[it.pos-1]
+	}
+	return it.cur.Combined(it.r.header.Symbol)
+}
+
+// Err - the error that stopped iteration, nil at a clean end of file
+func (it *Iter) Err() error {
+	return it.err
+}
@@ -0,0 +1,394 @@
+package tqfile
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readEvents - every event an Iter returns, failing the test on an error
+func readEvents(t *testing.T, it *Iter) []Event {
+	t.Helper()
+
+	var events []Event
+	for it.Next() {
+		events = append(events, it.Event())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+// openTestFile - a Reader of the file at path, closed when the test ends
+func openTestFile(t *testing.T, path string) *Reader {
+	t.Helper()
+
+	r, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+// rewriteHeader - data with its header block changed by edit
+func rewriteHeader(t *testing.T, data []byte, edit func(*Header)) []byte {
+	t.Helper()
+
+	h, err := decodeHeader(data[:HeaderSize])
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit(&h)
+	block, err := encodeHeader(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(block, data[HeaderSize:]...)
+}
+
+// at - SIP timestamp of test event i
+func at(i int) int64 {
+	return testStart + int64(i)*int64(time.Millisecond)
+}
+
+func TestFilterTimeRange(t *testing.T) {
+
+	// three blocks, the last one short
+	events := testEvents(2*BlockSize + 1000)
+	path := writeTestFile(t, events)
+	r := openTestFile(t, path)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := r.Header(); h.Blocks != 3 || h.Trades+h.Quotes != len(events) || h.MinT != at(0) || h.MaxT != at(len(events)-1) {
+		t.Fatalf("header %+v", h)
+	}
+
+	for _, c := range []struct {
+		name     string
+		from, to int
+		blocks   int // blocks read, -1 to not check
+	}{
+		{"everything", -1, -1, 3},
+		{"within the first block", 100, 200, 1},
+		{"within the middle block", BlockSize + 10, BlockSize + 20, 1},
+		{"across a block boundary", BlockSize - 10, BlockSize + 10, 2},
+		{"across every block", 10, 2*BlockSize + 10, 3},
+		{"from the start of a block", BlockSize, BlockSize + 5, 1},
+		{"to the start of a block", BlockSize - 5, BlockSize, 1},
+		{"from the end of a block", BlockSize - 1, BlockSize + 1, 2},
+		{"to the end of the file", 2*BlockSize + 999, -1, 1},
+		{"after the last event", len(events) + 10, -1, 0},
+		{"nothing in range", 500, 500, -1},
+	} {
+		var f Filter
+		lo, hi := 0, len(events)
+		if c.from >= 0 {
+			f.From, lo = at(c.from), c.from
+		}
+		if c.to >= 0 {
+			f.To, hi = at(c.to), c.to
+		}
+		if lo > len(events) {
+			lo = len(events)
+		}
+		want := events[lo:hi]
+
+		it := r.Iter(f)
+		first := it.block
+		got := readEvents(t, it)
+		if len(got) != len(want) || len(got) > 0 && (!reflect.DeepEqual(got[0], want[0]) || !reflect.DeepEqual(got[len(got)-1], want[len(want)-1])) {
+			t.Errorf("%v: got %v events, want %v", c.name, len(got), len(want))
+			continue
+		}
+		if c.blocks >= 0 && it.block-first != c.blocks {
+			t.Errorf("%v: read %v blocks, want %v", c.name, it.block-first, c.blocks)
+		}
+
+		// a stream decodes from the start and filters the same way
+		sr, err := NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := readEvents(t, sr.Iter(f)); len(got) != len(want) || len(got) > 0 && !reflect.DeepEqual(got[0], want[0]) {
+			t.Errorf("%v: streamed %v events, want %v", c.name, len(got), len(want))
+		}
+	}
+
+	// every event comes back as it was written
+	if got := readEvents(t, r.Iter(Filter{})); !reflect.DeepEqual(got, events) {
+		t.Error("events don't read back as written")
+	}
+}
+
+// kindEvents - n trades or quotes from test event i on
+func kindEvents(kind string, i, n int) []Event {
+
+	events := make([]Event, n)
+	for j := range events {
+		ts := at(i + j)
+		if kind == Trade {
+			events[j].Trade = &TradeRecord{SipTimestamp: ts, SequenceNumber: i + j, Price: 10, Size: 100}
+		} else {
+			events[j].Quote = &QuoteRecord{SipTimestamp: ts, SequenceNumber: i + j, BidPrice: 10, AskPrice: 10.01}
+		}
+	}
+	return events
+}
+
+// writeBytes - data as a file in a temp dir, its path
+func writeBytes(t *testing.T, data []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), FileName("AAA", "2022-12-23"))
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFilterEvents(t *testing.T) {
+
+	// a block of trades, a block of quotes, then a block of both
+	mixed := testEvents(300)
+	for _, e := range mixed {
+		if e.Trade != nil {
+			e.Trade.SipTimestamp += at(200) - testStart
+		} else {
+			e.Quote.SipTimestamp += at(200) - testStart
+		}
+	}
+	blocks := [][]Event{kindEvents(Trade, 0, 100), kindEvents(Quote, 100, 100), mixed}
+
+	path := filepath.Join(t.TempDir(), FileName("AAA", "2022-12-23"))
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewWriter(f, Header{Symbol: "AAA", Date: "2022-12-23"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range blocks {
+		if err := w.WriteAll(b); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	r := openTestFile(t, path)
+	if len(r.index) != 3 || r.index[0].Quotes != 0 || r.index[1].Trades != 0 {
+		t.Fatalf("index %+v", r.index)
+	}
+	for _, c := range []struct {
+		events string
+		want   int
+	}{
+		{"", 500},
+		{Trade, 200},
+		{Quote, 300},
+	} {
+		got := readEvents(t, r.Iter(Filter{Events: c.events}))
+		if len(got) != c.want {
+			t.Errorf("%q: got %v events, want %v", c.events, len(got), c.want)
+		}
+		for _, e := range got {
+			if c.events != "" && e.Kind() != c.events {
+				t.Fatalf("%q: got a %v", c.events, e.Kind())
+			}
+		}
+	}
+
+	// blocks that can't match aren't read at all, so damage to them goes unnoticed
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[r.index[1].Offset+10] ^= 1
+	r = openTestFile(t, writeBytes(t, data))
+	if got := readEvents(t, r.Iter(Filter{Events: Trade})); len(got) != 200 {
+		t.Errorf("trades past a damaged quote block: got %v, want 200", len(got))
+	}
+	it := r.Iter(Filter{Events: Quote})
+	for it.Next() {
+	}
+	if err := it.Err(); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("quotes from a damaged quote block: %v", err)
+	}
+}
+
+func TestDamagedFiles(t *testing.T) {
+
+	events := testEvents(2*BlockSize + 1000)
+	path := writeTestFile(t, events)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := openTestFile(t, path)
+	index, h := r.index, r.Header()
+
+	// a damaged block is found when it's read, the blocks before it are fine
+	bad := append([]byte(nil), data...)
+	bad[index[1].Offset+100] ^= 0x40
+	it := openTestFile(t, writeBytes(t, bad)).Iter(Filter{})
+	n := 0
+	for it.Next() {
+		n++
+	}
+	if err := it.Err(); err == nil || !strings.Contains(err.Error(), "checksum") || n != BlockSize {
+		t.Errorf("damaged block: %v events then %v", n, err)
+	}
+	if err := Verify(writeBytes(t, bad)); err == nil || !strings.Contains(err.Error(), "payload checksum") {
+		t.Errorf("verifying a damaged block: %v", err)
+	}
+
+	// a damaged index stops Open
+	bad = append([]byte(nil), data...)
+	bad[h.IndexOffset+blockInfoSize+2] ^= 1
+	if _, err := Open(writeBytes(t, bad)); err == nil || !strings.Contains(err.Error(), "index checksum") {
+		t.Errorf("damaged index: %v", err)
+	}
+
+	// as does one with the right checksum that doesn't add up
+	for _, c := range []struct {
+		name string
+		edit func([]blockInfo)
+		want string
+	}{
+		{"block moved", func(ix []blockInfo) { ix[1].Offset++ }, "bad index: block 1 at"},
+		{"blocks out of order", func(ix []blockInfo) { ix[1].FirstT = ix[0].FirstT }, "bad index: block 1 covers"},
+		{"counts", func(ix []blockInfo) { ix[2].Trades++; ix[2].Quotes-- }, "bad index: 44025 trades and 88047 quotes, header says 44024 and 88048"},
+		{"empty block", func(ix []blockInfo) { ix[2].Quotes, ix[2].Trades = 0, 0 }, "bad index: block 2 is empty"},
+	} {
+		ix := append([]blockInfo(nil), index...)
+		c.edit(ix)
+		raw := encodeIndex(ix)
+		bad = rewriteHeader(t, data, func(h *Header) { h.IndexCRC = crc32.Checksum(raw, castagnoli) })
+		copy(bad[h.IndexOffset:], raw)
+		if _, err := Open(writeBytes(t, bad)); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("index %v: got %v, want %q", c.name, err, c.want)
+		}
+	}
+
+	// truncated files are caught by their size when opened, and at the end
+	// of the payload when streamed
+	for _, cut := range []int{1, blockInfoSize + 1, len(data) - int(index[2].Offset) - 10} {
+		short := data[:len(data)-cut]
+		if _, err := Open(writeBytes(t, short)); err == nil || !strings.Contains(err.Error(), "header says") {
+			t.Errorf("cut %v bytes: opened with %v", cut, err)
+		}
+		sr, err := NewReader(bytes.NewReader(short))
+		if err != nil {
+			t.Fatal(err)
+		}
+		it := sr.Iter(Filter{})
+		for it.Next() {
+		}
+		if it.Err() == nil {
+			t.Errorf("cut %v bytes: streamed to the end", cut)
+		}
+	}
+}
+
+func TestEmptyFile(t *testing.T) {
+
+	path := writeTestFile(t, nil)
+	r := openTestFile(t, path)
+	if h := r.Header(); h.Blocks != 0 || h.Trades != 0 || h.Quotes != 0 || h.IndexOffset != HeaderSize || h.PayloadBytes != 0 {
+		t.Errorf("header %+v", h)
+	}
+	if got := readEvents(t, r.Iter(Filter{})); len(got) != 0 {
+		t.Errorf("got %v events", len(got))
+	}
+	if err := Verify(path); err != nil {
+		t.Error(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != HeaderSize {
+		t.Errorf("empty file is %v bytes", len(data))
+	}
+	sr, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readEvents(t, sr.Iter(Filter{})); len(got) != 0 {
+		t.Errorf("streamed %v events", len(got))
+	}
+}
+
+func TestStreamChecks(t *testing.T) {
+
+	data, err := ioutil.ReadFile(writeTestFile(t, testEvents(BlockSize+1000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := decodeHeader(data[:HeaderSize])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"payload checksum", rewriteHeader(t, data, func(h *Header) { h.PayloadCRC++ }), "payload checksum"},
+		{"trade count", rewriteHeader(t, data, func(h *Header) { h.Trades++ }), "read 22179 trades and 44357 quotes, header says 22180"},
+		{"quote count", rewriteHeader(t, data, func(h *Header) { h.Quotes-- }), "header says 22179 and 44356"},
+		{"cut in the index", data[:len(data)-blockInfoSize], fmt.Sprintf("payload is %v bytes, header says %v", h.PayloadBytes-blockInfoSize, h.PayloadBytes)},
+		{"cut in a block", data[:HeaderSize+1000], "reading block"},
+		{"damaged index", func() []byte {
+			b := append([]byte(nil), data...)
+			b[h.IndexOffset] ^= 1
+			return b
+		}(), "payload checksum"},
+	} {
+		r, err := NewReader(bytes.NewReader(c.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		it := r.Iter(Filter{})
+		n := 0
+		for it.Next() {
+			n++
+		}
+		if err := it.Err(); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%v: got %v, want %q", c.name, err, c.want)
+		}
+
+		// the events themselves all came through, it's the stream as a whole that's wrong
+		if c.name != "cut in a block" && n != BlockSize+1000 {
+			t.Errorf("%v: %v events before the error", c.name, n)
+		}
+	}
+
+	// a filter that stops early doesn't read to the end, so isn't checked
+	r, err := NewReader(bytes.NewReader(rewriteHeader(t, data, func(h *Header) { h.Trades++ })))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readEvents(t, r.Iter(Filter{To: at(10)})); len(got) != 10 {
+		t.Errorf("got %v events before To", len(got))
+	}
+}
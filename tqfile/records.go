@@ -0,0 +1,147 @@
+package tqfile
+
+import "fmt"
+
+// TradeRecord - a trade, fields as in polygon.io's /v3/trades
+type TradeRecord struct {
+	SipTimestamp         int64   // The nanosecond accuracy SIP Unix Timestamp.
+	ParticipantTimestamp int64   // The nanosecond accuracy Participant/Exchange Unix Timestamp.
+	TrfTimestamp         int64   // The nanosecond accuracy TRF (Trade Reporting Facility) Unix Timestamp, 0 for most trades.
+	SequenceNumber       int     // Increasing and unique per ticker symbol, not always sequential.
+	ID                   string  // The Trade ID, unique per combination of ticker, exchange and TRF.
+	Price                float64 // The price of the trade.
+	Size                 int64   // The size of the trade (volume).
+	Conditions           []int   // A list of condition codes.
+	Correction           int     // The trade correction indicator.
+	Exchange             int     // The exchange ID.
+	TrfID                int     // The ID for the Trade Reporting Facility where the trade took place.
+	Tape                 int     // 1 = NYSE, 2 = AMEX, 3 = Nasdaq
+}
+
+// QuoteRecord - an NBBO quote, fields as in polygon.io's /v3/quotes
+type QuoteRecord struct {
+	SipTimestamp         int64   // The nanosecond accuracy SIP Unix Timestamp.
+	ParticipantTimestamp int64   // The nanosecond accuracy Participant/Exchange Unix Timestamp.
+	SequenceNumber       int     // Increasing and unique per ticker symbol, not always sequential.
+	BidExchange          int     // The bid exchange ID.
+	BidPrice             float64 // The bid price.
+	BidSize              int     // The bid size in round lots.
+	AskExchange          int     // The ask exchange ID.
+	AskPrice             float64 // The ask price.
+	AskSize              int     // The ask size in round lots.
+	Conditions           []int   // A list of condition codes.
+	Indicators           []int   // A list of indicator codes.
+	Tape                 int     // 1 = NYSE, 2 = AMEX, 3 = Nasdaq
+}
+
+// Event - one event in a file, either a trade or a quote
+type Event struct {
+	Trade *TradeRecord // nil for quotes
+	Quote *QuoteRecord // nil for trades
+}
+
+// T - the SIP timestamp (Unix NS)
+func (e *Event) T() int64 {
+	if e.Trade != nil {
+		return e.Trade.SipTimestamp
+	}
+	return e.Quote.SipTimestamp
+}
+
+// Kind - Trade or Quote
+func (e *Event) Kind() string {
+	if e.Trade != nil {
+		return Trade
+	}
+	return Quote
+}
+
+// batch - the gob value in a block
+//
+// Kinds has a byte per event, 'T' or 'Q', saying how the trades and quotes
+// interleave in time order.
+type batch struct {
+	Kinds  []byte
+	Trades []TradeRecord
+	Quotes []QuoteRecord
+}
+
+// events - the batch's events in order
+func (b *batch) events() ([]Event, error) {
+
+	events := make([]Event, len(b.Kinds))
+	t, q := 0, 0
+	for i, k := range b.Kinds {
+		switch {
+		case k == Trade[0] && t < len(b.Trades):
+			events[i].Trade = &b.Trades[t]
+			t++
+		case k == Quote[0] && q < len(b.Quotes):
+			events[i].Quote = &b.Quotes[q]
+			q++
+		default:
+			return nil, fmt.Errorf("bad batch: event %v of kind %q with %v trades and %v quotes", i, k, len(b.Trades), len(b.Quotes))
+		}
+	}
+	if t != len(b.Trades) || q != len(b.Quotes) {
+		return nil, fmt.Errorf("bad batch: %v kinds for %v trades and %v quotes", len(b.Kinds), len(b.Trades), len(b.Quotes))
+	}
+
+	return events, nil
+}
+
+// Combined - the event as a version 1 record
+func (e *Event) Combined(symbol string) TradesQuotesCombined {
+
+	r := TradesQuotesCombined{Sym: symbol, EV: e.Kind(), T: e.T()}
+
+	if t := e.Trade; t != nil {
+		r.TY, r.TF, r.TQ, r.TI = t.ParticipantTimestamp, t.TrfTimestamp, t.SequenceNumber, t.ID
+		r.TP, r.TS, r.TC, r.TE = t.Price, t.Size, t.Conditions, t.Correction
+		r.TX, r.TR, r.TZ = t.Exchange, t.TrfID, t.Tape
+		return r
+	}
+
+	q := e.Quote
+	r.QY, r.QQ, r.QI = q.ParticipantTimestamp, q.SequenceNumber, q.Indicators
+	r.BX, r.BP, r.BS = q.BidExchange, q.BidPrice, q.BidSize
+	r.AX, r.AP, r.AS = q.AskExchange, q.AskPrice, q.AskSize
+	r.BSC, r.BSZ = q.Conditions, q.Tape
+	return r
+}
+
+// Event - a version 1 record as an event
+func (r *TradesQuotesCombined) Event() Event {
+
+	if r.EV == Trade {
+		return Event{Trade: &TradeRecord{
+			SipTimestamp:         r.T,
+			ParticipantTimestamp: r.TY,
+			TrfTimestamp:         r.TF,
+			SequenceNumber:       r.TQ,
+			ID:                   r.TI,
+			Price:                r.TP,
+			Size:                 r.TS,
+			Conditions:           r.TC,
+			Correction:           r.TE,
+			Exchange:             r.TX,
+			TrfID:                r.TR,
+			Tape:                 r.TZ,
+		}}
+	}
+
+	return Event{Quote: &QuoteRecord{
+		SipTimestamp:         r.T,
+		ParticipantTimestamp: r.QY,
+		SequenceNumber:       r.QQ,
+		BidExchange:          r.BX,
+		BidPrice:             r.BP,
+		BidSize:              r.BS,
+		AskExchange:          r.AX,
+		AskPrice:             r.AP,
+		AskSize:              r.AS,
+		Conditions:           r.BSC,
+		Indicators:           r.QI,
+		Tape:                 r.BSZ,
+	}}
+}
@@ -0,0 +1,136 @@
+// Package tqfile reads and writes the downloader's trades + quotes files.
+//
+// A file (<SYM>-<date>.gob.lz4) holds a symbol's trades and quotes for one
+// day in SIP timestamp order. Version 2 files start with a HeaderSize byte
+// header block:
+//
+//	0   magic "PGTQ"
+//	4   uint16 version
+//	6   uint32 length of the JSON header
+//	10  uint32 CRC-32C of the JSON header
+//	14  JSON Header, zero padded to HeaderSize
+//
+// The header says what the file holds (symbol, date, trade and quote counts,
+// time range, where and when it was downloaded), the size and CRC-32C of the
+// payload that follows, where its block index is and how the blocks are
+// compressed. The payload is blocks of up to BlockSize events, each a uint32
+// length and a gob encoded batch with trades and quotes in their own typed
+// records (TradeRecord, QuoteRecord) compressed with the header's codec: an
+// lz4 frame, a zstd frame (optionally with a dictionary, see AddDict) or
+// nothing at all. The blocks are followed by the index: 40 bytes per block with
+// its offset, length, trade and quote counts, CRC-32C and first and last SIP
+// timestamp. Blocks compress and decode on their own, so a reader looking
+// for a time range only reads the blocks that cover it. Readers check the
+// header and index before decoding anything; all integers are little endian.
+//
+// Version 1 files have no header: they're a bare lz4 frame holding a gob
+// stream of []TradesQuotesCombined batches (the oldest hold one batch with
+// the whole day). They read back the same way and `polygon convert` migrates
+// them.
+package tqfile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Ext - file extension of a trades + quotes file
+const Ext = ".gob.lz4"
+
+// Magic - first 4 bytes of a version 2 file
+const Magic = "PGTQ"
+
+// Version - file format version written by Writer
+const Version = 2
+
+// event types
+const (
+	Trade = "T"
+	Quote = "Q"
+)
+
+// TradesQuotesCombined - trades + quotes in one combined stream, the record
+// of version 1 files
+//
+// Trade fields are set for trades and quote fields for quotes, going by EV.
+// New code should use Event, Iter.Record still hands these out for code
+// written against version 1.
+type TradesQuotesCombined struct {
+	Sym string  // The ticker symbol for the given stock
+	EV  string  // The event type (T/Q)
+	T   int64   // The SIP Timestamp in Unix NS
+	TF  int64   // The nanosecond accuracy TRF(Trade Reporting Facility) Unix Timestamp. This is the timestamp of when the trade reporting facility received this message.
+	TQ  int     // The sequence number representing the sequence in which trade events happened. These are increasing and unique per ticker symbol, but will not always be sequential (e.g., 1, 2, 6, 9, 10, 11).
+	TY  int64   // The nanosecond accuracy Participant/Exchange Unix Timestamp. This is the timestamp of when the quote was actually generated at the exchange.
+	TE  int     // The trade correction indicator.
+	TI  string  // The trade ID // int64?
+	TP  float64 // Trade price
+	TS  int64   // Trade size
+	TC  []int   // Trade condition
+	TX  int     // Trade exchange ID
+	TR  int     // The ID for the Trade Reporting Facility where the trade took place.
+	TZ  int     // Trade tape. (1 = NYSE, 2 = AMEX, 3 = Nasdaq)
+	QF  int64   // The nanosecond accuracy TRF(Trade Reporting Facility) Unix Timestamp. This is the timestamp of when the trade reporting facility received this message.
+	QQ  int     // The sequence number represents the sequence in which message events happened. These are increasing and unique per ticker symbol, but will not always be sequential (e.g., 1, 2, 6, 9, 10, 11).
+	QY  int64   // The nanosecond accuracy Participant/Exchange Unix Timestamp. This is the timestamp of when the quote was actually generated at the exchange.
+	QI  []int   // The indicators. For more information, see our glossary of Conditions and Indicators.
+	BX  int     // The bid exchange ID
+	BP  float64 // The bid price
+	BS  int     // The bid size. This represents the number of round lot orders at the given bid price. The normal round lot size is 100 shares. A bid size of 2 means there are 200 shares for purchase at the given bid price
+	AX  int
+	AP  float64
+	AS  int
+	BSC []int // The condition
+	BSZ int   // The tape. (1 = NYSE, 2 = AMEX, 3 = Nasdaq)
+}
+
+// Time - the SIP timestamp as a time.Time (UTC)
+func (r *TradesQuotesCombined) Time() time.Time {
+	return time.Unix(0, r.T).UTC()
+}
+
+// FileName - <SYM>-<date>.gob.lz4
+func FileName(symbol, date string) string {
+	return symbol + "-" + date + Ext
+}
+
+// ParseFileName - symbol and date (YYYY-MM-DD) from a file name or path
+func ParseFileName(path string) (symbol, date string, ok bool) {
+	name := filepath.Base(path)
+	if !strings.HasSuffix(name, Ext) {
+		return "", "", false
+	}
+	name = strings.TrimSuffix(name, Ext)
+	if len(name) < len("X-2006-01-02") || name[len(name)-11] != '-' {
+		return "", "", false
+	}
+	date = name[len(name)-10:]
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		return "", "", false
+	}
+	return name[:len(name)-11], date, true
+}
+
+// HashFile - size and sha256 (hex) of the file at path, as the downloader's
+// manifest records them
+func HashFile(path string) (int64, string, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	n, err := io.Copy(hash, f)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return n, hex.EncodeToString(hash.Sum(nil)), nil
+}
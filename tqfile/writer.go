@@ -0,0 +1,237 @@
+package tqfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+// Writer - writes a version 2 file: the header block, blocks of up to
+// BlockSize events each compressed on its own (lz4 unless WithCodec says
+// otherwise), then the block index
+//
+// Events must be added in time order. The header block is written as a
+// placeholder up front and filled in by Close once the counts, time range,
+// index and payload checksum are known, so the destination has to be
+// seekable. Close doesn't close the underlying writer.
+type Writer struct {
+	w      io.WriteSeeker
+	start  int64 // offset of the header block
+	header Header
+	pw     *payloadWriter
+	codec  Codec
+	raw    bytes.Buffer // the block being written, before compression
+	buf    []byte       // and after
+	batch  batch
+	first  int64 // SIP timestamp of the batch's first event
+	index  []blockInfo
+	n      int
+}
+
+// WriterOption - configures a Writer
+type WriterOption func(*Writer)
+
+// WithCodec - compress blocks with c
+func WithCodec(c Codec) WriterOption {
+	return func(w *Writer) { w.codec = c }
+}
+
+// NewWriter - writer encoding onto w, starting at its current offset
+func NewWriter(w io.WriteSeeker, h Header, opts ...WriterOption) (*Writer, error) {
+
+	tw := &Writer{w: w, header: h, pw: &payloadWriter{w: w}, codec: defaultCodec}
+	for _, opt := range opts {
+		opt(tw)
+	}
+	tw.header.Trades, tw.header.Quotes, tw.header.MinT, tw.header.MaxT = 0, 0, 0, 0
+	tw.header.Codec, tw.header.ZstdDict = tw.codec.Name(), DictID(tw.codec)
+
+	var err error
+	if tw.start, err = w.Seek(0, io.SeekCurrent); err != nil {
+		return nil, err
+	}
+
+	// check the header fits now rather than after writing the whole file
+	block, err := encodeHeader(tw.header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(block); err != nil {
+		return nil, err
+	}
+
+	return tw, nil
+}
+
+// Write - add one event, writing a block when it's full
+func (w *Writer) Write(e Event) error {
+
+	if e.Trade == nil && e.Quote == nil {
+		return fmt.Errorf("event %v is neither a trade nor a quote", w.n)
+	}
+	t := e.T()
+	if w.n > 0 && t < w.header.MaxT {
+		return fmt.Errorf("event %v at %v is before the previous one at %v", w.n, t, w.header.MaxT)
+	}
+	if w.n == 0 {
+		w.header.MinT = t
+	}
+	w.header.MaxT = t
+	if len(w.batch.Kinds) == 0 {
+		w.first = t
+	}
+
+	switch {
+	case e.Trade != nil:
+		w.header.Trades++
+		w.batch.Kinds = append(w.batch.Kinds, Trade[0])
+		w.batch.Trades = append(w.batch.Trades, *e.Trade)
+	default:
+		w.header.Quotes++
+		w.batch.Kinds = append(w.batch.Kinds, Quote[0])
+		w.batch.Quotes = append(w.batch.Quotes, *e.Quote)
+	}
+	w.n++
+
+	if len(w.batch.Kinds) == BlockSize {
+		return w.Flush()
+	}
+	return nil
+}
+
+// WriteAll - add events in order
+func (w *Writer) WriteAll(events []Event) error {
+	for _, e := range events {
+		if err := w.Write(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush - write whatever is buffered as a block
+func (w *Writer) Flush() error {
+
+	if len(w.batch.Kinds) == 0 {
+		return nil
+	}
+
+	var err error
+	if w.buf, err = encodeBlock(w.buf, &w.raw, w.codec, &w.batch); err != nil {
+		return err
+	}
+	w.index = append(w.index, blockInfo{
+		Offset: HeaderSize + w.pw.n,
+		Length: int64(len(w.buf)),
+		Trades: len(w.batch.Trades),
+		Quotes: len(w.batch.Quotes),
+		CRC:    crc32.Checksum(w.buf, castagnoli),
+		FirstT: w.first,
+		LastT:  w.header.MaxT,
+	})
+	w.batch = batch{
+		Kinds:  w.batch.Kinds[:0],
+		Trades: w.batch.Trades[:0],
+		Quotes: w.batch.Quotes[:0],
+	}
+
+	_, err = w.pw.Write(w.buf)
+	return err
+}
+
+// Close - write whatever is buffered and the block index, and fill in the
+// header block
+func (w *Writer) Close() error {
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	index := encodeIndex(w.index)
+	w.header.Blocks = len(w.index)
+	w.header.IndexOffset = HeaderSize + w.pw.n
+	w.header.IndexCRC = crc32.Checksum(index, castagnoli)
+	if _, err := w.pw.Write(index); err != nil {
+		return err
+	}
+
+	w.header.PayloadBytes = w.pw.n
+	w.header.PayloadCRC = w.pw.crc
+	block, err := encodeHeader(w.header)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.w.Seek(w.start, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(block); err != nil {
+		return err
+	}
+	_, err = w.w.Seek(w.start+HeaderSize+w.pw.n, io.SeekStart)
+	return err
+}
+
+// Header - the header as written so far, complete once the writer is closed
+func (w *Writer) Header() Header {
+	return w.header
+}
+
+// Count - records written so far
+func (w *Writer) Count() int {
+	return w.n
+}
+
+// Verify - check that the file at path is complete and intact: the header
+// block and index are valid, the payload has the size and checksum the
+// header records and every block is a whole lz4 or zstd frame
+//
+// It reads the whole file but doesn't decode it.
+func Verify(path string) error {
+
+	r, err := Open(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if r.version != Version {
+		return fmt.Errorf("unsupported file version %v", r.version)
+	}
+
+	pr := &payloadReader{r: io.NewSectionReader(r.f, HeaderSize, r.header.PayloadBytes)}
+	if _, err := io.Copy(ioutil.Discard, pr); err != nil {
+		return err
+	}
+	if pr.crc != r.header.PayloadCRC {
+		return fmt.Errorf("payload checksum %08x, header says %08x", pr.crc, r.header.PayloadCRC)
+	}
+
+	// lz4: magic up front, end mark (4 zero bytes) + content checksum at
+	// the end; zstd: magic up front
+	head, foot := make([]byte, 4), make([]byte, 8)
+	for i, b := range r.index {
+		if _, err := r.f.ReadAt(head, b.Offset+4); err != nil {
+			return err
+		}
+		if _, err := r.f.ReadAt(foot, b.Offset+b.Length-8); err != nil {
+			return err
+		}
+		magic := binary.LittleEndian.Uint32(head)
+		switch r.codec.Name() {
+		case CodecLZ4:
+			if magic != lz4FrameMagic || !bytes.Equal(foot[:4], []byte{0, 0, 0, 0}) {
+				return fmt.Errorf("block %v isn't a whole lz4 frame", i)
+			}
+		case CodecZstd:
+			if magic != zstdFrameMagic {
+				return fmt.Errorf("block %v isn't a zstd frame", i)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package tqfile
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriterBlocks(t *testing.T) {
+
+	events := testEvents(BlockSize + 1)
+	path := filepath.Join(t.TempDir(), FileName("AAA", "2022-12-23"))
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	downloaded := time.Date(2022, 12, 24, 1, 0, 0, 0, time.UTC)
+	w, err := NewWriter(f, Header{Symbol: "AAA", Date: "2022-12-23", Trades: 5, MinT: 7, Source: "test", Downloaded: downloaded})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil || len(w.index) != 0 {
+		t.Fatalf("flushing nothing wrote %v blocks: %v", len(w.index), err)
+	}
+
+	// a block is written as soon as it's full
+	if err := w.WriteAll(events[:BlockSize]); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.index) != 1 || len(w.batch.Kinds) != 0 {
+		t.Errorf("after %v events: %v blocks, %v buffered", BlockSize, len(w.index), len(w.batch.Kinds))
+	}
+	if err := w.Write(events[BlockSize]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Count() != len(events) {
+		t.Errorf("count %v, want %v", w.Count(), len(events))
+	}
+
+	// the counts and time range come from the events, the rest from the caller
+	h := w.Header()
+	switch {
+	case h.Trades != 21846 || h.Quotes != 43691:
+		t.Errorf("header counts %v trades, %v quotes", h.Trades, h.Quotes)
+	case h.MinT != at(0) || h.MaxT != at(BlockSize):
+		t.Errorf("header time range %v to %v", h.MinT, h.MaxT)
+	case h.Blocks != 2 || h.Codec != CodecLZ4 || h.ZstdDict != 0:
+		t.Errorf("header %v blocks, codec %v", h.Blocks, h.Codec)
+	case h.Source != "test" || !h.Downloaded.Equal(downloaded):
+		t.Errorf("header lost the source: %+v", h)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != HeaderSize+h.PayloadBytes || h.IndexOffset+2*blockInfoSize != fi.Size() {
+		t.Errorf("file is %v bytes, header %+v", fi.Size(), h)
+	}
+
+	if err := Verify(path); err != nil {
+		t.Fatal(err)
+	}
+	r := openTestFile(t, path)
+	if got := r.Header(); got != h {
+		t.Errorf("header reads back as %+v\nwant %+v", got, h)
+	}
+	if r.index[1].FirstT != at(BlockSize) || r.index[1].Trades+r.index[1].Quotes != 1 {
+		t.Errorf("last block %+v", r.index[1])
+	}
+	if got := readEvents(t, r.Iter(Filter{})); !reflect.DeepEqual(got, events) {
+		t.Error("events don't read back as written")
+	}
+}
+
+func TestWriterRejects(t *testing.T) {
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// the header has to fit before anything is written
+	if _, err := NewWriter(f, Header{Source: strings.Repeat("x", HeaderSize)}); err == nil {
+		t.Error("wrote a header too big for its block")
+	}
+
+	w, err := NewWriter(f, Header{Symbol: "AAA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := testEvents(3)
+	if err := w.WriteAll(events[1:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(events[0]); err == nil || !strings.Contains(err.Error(), "before the previous one") {
+		t.Errorf("out of order event: %v", err)
+	}
+	if err := w.Write(Event{}); err == nil || !strings.Contains(err.Error(), "neither a trade nor a quote") {
+		t.Errorf("empty event: %v", err)
+	}
+
+	// events at the same time are fine
+	if err := w.Write(events[2]); err != nil {
+		t.Error(err)
+	}
+	if w.Count() != 3 {
+		t.Errorf("count %v after rejected events, want 3", w.Count())
+	}
+}